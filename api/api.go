@@ -3,13 +3,17 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 
+	"gopgrest/apperrors"
+	"gopgrest/changefeed"
 	"gopgrest/repatterns"
 	"gopgrest/repository"
 	"gopgrest/service"
@@ -19,12 +23,20 @@ import (
 type APIHandler struct {
 	Service service.Service
 	Repo    repository.Repository
+	Changes *changefeed.Broker // nil if the change feed is not enabled
+	// Origins, other than the server's own, allowed to open WebSockets
+	AllowedOrigins []string
 }
 
 type headers map[string]string
 
-func NewAPIHandler(db repository.QueryExecutor, tables []repository.Table) APIHandler {
-	repo := repository.NewRepository(db, tables)
+func NewAPIHandler(
+	db repository.QueryExecutor,
+	tables []repository.Table,
+	functions []repository.Function,
+	schemas []string,
+) APIHandler {
+	repo := repository.NewRepository(db, tables, functions, schemas)
 	service := service.NewService(repo)
 	return APIHandler{
 		Service: service,
@@ -32,19 +44,79 @@ func NewAPIHandler(db repository.QueryExecutor, tables []repository.Table) APIHa
 	}
 }
 
-// ServeHTTP routes the request by method and path, where the path begins with
-// an existing table name
+// ServeHTTP scopes the request to its schema and routes it by method and
+// path, where the path begins with an existing table name
 func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Println(r.Method, r.URL, r.RemoteAddr)
+	scoped, err := h.withRequestSchema(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	scoped.route(w, r)
+}
+
+// withRequestSchema returns a copy of the handler scoped to the schema
+// selected by the request, either with a `/{schema}/{table}` path or with an
+// Accept-Profile (GET) or Content-Profile (POST, PUT, DELETE) header. The
+// schema in the path takes precedence and is stripped from the request's
+// path. Requests that don't select a schema use the default schema
+func (h *APIHandler) withRequestSchema(r *http.Request) (*APIHandler, error) {
+	schema := r.Header.Get("Content-Profile")
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		schema = r.Header.Get("Accept-Profile")
+	}
+	matches := repatterns.ReqSchemaPrefix.FindStringSubmatch(r.URL.Path)
+	if matches != nil && slices.Contains(h.Repo.Schemas, matches[1]) {
+		schema = matches[1]
+		r.URL.Path = "/" + strings.TrimPrefix(matches[2], "/")
+		r.URL.RawPath = ""
+	}
+	if schema == "" {
+		return h, nil
+	}
+
+	repo, err := h.Repo.WithSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	scoped := *h
+	scoped.Repo = repo
+	scoped.Service.Repo = repo
+	return &scoped, nil
+}
+
+// route routes the request by method and path
+func (h *APIHandler) route(w http.ResponseWriter, r *http.Request) {
 	// Simpler to early return here if we're stripping trailing `/`
 	if r.Method == http.MethodGet && r.URL.Path == "/" {
 		h.showTables(w)
 		return
 	}
+	if r.Method == http.MethodGet && repatterns.ReqSubscribe.MatchString(r.URL.Path) {
+		h.subscribeChanges(w, r)
+		return
+	}
+	if r.Method == http.MethodGet && repatterns.ReqChanges.MatchString(r.URL.Path) {
+		h.streamChanges(w, r)
+		return
+	}
+	if repatterns.ReqRefresh.MatchString(r.URL.Path) {
+		h.refreshMaterializedView(w, r)
+		return
+	}
+	if repatterns.ReqRPC.MatchString(r.URL.Path) {
+		h.callFunction(w, r)
+		return
+	}
+	if repatterns.ReqSavedQuery.MatchString(r.URL.Path) {
+		h.getSavedQuery(w, r)
+		return
+	}
 
 	// Standardize URL
 	var err error
-	r.URL, err = url.Parse(stripTrailingChars(r.URL.String()))
+	r.URL, err = url.Parse(stripTrailingChars(requestTarget(r.URL)))
 	if err != nil {
 		writeResponse(w, http.StatusInternalServerError, nil, []byte(err.Error()))
 		return
@@ -54,11 +126,6 @@ func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusInternalServerError, nil, []byte(err.Error()))
 		return
 	}
-	r.URL, err = url.Parse(decodeURL(r.URL.String()))
-	if err != nil {
-		writeResponse(w, http.StatusInternalServerError, nil, []byte(err.Error()))
-		return
-	}
 
 	// Route request
 	switch r.Method {
@@ -76,15 +143,15 @@ func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *APIHandler) getRows(w http.ResponseWriter, r *http.Request) {
-	table, err := parseOptionalParamsRequest(r.URL.String())
+	table, err := parseOptionalParamsRequest(requestTarget(r.URL))
 	if err != nil {
 		writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
 	}
 
 	// Retrieve gotRows from database
-	gotRows, err := h.Service.GetRowsByRSQL(table, r.URL.String())
+	gotRows, err := h.Service.GetRowsByRSQL(table, requestTarget(r.URL))
 	if err != nil {
-		writeResponse(w, http.StatusInternalServerError, nil, []byte(err.Error()))
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -101,7 +168,7 @@ func (h *APIHandler) getRows(w http.ResponseWriter, r *http.Request) {
 
 // insertRows adds a row to a table
 func (h *APIHandler) insertRows(w http.ResponseWriter, r *http.Request) {
-	table, err := parseOptionalParamsRequest(r.URL.String())
+	table, err := parseOptionalParamsRequest(requestTarget(r.URL))
 	if err != nil {
 		writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
 	}
@@ -114,7 +181,7 @@ func (h *APIHandler) insertRows(w http.ResponseWriter, r *http.Request) {
 
 	var newRows []types.RowData
 	// Try decoding an array of JSON objects
-	if err = json.NewDecoder(r.Body).Decode(&newRows); err != nil {
+	if err = newBodyDecoder(r.Body).Decode(&newRows); err != nil {
 
 		// The request may not have been an array of JSON objects
 		// Try decoding a single JSON object
@@ -124,7 +191,7 @@ func (h *APIHandler) insertRows(w http.ResponseWriter, r *http.Request) {
 		r.Body.Close()
 		var singleRow types.RowData
 
-		if err = json.NewDecoder(r.Body).Decode(&singleRow); err != nil {
+		if err = newBodyDecoder(r.Body).Decode(&singleRow); err != nil {
 
 			// If we fail again, it was malformed JSON/JSON array
 			writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
@@ -137,11 +204,18 @@ func (h *APIHandler) insertRows(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Columns missing from some rows are set to their DEFAULT unless the
+	// client prefers null. Unknown preferences are ignored
+	missing := preference(r, "missing")
+	if missing != service.MISSING_DEFAULT && missing != service.MISSING_NULL {
+		missing = ""
+	}
+
 	// Insert new rows into the database
-	newIDs, err := h.Service.InsertRows(newRows, table)
+	newIDs, err := h.Service.InsertRows(newRows, table, missing)
 	if err != nil {
 		log.Println(err)
-		writeResponse(w, http.StatusInternalServerError, nil, []byte(err.Error()))
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -152,27 +226,30 @@ func (h *APIHandler) insertRows(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	headers := headers{"Content-Type": "application/json"}
+	if missing != "" {
+		headers["Preference-Applied"] = "missing=" + missing
+	}
 	writeResponse(w, http.StatusOK, headers, jsonData)
 }
 
 func (h *APIHandler) updateRows(w http.ResponseWriter, r *http.Request) {
-	tableName, err := parseOptionalParamsRequest(r.URL.String())
+	tableName, err := parseOptionalParamsRequest(requestTarget(r.URL))
 	if err != nil {
 		writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
 	}
 
 	// Decode request body into map to dynamically update row
 	var updateData *types.RowData
-	err = json.NewDecoder(r.Body).Decode(&updateData)
+	err = newBodyDecoder(r.Body).Decode(&updateData)
 	if err != nil {
 		writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
 		return
 	}
 
 	// Update row with request data
-	updatedIDs, err := h.Service.UpdateRowsByRSQL(tableName, r.URL.String(), updateData)
+	updatedIDs, err := h.Service.UpdateRowsByRSQL(tableName, requestTarget(r.URL), updateData)
 	if err != nil {
-		writeResponse(w, http.StatusInternalServerError, nil, []byte(err.Error()))
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -188,15 +265,15 @@ func (h *APIHandler) updateRows(w http.ResponseWriter, r *http.Request) {
 
 func (h *APIHandler) deleteRows(w http.ResponseWriter, r *http.Request) {
 	// Get table from URL path
-	tableName, err := parseOptionalParamsRequest(r.URL.String())
+	tableName, err := parseOptionalParamsRequest(requestTarget(r.URL))
 	if err != nil {
 		writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
 	}
 
 	// Delete rows by rsql conditions
-	deletedIDs, err := h.Service.DeleteRowsByRSQL(tableName, r.URL.String())
+	deletedIDs, err := h.Service.DeleteRowsByRSQL(tableName, requestTarget(r.URL))
 	if err != nil {
-		writeResponse(w, http.StatusInternalServerError, nil, []byte(err.Error()))
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -210,6 +287,24 @@ func (h *APIHandler) deleteRows(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, http.StatusOK, headers, jsonData)
 }
 
+// refreshMaterializedView handles `POST /{matview}/_refresh`, refreshing the
+// view concurrently if the `concurrently=true` query param is set
+func (h *APIHandler) refreshMaterializedView(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, headers{"Allow": http.MethodPost}, nil)
+		return
+	}
+	tableName := repatterns.ReqRefresh.FindStringSubmatch(r.URL.Path)[1]
+	concurrently := r.URL.Query().Get("concurrently") == "true"
+
+	err := h.Service.RefreshMaterializedView(tableName, concurrently)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeResponse(w, http.StatusNoContent, nil, nil)
+}
+
 // showTables responds with a JSON object of the tables, their column names,
 // and column types
 func (h *APIHandler) showTables(w http.ResponseWriter) {
@@ -233,7 +328,7 @@ func notFoundHandler(w http.ResponseWriter) {
 // If the URL didn't have an ID resource, returns the URL as is.
 func coerceURLToQueryParams(r *http.Request) error {
 	// If it's already in an optional-params format, return as is
-	if repatterns.ReqOptionalParams.MatchString(r.URL.String()) || r.URL.Path == "/" {
+	if repatterns.ReqOptionalParams.MatchString(requestTarget(r.URL)) || r.URL.Path == "/" {
 		return nil
 	}
 	matches := repatterns.ReqWithId.FindStringSubmatch(r.URL.Path)
@@ -269,6 +364,83 @@ func parseOptionalParamsRequest(url string) (string, error) {
 	return matches[1], nil
 }
 
+// newBodyDecoder returns a decoder for rows in a request body that keeps
+// numbers as json.Number, so that e.g. numeric values keep their precision
+func newBodyDecoder(body io.Reader) *json.Decoder {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	return decoder
+}
+
+// preference gets the value of a preference in the request's Prefer headers,
+// e.g. `null` for `Prefer: missing=null`, or an empty string if it wasn't set
+func preference(r *http.Request, name string) string {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			pref, _, _ = strings.Cut(pref, ";")
+			k, v, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if strings.EqualFold(k, name) {
+				return strings.Trim(v, `"`)
+			}
+		}
+	}
+	return ""
+}
+
+// writeError responds with the status code for known app errors, or with
+// statusCode for any other error
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	var validationErr *apperrors.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		// Report every invalid field so clients can fix them all at once
+		body, _ := json.Marshal(map[string]any{
+			"message": apperrors.InvalidRequestBody.Error(),
+			"errors":  validationErr.Fields,
+		})
+		headers := headers{"Content-Type": "application/json"}
+		writeResponse(w, http.StatusUnprocessableEntity, headers, body)
+	case errors.Is(err, apperrors.TableIsReadOnly):
+		// Read-only tables are still available to GET
+		headers := headers{"Allow": http.MethodGet}
+		writeResponse(w, http.StatusMethodNotAllowed, headers, []byte(err.Error()))
+	case errors.Is(err, apperrors.TableIsNotInsertable):
+		headers := headers{"Allow": strings.Join([]string{http.MethodGet, http.MethodPut, http.MethodDelete}, ", ")}
+		writeResponse(w, http.StatusMethodNotAllowed, headers, []byte(err.Error()))
+	case errors.Is(err, apperrors.TableIsNotUpdatable):
+		headers := headers{"Allow": strings.Join([]string{http.MethodGet, http.MethodPost}, ", ")}
+		writeResponse(w, http.StatusMethodNotAllowed, headers, []byte(err.Error()))
+	case errors.Is(err, apperrors.FunctionIsNotReadOnly):
+		headers := headers{"Allow": http.MethodPost}
+		writeResponse(w, http.StatusMethodNotAllowed, headers, []byte(err.Error()))
+	case errors.Is(err, apperrors.FunctionDoesNotExist),
+		errors.Is(err, apperrors.SavedQueryDoesNotExist):
+		writeResponse(w, http.StatusNotFound, nil, []byte(err.Error()))
+	case errors.Is(err, apperrors.SchemaNotExposed):
+		writeResponse(w, http.StatusNotAcceptable, nil, []byte(err.Error()))
+	case errors.Is(err, apperrors.NotMaterializedView),
+		errors.Is(err, apperrors.InvalidFunctionArgs),
+		errors.Is(err, apperrors.InvalidFunctionRSQL),
+		errors.Is(err, apperrors.InvalidSavedQueryParams),
+		errors.Is(err, apperrors.ColDoesNotExist),
+		errors.Is(err, apperrors.InvalidConditionValue),
+		errors.Is(err, apperrors.InvalidCast),
+		errors.Is(err, apperrors.InvalidJSONPath),
+		errors.Is(err, apperrors.InvalidJSONOperator),
+		errors.Is(err, apperrors.InvalidArrayOperator),
+		errors.Is(err, apperrors.InvalidTextSearch),
+		errors.Is(err, apperrors.InvalidJoin),
+		errors.Is(err, apperrors.InvalidDistinct),
+		errors.Is(err, apperrors.InvalidSubquery),
+		errors.Is(err, apperrors.InvalidChangeFilter),
+		errors.Is(err, apperrors.RankWithoutTextSearch),
+		errors.Is(err, apperrors.CheckViolation):
+		writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
+	default:
+		writeResponse(w, statusCode, nil, []byte(err.Error()))
+	}
+}
+
 // writeResponse writes headers and data
 func writeResponse(w http.ResponseWriter, statusCode int, headers headers, data []byte) {
 	for k, v := range headers {
@@ -278,34 +450,14 @@ func writeResponse(w http.ResponseWriter, statusCode int, headers headers, data
 	w.Write(data)
 }
 
-// decodeURL decodes any percent-encoded characters as defined here:
-// https://developer.mozilla.org/en-US/docs/Glossary/Percent-encoding
-func decodeURL(url string) string {
-	replacer := strings.NewReplacer(
-		"%3A", ":",
-		"%2F", "/",
-		"%3F", "?",
-		"%23", "#",
-		"%5B", "[",
-		"%5D", "]",
-		"%40", "@",
-		"%21", "!",
-		"%24", "$",
-		"%26", "&",
-		"%27", "'",
-		"%28", "(",
-		"%29", ")",
-		"%2A", "*",
-		"%2B", "+",
-		"%2C", ",",
-		"%3B", ";",
-		"%3D", "=",
-		"%25", "%",
-		// Recognizing both %20 and + for ' '. Use "%2B" if '+' in the url
-		"%20", " ",
-		"+", " ",
-	)
-	return replacer.Replace(url)
+// requestTarget returns a URL's path and query, e.g. `/authors?select=surname`.
+// Unlike URL.String, the path is not percent-encoded, so that non-ASCII table
+// names are routed as they are
+func requestTarget(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	return u.Path + "?" + u.RawQuery
 }
 
 func stripTrailingChars(url string) string {
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopgrest/changefeed"
+	"gopgrest/repatterns"
+)
+
+// HEARTBEAT_INTERVAL is how often a comment is sent on an idle change stream
+// to keep proxies from closing the connection
+const HEARTBEAT_INTERVAL = 15 * time.Second
+
+// streamChanges responds to `GET /{table}/_changes` with a Server-Sent Events
+// stream of the inserts, updates and deletes on the table, optionally
+// filtered by a `where` clause. A client reconnecting with a Last-Event-ID
+// header is sent the buffered events it missed first
+func (h *APIHandler) streamChanges(w http.ResponseWriter, r *http.Request) {
+	if h.Changes == nil {
+		writeResponse(w, http.StatusNotImplemented, nil, []byte("change feed is not enabled"))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeResponse(w, http.StatusInternalServerError, nil, []byte("streaming not supported"))
+		return
+	}
+
+	tableName := repatterns.ReqChanges.FindStringSubmatch(r.URL.Path)[1]
+
+	// Parse optional `where` filter as if it were a GET request on the table
+	filterURL := "/" + tableName
+	if r.URL.RawQuery != "" {
+		filterURL = fmt.Sprintf("/%s?%s", tableName, r.URL.RawQuery)
+	}
+	conditions, err := h.Service.GetChangeFilter(tableName, filterURL)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
+		return
+	}
+	filter, err := changefeed.NewFilter(conditions)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
+		return
+	}
+
+	var lastID int64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		lastID, err = strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
+			return
+		}
+	}
+
+	sub, replay := h.Changes.Subscribe(h.Repo.Schema, tableName, lastID)
+	defer h.Changes.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, e := range replay {
+		if filter.Matches(e.Row) {
+			writeEvent(w, e)
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case e, ok := <-sub.C:
+			// The broker closes the channel if the client falls behind. Ending
+			// the response lets it reconnect and resume from Last-Event-ID
+			if !ok {
+				return
+			}
+			if filter.Matches(e.Row) {
+				writeEvent(w, e)
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// writeEvent writes a change event in the SSE wire format
+func writeEvent(w http.ResponseWriter, e changefeed.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Could not encode change event %d: %s\n", e.ID, err)
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Op, data)
+}
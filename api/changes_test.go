@@ -0,0 +1,93 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopgrest/assert"
+	"gopgrest/changefeed"
+	"gopgrest/tests"
+)
+
+func Test_GET_Changes_NotEnabled(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+	rr, err := tests.MakeHttpRequest(ah, http.MethodGet, "/authors/_changes", nil)
+	assert.Try(t, err)
+	assert.IsEq(t, rr.Code, http.StatusNotImplemented)
+}
+
+func Test_GET_Changes_BadFilter(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+	ah.Changes = changefeed.NewBroker(changefeed.BUFFER_SIZE)
+
+	t.Run("column not in table", func(t *testing.T) {
+		rr, err := tests.MakeHttpRequest(ah, http.MethodGet, "/authors/_changes?where=title==Mrs.+Dalloway", nil)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("malformed Last-Event-ID", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/authors/_changes", nil)
+		assert.Try(t, err)
+		req.Header.Set("Last-Event-ID", "latest")
+		rr := httptest.NewRecorder()
+		ah.ServeHTTP(rr, req)
+		assert.IsEq(t, rr.Code, http.StatusBadRequest)
+	})
+}
+
+func Test_WebSocket_OtherOrigin(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+	ah.Changes = changefeed.NewBroker(changefeed.BUFFER_SIZE)
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/_changes", nil)
+	assert.Try(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "http://evil.example")
+	rr := httptest.NewRecorder()
+	ah.ServeHTTP(rr, req)
+	assert.IsEq(t, rr.Code, http.StatusForbidden)
+}
+
+func Test_WebSocket_Subscriptions(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+	ah.Changes = changefeed.NewBroker(changefeed.BUFFER_SIZE)
+	server := httptest.NewServer(&ah)
+	defer server.Close()
+
+	conn, reader := tests.DialWebSocket(t, server.URL, "/_changes")
+	send := func(msg string) {
+		tests.WriteWebSocketFrame(t, conn, 0x1, []byte(msg))
+	}
+	receive := func() map[string]any {
+		msg := map[string]any{}
+		err := json.Unmarshal(tests.ReadWebSocketFrame(t, reader), &msg)
+		assert.Try(t, err)
+		return msg
+	}
+
+	send(`{"type": "subscribe", "id": "bad", "table": "authors", "where": "title==Orlando"}`)
+	msg := receive()
+	assert.IsEq(t, msg["type"], "error")
+	assert.IsEq(t, msg["id"], "bad")
+
+	send(`{"type": "subscribe", "id": "woolf", "table": "authors", "where": "surname==Woolf"}`)
+	assert.IsEq(t, receive()["type"], "subscribed")
+
+	// Changes that don't match the filter are not sent
+	assert.Try(t, ah.Changes.Publish(`{"schema": "public", "table": "authors", "op": "update", "pk": 1}`))
+	assert.Try(t, ah.Changes.Publish(`{"schema": "public", "table": "authors", "op": "update", "pk": 3}`))
+	msg = receive()
+	assert.IsEq(t, msg["type"], "update")
+	assert.IsEq(t, msg["id"], "woolf")
+	// The full row is fetched from the database
+	row := msg["row"].(map[string]any)
+	assert.IsEq(t, row["forename"], "Virginia")
+
+	send(`{"type": "unsubscribe", "id": "woolf"}`)
+	assert.IsEq(t, receive()["type"], "unsubscribed")
+}
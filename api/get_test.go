@@ -122,6 +122,27 @@ func Test_GET_Rows_RSQL_INNER_JOIN(t *testing.T) {
 		url := "/books?select=title,name:genre&right_join=genres:books.genre_id==genres.id"
 		apiGetRowsTester(t, rawQuery, url)
 	})
+
+	t.Run("aliased self JOIN", func(t *testing.T) {
+		rawQuery := "SELECT authors.surname, peer.surname AS peer FROM authors JOIN authors AS peer ON authors.forename = peer.forename AND peer.born > 1900"
+		url := "/authors?select=authors.surname,peer.surname:peer&join=authors@peer:authors.forename==peer.forename;peer.born>1900"
+		apiGetRowsTester(t, rawQuery, url)
+	})
+}
+
+func Test_GET_Rows_RSQL_OrderBy(t *testing.T) {
+	t.Run("single column", func(t *testing.T) {
+		rawQuery := "SELECT * FROM authors ORDER BY born"
+		apiGetRowsTester(t, rawQuery, "/authors?order_by=born")
+	})
+	t.Run("multiple columns with directions", func(t *testing.T) {
+		rawQuery := "SELECT * FROM authors ORDER BY forename DESC, born ASC"
+		apiGetRowsTester(t, rawQuery, "/authors?order_by=forename:desc,born:asc")
+	})
+	t.Run("select alias", func(t *testing.T) {
+		rawQuery := "SELECT surname AS name FROM authors ORDER BY name DESC"
+		apiGetRowsTester(t, rawQuery, "/authors?select=surname:name&order_by=name:desc")
+	})
 }
 
 func Test_GET_Rows_RSQL_LIMIT(t *testing.T) {
@@ -213,3 +234,257 @@ func unmarshal(t *testing.T, body []byte, dest *[]types.RowData) {
 		}
 	}
 }
+
+func Test_GET_Rows_RSQL_Types(t *testing.T) {
+	cases := []struct {
+		name     string
+		rawQuery string
+		url      string
+	}{
+		{"boolean", "SELECT id FROM editions WHERE in_print = true", "/editions?select=id&where=in_print==true"},
+		{"date", "SELECT id FROM editions WHERE published < '1950-01-01'", "/editions?select=id&where=published<1950-01-01"},
+		{"numeric", "SELECT id FROM editions WHERE price >= 10", "/editions?select=id&where=price>=10.00"},
+		{"timestamptz", "SELECT id FROM editions WHERE added > '2024-01-31'", "/editions?select=id&where=added>2024-01-31T00:00:00Z"},
+		{"uuid", "SELECT id FROM editions WHERE ref = 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11'", "/editions?select=id&where=ref==a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{"array", "SELECT id FROM editions WHERE formats = '{hardcover}'", "/editions?select=id&where=formats==hardcover"},
+		{"cast", "SELECT id FROM editions WHERE published::text LIKE '192%'", "/editions?select=id&where=published::text=like=192%25"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			apiGetRowsTester(t, c.rawQuery, c.url)
+		})
+	}
+}
+
+func Test_GET_Rows_RSQL_InvalidTypes(t *testing.T) {
+	cases := []struct {
+		name string
+		url  string
+	}{
+		{"boolean", "/editions?where=in_print==maybe"},
+		{"date", "/editions?where=published<last+year"},
+		{"integer", "/authors?where=born>=eighteen"},
+		{"unknown cast", "/editions?where=price::money==15"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ah := tests.NewTestAPIHandler(t)
+			rr, err := tests.MakeHttpRequest(ah, http.MethodGet, c.url, nil)
+			assert.Try(t, err)
+			assert.IsEq(t, rr.Code, http.StatusBadRequest)
+		})
+	}
+}
+
+// Columns must be in one of the query's tables, not just anywhere in the
+// schema
+func Test_GET_Rows_RSQL_ColumnNotInQuery(t *testing.T) {
+	cases := []struct {
+		name string
+		url  string
+	}{
+		{"order_by", "/authors?order_by=genre_id"},
+		{"computed column", "/authors?select=title||surname:x"},
+		{"window partition", "/authors?select=row_number()over(partition_by=genre_id):n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ah := tests.NewTestAPIHandler(t)
+			rr, err := tests.MakeHttpRequest(ah, http.MethodGet, c.url, nil)
+			assert.Try(t, err)
+			assert.IsEq(t, rr.Code, http.StatusBadRequest)
+		})
+	}
+}
+
+func Test_GET_Rows_RSQL_JSONPaths(t *testing.T) {
+	cases := []struct {
+		name     string
+		rawQuery string
+		url      string
+	}{
+		{"key as text", "SELECT id FROM editions WHERE meta->'publisher'->>'city' = 'London'", "/editions?select=id&where=meta->publisher->>city==London"},
+		{"dot separated keys", "SELECT id FROM editions WHERE meta->'publisher'->>'city' = 'London'", "/editions?select=id&where=meta.publisher.city==London"},
+		{"array index", "SELECT id FROM editions WHERE meta->'tags'->>1 = 'poetry'", "/editions?select=id&where=meta->tags->>1==poetry"},
+		{"cast", "SELECT id FROM editions WHERE (meta->>'pages')::int > 250", "/editions?select=id&where=meta->>pages::int>250"},
+		{"json value", "SELECT id FROM editions WHERE meta->'pages' = '209'", "/editions?select=id&where=meta->pages==209"},
+		{"order by", "SELECT id FROM editions ORDER BY meta->'pages' DESC", "/editions?select=id&order_by=meta->pages:desc"},
+		{"contains", `SELECT id FROM editions WHERE meta @> '{"tags": ["poetry"]}'`, "/editions?select=id&where=meta=contains=%7B%22tags%22:%5B%22poetry%22%5D%7D"},
+		{"has key", "SELECT id FROM editions WHERE meta ? 'publisher'", "/editions?select=id&where=meta=haskey=publisher"},
+		{"jsonpath", "SELECT id FROM editions WHERE meta @? '$.pages ? (@ > 250)'", "/editions?select=id&where=meta=jsonpath=$.pages%20%3F%20(@%20%3E%20250)"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			apiGetRowsTester(t, c.rawQuery, c.url)
+		})
+	}
+}
+
+func Test_GET_Rows_RSQL_InvalidJSONPaths(t *testing.T) {
+	cases := []struct {
+		name string
+		url  string
+	}{
+		{"path on non-json column", "/editions?where=price->amount==15"},
+		{"select path on non-json column", "/authors?select=surname->>0"},
+		{"contains on text", "/editions?where=meta->>tags=contains=%5B%5D"},
+		{"invalid json document", "/editions?where=meta=contains=%7Btags"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ah := tests.NewTestAPIHandler(t)
+			rr, err := tests.MakeHttpRequest(ah, http.MethodGet, c.url, nil)
+			assert.Try(t, err)
+			assert.IsEq(t, rr.Code, http.StatusBadRequest)
+		})
+	}
+}
+
+func Test_GET_Rows_RSQL_Comparisons(t *testing.T) {
+	cases := []struct {
+		name     string
+		rawQuery string
+		url      string
+	}{
+		{"ilike", "SELECT id FROM authors WHERE surname ILIKE 'w%'", "/authors?select=id&where=surname=ilike=w%25"},
+		{"not ilike", "SELECT id FROM authors WHERE surname NOT ILIKE 'w%'", "/authors?select=id&where=surname=!ilike=w%25"},
+		{"between", "SELECT id FROM authors WHERE born BETWEEN 1800 AND 1900", "/authors?select=id&where=born=between=1800,1900"},
+		{"not between", "SELECT id FROM authors WHERE born NOT BETWEEN 1800 AND 1900", "/authors?select=id&where=born=!between=1800,1900"},
+		{"match", "SELECT id FROM authors WHERE surname ~ '^[BC]'", "/authors?select=id&where=surname=match=^[BC]"},
+		{"imatch", "SELECT id FROM authors WHERE surname ~* '^b'", "/authors?select=id&where=surname=imatch=^b"},
+		{"is distinct from", "SELECT id FROM authors WHERE died IS DISTINCT FROM 1941", "/authors?select=id&where=died=isdistinct=1941"},
+		{"starts with", "SELECT id FROM books WHERE title LIKE 'To %'", "/books?select=id&where=title=startswith=To%20"},
+		{"ends with", "SELECT id FROM books WHERE title LIKE '%Hall'", "/books?select=id&where=title=endswith=Hall"},
+		{"escaped wildcard", `SELECT id FROM books WHERE title LIKE '\%%'`, "/books?select=id&where=title=startswith=%25"},
+		{"quoted values", "SELECT id FROM books WHERE title IN ('Mrs Dalloway', 'Autobiography; of Red, a novel')", "/books?select=id&where=title=in=%22Mrs%20Dalloway%22,%22Autobiography;%20of%20Red,%20a%20novel%22"},
+		{"encoded ampersand", "SELECT id FROM books WHERE title = 'Pride & Prejudice'", "/books?select=id&where=title=='Pride%20%26%20Prejudice'"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			apiGetRowsTester(t, c.rawQuery, c.url)
+		})
+	}
+}
+
+func Test_GET_Rows_RSQL_Arrays(t *testing.T) {
+	cases := []struct {
+		name     string
+		rawQuery string
+		url      string
+	}{
+		{"contains", "SELECT id FROM editions WHERE formats @> '{paperback}'", "/editions?select=id&where=formats=contains=paperback"},
+		{"contained by", "SELECT id FROM editions WHERE formats <@ '{paperback,hardcover}'", "/editions?select=id&where=formats=containedby=paperback,hardcover"},
+		{"overlaps literal", "SELECT id FROM editions WHERE formats && '{ebook,hardcover}'", "/editions?select=id&where=formats=overlaps=%7Bebook,hardcover%7D"},
+		{"any", "SELECT id FROM editions WHERE 'hardcover' = ANY(formats)", "/editions?select=id&where=formats=any=hardcover"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			apiGetRowsTester(t, c.rawQuery, c.url)
+		})
+	}
+
+	t.Run("non-array column", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodGet, "/editions?where=price=overlaps=1,2", nil)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusBadRequest)
+	})
+}
+
+func Test_GET_Rows_RSQL_FullTextSearch(t *testing.T) {
+	cases := []struct {
+		name     string
+		rawQuery string
+		url      string
+	}{
+		{"to_tsquery", "SELECT id FROM books WHERE to_tsvector(title) @@ to_tsquery('lighthouse')", "/books?select=id&where=title=fts=lighthouse"},
+		{"config", "SELECT id FROM books WHERE to_tsvector('english', title) @@ to_tsquery('english', 'lighthouses')", "/books?select=id&where=title=fts(english)=lighthouses"},
+		{"plainto_tsquery", "SELECT id FROM books WHERE to_tsvector(title) @@ plainto_tsquery('wildfell hall')", "/books?select=id&where=title=plfts=wildfell%20hall"},
+		{"phraseto_tsquery", "SELECT id FROM books WHERE to_tsvector('english', title) @@ phraseto_tsquery('english', 'to the lighthouse')", "/books?select=id&where=title=phfts(english)=to%20the%20lighthouse"},
+		{"websearch_to_tsquery", "SELECT id FROM books WHERE to_tsvector(title) @@ websearch_to_tsquery('red or dalloway')", "/books?select=id&where=title=wfts=red%20or%20dalloway"},
+		{"json", "SELECT id FROM editions WHERE to_tsvector(meta->'tags') @@ to_tsquery('poetry')", "/editions?select=id&where=meta->tags=fts=poetry"},
+		{
+			"rank",
+			"SELECT id FROM books WHERE to_tsvector(title) @@ websearch_to_tsquery('red or dalloway') " +
+				"ORDER BY ts_rank(to_tsvector(title), websearch_to_tsquery('red or dalloway')) DESC",
+			"/books?select=id&where=title=wfts=red%20or%20dalloway&order_by=rank:desc",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			apiGetRowsTester(t, c.rawQuery, c.url)
+		})
+	}
+}
+
+func Test_GET_Rows_RSQL_InvalidFullTextSearch(t *testing.T) {
+	cases := []struct {
+		name string
+		url  string
+	}{
+		{"integer column", "/authors?where=born=fts=1900"},
+		{"rank without search", "/books?order_by=rank"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ah := tests.NewTestAPIHandler(t)
+			rr, err := tests.MakeHttpRequest(ah, http.MethodGet, c.url, nil)
+			assert.Try(t, err)
+			assert.IsEq(t, rr.Code, http.StatusBadRequest)
+		})
+	}
+}
+
+func Test_GET_Rows_RSQL_Filter(t *testing.T) {
+	cases := []struct {
+		name     string
+		rawQuery string
+		url      string
+	}{
+		{"or", "SELECT id FROM authors WHERE surname = 'Woolf' OR born > 1900", "/authors?select=id&filter=surname==Woolf,born=gt=1900"},
+		{"and binds tighter", "SELECT id FROM authors WHERE surname = 'Woolf' OR (born > 1800 AND died < 1900)", "/authors?select=id&filter=surname==Woolf,born=gt=1800;died=lt=1900"},
+		{"group", "SELECT id FROM authors WHERE (surname = 'Woolf' OR born > 1800) AND died < 1900", "/authors?select=id&filter=(surname==Woolf,born=gt=1800);died=lt=1900"},
+		{"in list", "SELECT id FROM authors WHERE forename IN ('Ann', 'Anne') OR surname = 'Carson'", "/authors?select=id&filter=forename=in=(Ann,Anne),surname==Carson"},
+		{"quoted", "SELECT id FROM books WHERE title = 'Mrs. Dalloway' OR title = 'Autobiography of Red'", "/books?select=id&filter=title=='Mrs.%20Dalloway',title==%22Autobiography%20of%20Red%22"},
+		{"with where", "SELECT id FROM authors WHERE born < 1900 AND (surname = 'Woolf' OR surname = 'Brontë')", "/authors?select=id&where=born<1900&filter=surname==Woolf,surname==Brontë"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			apiGetRowsTester(t, c.rawQuery, c.url)
+		})
+	}
+}
+
+// Test_GET_Rows_JSONPathSelect tests that selected paths are named after their
+// last key unless they have an alias
+func Test_GET_Rows_JSONPathSelect(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+	rr, err := tests.MakeHttpRequest(
+		ah,
+		http.MethodGet,
+		"/editions?select=meta->publisher->>city,meta.tags->0:first_tag&where=id==1",
+		nil,
+	)
+	assert.Try(t, err)
+	assert.IsEq(t, rr.Code, http.StatusOK)
+	assert.IsEq(t, rr.Body.String(), `[{"city":"New York","first_tag":"classic"}]`)
+}
+
+// Test_GET_Rows_JSONTypes tests that values are output in the same form they
+// are accepted as input
+func Test_GET_Rows_JSONTypes(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+	rr, err := tests.MakeHttpRequest(
+		ah,
+		http.MethodGet,
+		"/editions?select=published,price,ref,formats,cover&where=id==1",
+		nil,
+	)
+	assert.Try(t, err)
+	assert.IsEq(t, rr.Code, http.StatusOK)
+
+	exp := `[{"cover":"paperback","formats":["paperback","ebook"],` +
+		`"price":"15.00","published":"1998-01-01",` +
+		`"ref":"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"}]`
+	assert.IsEq(t, rr.Body.String(), exp)
+}
@@ -1,11 +1,15 @@
 package api_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"gopgrest/apperrors"
 	"gopgrest/assert"
 	"gopgrest/tests"
 	"gopgrest/types"
@@ -52,3 +56,100 @@ func Test_POST(t *testing.T) {
 		}
 	}
 }
+
+func Test_POST_InvalidBody(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+
+	newRows := []types.RowData{
+		{"surname": strings.Repeat("a", 151), "born": "1972"},
+		{"forename": "Martha", "born": 1947.5},
+	}
+	rr, err := tests.MakeHttpRequest(ah, http.MethodPost, "/authors", newRows)
+	assert.Try(t, err)
+	assert.IsEq(t, rr.Code, http.StatusUnprocessableEntity)
+
+	// Every invalid field is reported at once
+	var body struct {
+		Errors []apperrors.FieldError `json:"errors"`
+	}
+	assert.Try(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	exp := []apperrors.FieldError{
+		{Row: 0, Column: "surname"},
+		{Row: 0, Column: "born"},
+		{Row: 1, Column: "surname"},
+		{Row: 1, Column: "born"},
+	}
+	assert.IsEq(t, len(body.Errors), len(exp))
+	for i, fieldErr := range exp {
+		assert.IsEq(t, body.Errors[i].Row, fieldErr.Row)
+		assert.IsEq(t, body.Errors[i].Column, fieldErr.Column)
+	}
+
+	count, err := tests.CountRows(ah.Repo, "authors", "WHERE forename = 'Martha'")
+	assert.Try(t, err)
+	assert.IsEq(t, count, 0)
+}
+
+// CHECK constraints are enforced by Postgres, whose violation is still the
+// client's error
+func Test_POST_CheckViolation(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+
+	newRows := []types.RowData{{"book_id": 1, "price": -1}}
+	rr, err := tests.MakeHttpRequest(ah, http.MethodPost, "/editions", newRows)
+	assert.Try(t, err)
+	assert.IsEq(t, rr.Code, http.StatusBadRequest)
+	assert.IsTrue(t, strings.Contains(rr.Body.String(), apperrors.CheckViolation.Error()))
+}
+
+func Test_POST_PreferMissing(t *testing.T) {
+	newRows := []types.RowData{
+		{"surname": "Jemisin", "born": 1972},
+		{"surname": "Plato", "forename": ""},
+	}
+	body, err := json.Marshal(newRows)
+	assert.Try(t, err)
+
+	cases := []struct {
+		prefer     string
+		expCode    int
+		expApplied string
+	}{
+		{"", http.StatusOK, ""},
+		{"missing=default", http.StatusOK, "missing=default"},
+		// forename is NOT NULL
+		{"missing=null", http.StatusUnprocessableEntity, ""},
+		{"return=minimal, missing=null", http.StatusUnprocessableEntity, ""},
+		{"missing=unknown", http.StatusOK, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.prefer, func(t *testing.T) {
+			ah := tests.NewTestAPIHandler(t)
+			req := httptest.NewRequest(http.MethodPost, "/authors", bytes.NewBuffer(body))
+			if c.prefer != "" {
+				req.Header.Set("Prefer", c.prefer)
+			}
+			rr := httptest.NewRecorder()
+			ah.ServeHTTP(rr, req)
+			assert.IsEq(t, rr.Code, c.expCode)
+			assert.IsEq(t, rr.Header().Get("Preference-Applied"), c.expApplied)
+		})
+	}
+}
+
+func Test_POST_JSONTypes(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+	body := `{"book_id": 2, "price": 1234.50, "formats": ["audiobook", "large \"print\""]}`
+	req := httptest.NewRequest(http.MethodPost, "/editions", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	ah.ServeHTTP(rr, req)
+	assert.IsEq(t, rr.Code, http.StatusOK)
+
+	count, err := tests.CountRows(
+		ah.Repo,
+		"editions",
+		`WHERE price = 1234.50 AND formats = '{audiobook,"large \"print\""}'`,
+	)
+	assert.Try(t, err)
+	assert.IsEq(t, count, 1)
+}
@@ -76,3 +76,15 @@ func Test_PUT_MultipleConditions(t *testing.T) {
 		}
 	}
 }
+
+func Test_PUT_InvalidBody(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+	updateData := types.RowData{"surname": nil, "born": "unknown"}
+	rr, err := tests.MakeHttpRequest(ah, http.MethodPut, "/authors?surname==Brontë", updateData)
+	assert.Try(t, err)
+	assert.IsEq(t, rr.Code, http.StatusUnprocessableEntity)
+
+	count, err := tests.CountRows(ah.Repo, "authors", "WHERE surname = 'Brontë'")
+	assert.Try(t, err)
+	assert.IsEq(t, count, 1)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"gopgrest/repatterns"
+)
+
+// getSavedQuery handles `GET /_queries/{name}`, responding with the rows of a
+// saved query whose params are set by query params, e.g.
+//
+// `GET /_queries/books_by_genre?genre=3`
+func (h *APIHandler) getSavedQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeResponse(w, http.StatusMethodNotAllowed, headers{"Allow": http.MethodGet}, nil)
+		return
+	}
+	name := repatterns.ReqSavedQuery.FindStringSubmatch(r.URL.Path)[1]
+
+	params, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
+		return
+	}
+	values := map[string]string{}
+	for param, vals := range params {
+		if len(vals) != 1 {
+			msg := fmt.Sprintf("param %s is set more than once", param)
+			writeResponse(w, http.StatusBadRequest, nil, []byte(msg))
+			return
+		}
+		values[param] = vals[0]
+	}
+
+	gotRows, err := h.Service.GetSavedQuery(name, values)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(gotRows)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, nil, []byte(err.Error()))
+		return
+	}
+	headers := headers{"Content-Type": "application/json"}
+	writeResponse(w, http.StatusOK, headers, jsonData)
+}
@@ -0,0 +1,53 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"gopgrest/assert"
+	"gopgrest/service"
+	"gopgrest/tests"
+	"gopgrest/types"
+)
+
+func Test_SavedQueries(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+	err := ah.Service.SetSavedQueries(map[string]service.SavedQuery{
+		"books_by_author": {
+			Query:  "/books?select=title&join=authors:books.author_id==authors.id&where=authors.surname=={surname}&order_by=title",
+			Params: map[string]string{"surname": "text"},
+		},
+	})
+	assert.Try(t, err)
+
+	t.Run("GET with params", func(t *testing.T) {
+		rr, err := tests.MakeHttpRequest(ah, http.MethodGet, "/_queries/books_by_author?surname=Woolf", nil)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		gotRows := []types.RowData{}
+		unmarshal(t, rr.Body.Bytes(), &gotRows)
+		expRows := []types.RowData{{"title": "Mrs. Dalloway"}, {"title": "To The Lighthouse"}}
+		assert.Try(t, tests.CheckMapEquality(expRows, gotRows))
+	})
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		code   int
+	}{
+		{"unknown query", http.MethodGet, "/_queries/all_books", http.StatusNotFound},
+		{"missing param", http.MethodGet, "/_queries/books_by_author", http.StatusBadRequest},
+		{"RSQL clause", http.MethodGet, "/_queries/books_by_author?surname=Woolf&limit=1", http.StatusBadRequest},
+		{"repeated param", http.MethodGet, "/_queries/books_by_author?surname=Woolf&surname=Carson", http.StatusBadRequest},
+		{"POST", http.MethodPost, "/_queries/books_by_author?surname=Woolf", http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rr, err := tests.MakeHttpRequest(ah, c.method, c.path, nil)
+			assert.Try(t, err)
+			assert.IsEq(t, rr.Code, c.code)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"gopgrest/repatterns"
+	"gopgrest/rsql"
+)
+
+// callFunction handles `POST /rpc/{function}`, calling the function with the
+// named arguments in the JSON request body, and `GET /rpc/{function}`, which
+// is only allowed for STABLE and IMMUTABLE functions and takes arguments as
+// query params. In both cases, RSQL query params are applied to the result of
+// set-returning functions, e.g.
+//
+// `GET /rpc/books_by_author?author_surname=Woolf&select=title&limit=1`
+func (h *APIHandler) callFunction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		headers := headers{"Allow": "GET, POST"}
+		writeResponse(w, http.StatusMethodNotAllowed, headers, nil)
+		return
+	}
+	name := repatterns.ReqRPC.FindStringSubmatch(r.URL.Path)[1]
+	args := map[string]any{}
+
+	// Separate the RSQL clauses from the function arguments
+	rsqlClauses := []string{}
+	if r.URL.RawQuery != "" {
+		for param := range strings.SplitSeq(r.URL.RawQuery, "&") {
+			rawKey, value, _ := strings.Cut(param, "=")
+			key, err := url.QueryUnescape(rawKey)
+			if err != nil {
+				writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
+				return
+			}
+			if slices.Contains(rsql.VALIDKEYWORDS, key) {
+				rsqlClauses = append(rsqlClauses, param)
+				continue
+			}
+			if r.Method != http.MethodGet {
+				msg := fmt.Sprintf("unexpected query param %s, pass arguments in the request body", key)
+				writeResponse(w, http.StatusBadRequest, nil, []byte(msg))
+				return
+			}
+			arg, err := url.QueryUnescape(value)
+			if err != nil {
+				writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
+				return
+			}
+			args[key] = arg
+		}
+	}
+
+	// Decode arguments from an optional JSON object in a POST body, keeping
+	// the precision of numbers
+	if r.Method == http.MethodPost {
+		err := newBodyDecoder(r.Body).Decode(&args)
+		if err != nil && !errors.Is(err, io.EOF) {
+			writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
+			return
+		}
+	}
+
+	result, err := h.Service.CallFunction(
+		name,
+		args,
+		strings.Join(rsqlClauses, "&"),
+		r.Method == http.MethodGet,
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, nil, []byte(err.Error()))
+		return
+	}
+	headers := headers{"Content-Type": "application/json"}
+	writeResponse(w, http.StatusOK, headers, jsonData)
+}
@@ -0,0 +1,134 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gopgrest/assert"
+	"gopgrest/tests"
+	"gopgrest/types"
+)
+
+func Test_RPC_SetReturning(t *testing.T) {
+	t.Run("GET with argument", func(t *testing.T) {
+		rawQuery := "SELECT * FROM books_by_author('Woolf')"
+		apiGetRowsTester(t, rawQuery, "/rpc/books_by_author?author_surname=Woolf")
+	})
+
+	t.Run("GET with RSQL", func(t *testing.T) {
+		rawQuery := "SELECT title FROM books_by_author('Woolf') WHERE title LIKE 'Mrs%' ORDER BY title DESC LIMIT 1"
+		url := "/rpc/books_by_author?author_surname=Woolf&select=title&where=title=like=Mrs%25&order_by=title:desc&limit=1"
+		apiGetRowsTester(t, rawQuery, url)
+	})
+
+	t.Run("POST with JSON arguments", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		args := map[string]any{"author_surname": "Carson"}
+		rr, err := tests.MakeHttpRequest(ah, http.MethodPost, "/rpc/books_by_author?select=title", args)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		gotRows := []types.RowData{}
+		unmarshal(t, rr.Body.Bytes(), &gotRows)
+		assert.Try(t, tests.CheckMapEquality([]types.RowData{{"title": "Autobiography of Red"}}, gotRows))
+	})
+}
+
+func Test_RPC_Scalar(t *testing.T) {
+	cases := []struct {
+		name string
+		path string
+		exp  any
+	}{
+		{"required argument", "/rpc/lifespan?author=2", float64(29)},
+		{"NULL result", "/rpc/lifespan?author=1", nil},
+		{"default argument", "/rpc/lifespan?author=1&if_living=75", float64(75)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ah := tests.NewTestAPIHandler(t)
+			rr, err := tests.MakeHttpRequest(ah, http.MethodGet, c.path, nil)
+			assert.Try(t, err)
+			assert.IsEq(t, rr.Code, http.StatusOK)
+
+			var got any
+			assert.Try(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			assert.IsEq(t, got, c.exp)
+		})
+	}
+}
+
+func Test_RPC_Arguments(t *testing.T) {
+	t.Run("POST numbers keep their precision", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		args := json.RawMessage(`{"big": 9007199254740993, "exact": 12345678901234567890.123}`)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodPost, "/rpc/numbers_text", args)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		var got any
+		assert.Try(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.IsEq(t, got, "9007199254740993 12345678901234567890.123")
+	})
+
+	t.Run("GET argument names are unescaped", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodGet, "/rpc/lifespan?%61uthor=2", nil)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		var got any
+		assert.Try(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.IsEq(t, got, float64(29))
+	})
+}
+
+func Test_RPC_Volatile(t *testing.T) {
+	t.Run("GET is not allowed", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodGet, "/rpc/add_genre?genre_name=Satire", nil)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusMethodNotAllowed)
+		assert.IsEq(t, rr.Header().Get("Allow"), http.MethodPost)
+	})
+
+	t.Run("POST", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		args := map[string]any{"genre_name": "Satire"}
+		rr, err := tests.MakeHttpRequest(ah, http.MethodPost, "/rpc/add_genre", args)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		count, err := tests.CountRows(ah.Repo, "genres", "WHERE name = 'Satire'")
+		assert.Try(t, err)
+		assert.IsEq(t, count, 1)
+	})
+}
+
+func Test_RPC_BadRequests(t *testing.T) {
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		args    any
+		expCode int
+	}{
+		{"unknown function", http.MethodGet, "/rpc/does_not_exist", nil, http.StatusNotFound},
+		{"unknown argument", http.MethodPost, "/rpc/add_genre", map[string]any{"genre": "Satire"}, http.StatusBadRequest},
+		{"missing argument", http.MethodGet, "/rpc/lifespan?if_living=75", nil, http.StatusBadRequest},
+		{"wrong argument type", http.MethodPost, "/rpc/lifespan", map[string]any{"author": "Woolf"}, http.StatusBadRequest},
+		{"non-integer argument", http.MethodPost, "/rpc/lifespan", map[string]any{"author": 1.5}, http.StatusBadRequest},
+		{"RSQL on scalar function", http.MethodGet, "/rpc/lifespan?author=1&limit=1", nil, http.StatusBadRequest},
+		{"column not in result", http.MethodGet, "/rpc/books_by_author?author_surname=Woolf&select=surname", nil, http.StatusBadRequest},
+		{"wrong method", http.MethodDelete, "/rpc/add_genre", nil, http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ah := tests.NewTestAPIHandler(t)
+			rr, err := tests.MakeHttpRequest(ah, c.method, c.path, c.args)
+			assert.Try(t, err)
+			assert.IsEq(t, rr.Code, c.expCode)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopgrest/assert"
+	"gopgrest/tests"
+	"gopgrest/types"
+)
+
+func Test_Schemas_Path(t *testing.T) {
+	t.Run("table in another schema", func(t *testing.T) {
+		rawQuery := "SELECT * FROM archive.authors"
+		apiGetRowsTester(t, rawQuery, "/archive/authors")
+	})
+
+	t.Run("row by id in another schema", func(t *testing.T) {
+		rawQuery := "SELECT * FROM archive.authors WHERE id = 2"
+		apiGetRowsTester(t, rawQuery, "/archive/authors/2")
+	})
+
+	t.Run("default schema", func(t *testing.T) {
+		rawQuery := "SELECT * FROM public.authors"
+		apiGetRowsTester(t, rawQuery, "/public/authors?")
+	})
+
+	t.Run("tables of a schema", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodGet, "/archive", nil)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		tables := map[string]any{}
+		assert.Try(t, json.Unmarshal(rr.Body.Bytes(), &tables))
+		assert.IsEq(t, len(tables), 5)
+		_, ok := tables["letters"]
+		assert.IsTrue(t, ok)
+	})
+
+	// public.archive shares a name with the archive schema. The first segment
+	// of the path selects a schema whenever it names an exposed one
+	t.Run("schema shares a name with a table", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodGet, "/archive", nil)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		tables := map[string]any{}
+		assert.Try(t, json.Unmarshal(rr.Body.Bytes(), &tables))
+		_, ok := tables["letters"]
+		assert.IsTrue(t, ok)
+	})
+
+	t.Run("table shares a name with a schema", func(t *testing.T) {
+		rawQuery := "SELECT * FROM public.archive"
+		apiGetRowsTester(t, rawQuery, "/public/archive")
+	})
+
+	t.Run("table not in schema", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodGet, "/letters", nil)
+		assert.Try(t, err)
+		assert.IsNotEq(t, rr.Code, http.StatusOK)
+	})
+}
+
+func Test_Schemas_Headers(t *testing.T) {
+	t.Run("Accept-Profile", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		req := httptest.NewRequest(http.MethodGet, "/authors?select=surname", nil)
+		req.Header.Set("Accept-Profile", "archive")
+		rr := httptest.NewRecorder()
+		ah.ServeHTTP(rr, req)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		gotRows := []types.RowData{}
+		unmarshal(t, rr.Body.Bytes(), &gotRows)
+		expRows, err := tests.SelectRows(ah.Repo, "SELECT surname FROM archive.authors")
+		assert.Try(t, err)
+		assert.Try(t, tests.CheckMapEquality(expRows, gotRows))
+	})
+
+	t.Run("Content-Profile", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		body := `{"title": "Fragment 31", "author_id": 1}`
+		req := httptest.NewRequest(http.MethodPost, "/letters", bytes.NewBufferString(body))
+		req.Header.Set("Content-Profile", "archive")
+		rr := httptest.NewRecorder()
+		ah.ServeHTTP(rr, req)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		count, err := tests.CountRows(ah.Repo, "archive.letters", "WHERE title = 'Fragment 31'")
+		assert.Try(t, err)
+		assert.IsEq(t, count, 1)
+	})
+
+	t.Run("schema is not exposed", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		req := httptest.NewRequest(http.MethodGet, "/authors", nil)
+		req.Header.Set("Accept-Profile", "pg_catalog")
+		rr := httptest.NewRecorder()
+		ah.ServeHTTP(rr, req)
+		assert.IsEq(t, rr.Code, http.StatusNotAcceptable)
+	})
+}
+
+func Test_Schemas_QuotedIdentifiers(t *testing.T) {
+	t.Run("reserved word table", func(t *testing.T) {
+		rawQuery := `SELECT "DisplayName" FROM archive."user" ORDER BY "order" DESC`
+		apiGetRowsTester(t, rawQuery, "/archive/user?select=DisplayName&order_by=order:desc")
+	})
+
+	t.Run("non-ASCII table", func(t *testing.T) {
+		rawQuery := `SELECT * FROM archive."éditeurs"`
+		apiGetRowsTester(t, rawQuery, "/archive/%C3%A9diteurs")
+	})
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"gopgrest/changefeed"
+	"gopgrest/types"
+	"gopgrest/websocket"
+)
+
+// subscriptionRequest is sent by a WebSocket client to add or remove a
+// subscription to a table's changes, e.g.
+//
+// `{"type": "subscribe", "id": "anne", "table": "authors", "where": "forename==Anne"}`
+type subscriptionRequest struct {
+	Type  string `json:"type"`  // "subscribe" or "unsubscribe"
+	ID    string `json:"id"`    // chosen by the client, unique per connection
+	Table string `json:"table"` // table to subscribe to
+	Where string `json:"where"` // optional RSQL `where` conditions
+}
+
+// subscriptionMessage is sent to a WebSocket client. Type is one of the change
+// operations (insert, update, delete) or subscribed, unsubscribed, lagged or
+// error
+type subscriptionMessage struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id,omitempty"`
+	EventID int64         `json:"event_id,omitempty"`
+	Table   string        `json:"table,omitempty"`
+	PK      any           `json:"pk,omitempty"`
+	Row     types.RowData `json:"row,omitempty"`
+	Message string        `json:"message,omitempty"`
+}
+
+// subscribeChanges upgrades `GET /_changes` to a WebSocket over which a client
+// can subscribe to changes on several tables, each with its own filter
+func (h *APIHandler) subscribeChanges(w http.ResponseWriter, r *http.Request) {
+	if h.Changes == nil {
+		writeResponse(w, http.StatusNotImplemented, nil, []byte("change feed is not enabled"))
+		return
+	}
+	// Browsers send cookies with a WebSocket handshake from any site, so
+	// other sites' pages must be refused
+	if !websocket.CheckOrigin(r, h.AllowedOrigins) {
+		writeResponse(w, http.StatusForbidden, nil, []byte("origin not allowed"))
+		return
+	}
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, nil, []byte(err.Error()))
+		return
+	}
+
+	// The request context is not cancelled when a hijacked connection closes,
+	// so subscriptions are tied to the read loop instead
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+		conn.Close()
+	}()
+
+	subs := map[string]context.CancelFunc{}
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		reply, forward := h.handleSubscriptionRequest(ctx, conn, subs, data)
+		err = conn.WriteJSON(reply)
+		// A new subscription is forwarded even if its confirmation failed,
+		// so that it is unsubscribed once the context is cancelled
+		if forward != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				forward()
+			}()
+		}
+		// A failed write means the socket is dead, ending the read loop
+		// removes its subscriptions
+		if err != nil {
+			log.Printf("Could not write to change subscriber: %s\n", err)
+			return
+		}
+	}
+}
+
+// handleSubscriptionRequest adds or removes a subscription as a client's
+// request asks and returns the message that confirms it, or an error message.
+// A new subscription is returned with a function that forwards its changes,
+// which is started once the subscription is confirmed
+func (h *APIHandler) handleSubscriptionRequest(
+	ctx context.Context,
+	conn *websocket.Conn,
+	subs map[string]context.CancelFunc,
+	data []byte,
+) (subscriptionMessage, func()) {
+	var req subscriptionRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return subscriptionMessage{Type: "error", Message: err.Error()}, nil
+	}
+
+	switch req.Type {
+	case "subscribe":
+		if _, ok := subs[req.ID]; ok {
+			return subscriptionMessage{
+				Type:    "error",
+				ID:      req.ID,
+				Message: fmt.Sprintf("subscription %s already exists", req.ID),
+			}, nil
+		}
+		filterURL := "/" + req.Table
+		if req.Where != "" {
+			filterURL = fmt.Sprintf("/%s?where=%s", req.Table, url.QueryEscape(req.Where))
+		}
+		conditions, err := h.Service.GetChangeFilter(req.Table, filterURL)
+		if err != nil {
+			return subscriptionMessage{Type: "error", ID: req.ID, Message: err.Error()}, nil
+		}
+		filter, err := changefeed.NewFilter(conditions)
+		if err != nil {
+			return subscriptionMessage{Type: "error", ID: req.ID, Message: err.Error()}, nil
+		}
+
+		// Subscribe before confirming so no changes are missed
+		sub, _ := h.Changes.Subscribe(h.Repo.Schema, req.Table, 0)
+		subCtx, subCancel := context.WithCancel(ctx)
+		subs[req.ID] = subCancel
+		forward := func() { h.forwardChanges(subCtx, conn, req.ID, sub, filter) }
+		return subscriptionMessage{Type: "subscribed", ID: req.ID, Table: req.Table}, forward
+	case "unsubscribe":
+		subCancel, ok := subs[req.ID]
+		if !ok {
+			return subscriptionMessage{
+				Type:    "error",
+				ID:      req.ID,
+				Message: fmt.Sprintf("no subscription %s", req.ID),
+			}, nil
+		}
+		subCancel()
+		delete(subs, req.ID)
+		return subscriptionMessage{Type: "unsubscribed", ID: req.ID}, nil
+	default:
+		return subscriptionMessage{
+			Type:    "error",
+			ID:      req.ID,
+			Message: fmt.Sprintf("invalid message type '%s'", req.Type),
+		}, nil
+	}
+}
+
+// forwardChanges sends a subscription's matching changes to the client until
+// the context is cancelled. Each subscription has its own bounded buffer in
+// the broker, so a busy table only holds back its own subscription: if the
+// client can't keep up, the broker drops the subscription and it is resumed
+// from the broker's ring buffer after notifying the client that it lagged.
+// If a write fails the connection is closed, which ends the read loop and so
+// every subscription on it
+func (h *APIHandler) forwardChanges(
+	ctx context.Context,
+	conn *websocket.Conn,
+	id string,
+	sub *changefeed.Subscription,
+	filter changefeed.Filter,
+) {
+	defer func() { h.Changes.Unsubscribe(sub) }()
+	lastID := sub.LastID
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.C:
+			events := []changefeed.Event{e}
+			if !ok {
+				err := conn.WriteJSON(subscriptionMessage{Type: "lagged", ID: id, Table: sub.Table})
+				if err != nil {
+					log.Printf("Could not write to change subscriber: %s\n", err)
+					conn.Close()
+					return
+				}
+				sub, events = h.Changes.Resume(sub, lastID)
+			}
+			for _, e := range events {
+				if err := h.sendChange(conn, id, e, filter); err != nil {
+					log.Printf("Could not write to change subscriber: %s\n", err)
+					conn.Close()
+					return
+				}
+				lastID = e.ID
+			}
+		}
+	}
+}
+
+// sendChange sends a change to the client if the changed row matches the
+// subscription's filter. Inserted and updated rows are fetched so the
+// client receives the full row even if it was too large for the NOTIFY
+// payload. Deleted rows are sent as they were in the payload
+func (h *APIHandler) sendChange(conn *websocket.Conn, id string, e changefeed.Event, filter changefeed.Filter) error {
+	row := e.Row
+	if e.Op != "delete" {
+		fetched, err := h.Service.GetChangedRow(e.Table, e.PK)
+		if err != nil {
+			// The row may have been changed again or deleted since
+			log.Printf("Could not fetch changed row %s %v: %s\n", e.Table, e.PK, err)
+		} else {
+			row = fetched
+		}
+	}
+	if !filter.Matches(row) {
+		return nil
+	}
+	return conn.WriteJSON(subscriptionMessage{
+		Type:    e.Op,
+		ID:      id,
+		EventID: e.ID,
+		Table:   e.Table,
+		PK:      e.PK,
+		Row:     row,
+	})
+}
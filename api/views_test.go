@@ -0,0 +1,125 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"gopgrest/assert"
+	"gopgrest/tests"
+	"gopgrest/types"
+)
+
+func Test_GET_Views(t *testing.T) {
+	t.Run("view", func(t *testing.T) {
+		rawQuery := "SELECT * FROM book_authors WHERE surname = 'Woolf'"
+		apiGetRowsTester(t, rawQuery, "/book_authors?where=surname==Woolf")
+	})
+	t.Run("materialized view", func(t *testing.T) {
+		rawQuery := "SELECT name, book_count FROM genre_counts"
+		apiGetRowsTester(t, rawQuery, "/genre_counts?select=name,book_count")
+	})
+}
+
+func Test_ReadOnlyViews(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+	}{
+		{"POST to view", http.MethodPost, "/book_authors", types.RowData{"title": "Orlando"}},
+		{"PUT to view", http.MethodPut, "/book_authors/1", types.RowData{"title": "Orlando"}},
+		{"DELETE from view", http.MethodDelete, "/book_authors/1", nil},
+		{"POST to materialized view", http.MethodPost, "/genre_counts", types.RowData{"name": "Satire"}},
+		{"DELETE from materialized view", http.MethodDelete, "/genre_counts?name==Romance", nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ah := tests.NewTestAPIHandler(t)
+			rr, err := tests.MakeHttpRequest(ah, c.method, c.path, c.body)
+			assert.Try(t, err)
+			assert.IsEq(t, rr.Code, http.StatusMethodNotAllowed)
+			assert.IsEq(t, rr.Header().Get("Allow"), http.MethodGet)
+		})
+	}
+}
+
+func Test_UpdatableView(t *testing.T) {
+	ah := tests.NewTestAPIHandler(t)
+	rr, err := tests.MakeHttpRequest(
+		ah,
+		http.MethodPut,
+		"/nineteenth_century_authors?surname==Brontë",
+		types.RowData{"forename": "Charlotte"},
+	)
+	assert.Try(t, err)
+	assert.IsEq(t, rr.Code, http.StatusOK)
+
+	count, err := tests.CountRows(ah.Repo, "authors", "WHERE forename = 'Charlotte'")
+	assert.Try(t, err)
+	assert.IsEq(t, count, 1)
+}
+
+// Views that can be updated but not inserted into allow PUT and DELETE only
+func Test_UpdatableView_NotInsertable(t *testing.T) {
+	t.Run("PUT", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodPut, "/genre_names?name==Romance", types.RowData{"name": "Gothic"})
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		count, err := tests.CountRows(ah.Repo, "genres", "WHERE name = 'Gothic'")
+		assert.Try(t, err)
+		assert.IsEq(t, count, 1)
+	})
+	t.Run("DELETE", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodDelete, "/genre_names?name==Dystopian", nil)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusOK)
+
+		count, err := tests.CountRows(ah.Repo, "genres", "WHERE name = 'Dystopian'")
+		assert.Try(t, err)
+		assert.IsEq(t, count, 0)
+	})
+	t.Run("POST", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodPost, "/genre_names", []types.RowData{{"name": "Satire"}})
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusMethodNotAllowed)
+		assert.IsEq(t, rr.Header().Get("Allow"), "GET, PUT, DELETE")
+	})
+}
+
+func Test_POST_RefreshMaterializedView(t *testing.T) {
+	for _, path := range []string{"/genre_counts/_refresh", "/genre_counts/_refresh?concurrently=true"} {
+		t.Run(path, func(t *testing.T) {
+			ah := tests.NewTestAPIHandler(t)
+			_, err := ah.Repo.DB.Exec("INSERT INTO books (title, author_id, genre_id) VALUES ('Orlando', 3, 1)")
+			assert.Try(t, err)
+
+			rr, err := tests.MakeHttpRequest(ah, http.MethodPost, path, nil)
+			assert.Try(t, err)
+			assert.IsEq(t, rr.Code, http.StatusNoContent)
+
+			count, err := tests.CountRows(ah.Repo, "genre_counts", "WHERE name = 'Modernism' AND book_count = 2")
+			assert.Try(t, err)
+			assert.IsEq(t, count, 1)
+		})
+	}
+
+	t.Run("not a materialized view", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodPost, "/authors/_refresh", nil)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("wrong method", func(t *testing.T) {
+		ah := tests.NewTestAPIHandler(t)
+		rr, err := tests.MakeHttpRequest(ah, http.MethodGet, "/genre_counts/_refresh", nil)
+		assert.Try(t, err)
+		assert.IsEq(t, rr.Code, http.StatusMethodNotAllowed)
+		assert.IsEq(t, rr.Header().Get("Allow"), http.MethodPost)
+	})
+}
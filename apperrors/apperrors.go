@@ -3,6 +3,7 @@ package apperrors
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"gopgrest/rsql"
 )
@@ -10,13 +11,40 @@ import (
 var (
 	GetByIdNotUnique         = errors.New("GET by id returned multiple rows")
 	InsertWithNoRows         = errors.New("Cannot insert with no rows")
-	InsertColsDoNotMatch     = errors.New("Columns in rows to insert do not match")
 	InsertValTypesDoNotMatch = errors.New("Value types in rows to insert do not match")
 	DeleteWithNoConditions   = errors.New("Will not DELETE with no WHERE conditions")
 	UpdateWithNoConditions   = errors.New("Will not UPDATE with no WHERE conditions")
 
 	TableDoesNotExist = errors.New("Table does not exist")
 	ColDoesNotExist   = errors.New("Column not found in given table")
+	SchemaNotExposed  = errors.New("Schema is not exposed")
+
+	InvalidConditionValue = errors.New("Invalid value in condition")
+	InvalidCast           = errors.New("Invalid cast in condition")
+	InvalidJSONPath       = errors.New("Invalid json path")
+	InvalidJSONOperator   = errors.New("Invalid json operator in condition")
+	InvalidArrayOperator  = errors.New("Invalid array operator in condition")
+	InvalidTextSearch     = errors.New("Invalid full-text search condition")
+	InvalidJoin           = errors.New("Invalid join")
+	InvalidDistinct       = errors.New("Invalid distinct clause")
+	InvalidSubquery       = errors.New("Invalid subquery in condition")
+	InvalidChangeFilter   = errors.New("Invalid change feed filter")
+	RankWithoutTextSearch = errors.New("Cannot order by rank without a full-text search condition")
+	InvalidRequestBody    = errors.New("Invalid values in request body")
+	CheckViolation        = errors.New("Row violates a check constraint")
+
+	TableIsReadOnly      = errors.New("Table is read-only")
+	TableIsNotInsertable = errors.New("Table does not allow inserts")
+	TableIsNotUpdatable  = errors.New("Table does not allow updates or deletes")
+	NotMaterializedView  = errors.New("Table is not a materialized view")
+
+	FunctionDoesNotExist  = errors.New("Function does not exist")
+	FunctionIsNotReadOnly = errors.New("Function is VOLATILE and can only be called with POST")
+	InvalidFunctionArgs   = errors.New("Invalid function arguments")
+	InvalidFunctionRSQL   = errors.New("Invalid RSQL query for function")
+
+	SavedQueryDoesNotExist  = errors.New("Saved query does not exist")
+	InvalidSavedQueryParams = errors.New("Invalid saved query params")
 )
 
 func NewDeleteInvalidIDErr(tableName string, id int64) error {
@@ -29,3 +57,28 @@ func NewUpdateNoMatchingConditionsErr(tableName string, conditions []rsql.Condit
 		tableName, conditions,
 	)
 }
+
+// FieldError is an invalid value of a column in a request body. Row is the
+// index of the row in the body, which is always 0 for updates
+type FieldError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column"`
+	Message string `json:"message"`
+}
+
+// ValidationError reports every invalid value in a request body at once
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = fmt.Sprintf("row %d col %s: %s", f.Row, f.Column, f.Message)
+	}
+	return fmt.Sprintf("%s\n%s", InvalidRequestBody, strings.Join(msgs, "\n"))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return InvalidRequestBody
+}
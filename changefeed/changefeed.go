@@ -0,0 +1,172 @@
+package changefeed
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/lib/pq"
+
+	"gopgrest/types"
+)
+
+// CHANNEL is the Postgres NOTIFY channel the change triggers publish to
+const CHANNEL = "gopgrest_changes"
+
+// BUFFER_SIZE is the default number of recent events kept for clients
+// resuming a stream
+const BUFFER_SIZE = 1024
+
+// SUBSCRIPTION_BUFFER is the number of events a subscriber may fall behind
+// before the broker drops it
+const SUBSCRIPTION_BUFFER = 64
+
+// Event is a single insert, update or delete on a table, decoded from a
+// NOTIFY payload and numbered by the Broker
+type Event struct {
+	ID     int64         `json:"id"`
+	Schema string        `json:"schema"`
+	Table  string        `json:"table"`
+	Op     string        `json:"op"`
+	PK     any           `json:"pk"`
+	Row    types.RowData `json:"row,omitempty"`
+}
+
+// Subscription receives the events for one table in a schema that come after
+// the event LastID. C is closed when the subscription is removed, either by
+// Unsubscribe or because the subscriber fell too far behind and was dropped
+// by the Broker
+type Subscription struct {
+	Schema string
+	Table  string
+	LastID int64
+	C      chan Event
+}
+
+// Broker fans out change events received over LISTEN/NOTIFY to subscribers
+// and keeps the most recent events in a ring buffer so that clients can
+// resume a stream from a known event ID
+type Broker struct {
+	mu     sync.Mutex
+	nextID int64
+	recent *ring
+	subs   map[*Subscription]struct{}
+}
+
+// NewBroker returns a Broker that remembers the last `size` events
+func NewBroker(size int) *Broker {
+	return &Broker{
+		nextID: 1,
+		recent: newRing(size),
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+// Listen subscribes the listener to the change channel and publishes each
+// notification until the listener is closed
+func (b *Broker) Listen(listener *pq.Listener) error {
+	if err := listener.Listen(CHANNEL); err != nil {
+		return err
+	}
+	go func() {
+		for n := range listener.NotificationChannel() {
+			// A nil notification is sent after the listener reconnects, any
+			// events sent while it was disconnected are lost
+			if n == nil {
+				log.Println("Change feed listener reconnected")
+				continue
+			}
+			if err := b.Publish(n.Extra); err != nil {
+				log.Printf("Could not decode change event: %s\n", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Publish decodes a NOTIFY payload into an Event, stores it in the ring
+// buffer and sends it to every subscriber of the event's table
+func (b *Broker) Publish(payload string) error {
+	var e Event
+	if err := json.Unmarshal([]byte(payload), &e); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.ID = b.nextID
+	b.nextID++
+	b.recent.push(e)
+
+	for sub := range b.subs {
+		if sub.Schema != e.Schema || sub.Table != e.Table {
+			continue
+		}
+		select {
+		case sub.C <- e:
+		default:
+			// Subscriber is not keeping up, drop it rather than block the
+			// feed. It can resume from its last event ID
+			log.Printf("Dropping slow change subscriber on table %s\n", sub.Table)
+			delete(b.subs, sub)
+			close(sub.C)
+		}
+	}
+	return nil
+}
+
+// Subscribe registers a new subscription to a table in a schema. If lastID is
+// greater than zero, the buffered events for the table that came after lastID
+// are returned so the caller can replay them before reading from the
+// subscription. Otherwise the subscription starts after the last published
+// event
+func (b *Broker) Subscribe(schema, table string, lastID int64) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if lastID <= 0 {
+		lastID = b.nextID - 1
+	}
+	return b.subscribe(schema, table, lastID)
+}
+
+// Resume registers a new subscription to a dropped subscription's table and
+// returns the buffered events for the table that came after lastID, even if
+// it is zero, so that a subscriber dropped before receiving any event misses
+// none of the buffered ones
+func (b *Broker) Resume(sub *Subscription, lastID int64) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subscribe(sub.Schema, sub.Table, lastID)
+}
+
+// subscribe registers a subscription starting after lastID and returns the
+// buffered events for its table since then. The caller must hold b.mu
+func (b *Broker) subscribe(schema, table string, lastID int64) (*Subscription, []Event) {
+	replay := []Event{}
+	for _, e := range b.recent.since(lastID) {
+		if e.Schema == schema && e.Table == table {
+			replay = append(replay, e)
+		}
+	}
+
+	sub := &Subscription{
+		Schema: schema,
+		Table:  table,
+		LastID: lastID,
+		C:      make(chan Event, SUBSCRIPTION_BUFFER),
+	}
+	b.subs[sub] = struct{}{}
+	return sub, replay
+}
+
+// Unsubscribe removes a subscription and closes its channel if the Broker
+// has not already dropped it
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.C)
+	}
+}
@@ -0,0 +1,240 @@
+package changefeed_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"gopgrest/apperrors"
+	"gopgrest/assert"
+	"gopgrest/changefeed"
+	"gopgrest/rsql"
+	"gopgrest/types"
+)
+
+func Test_BrokerPublish(t *testing.T) {
+	t.Run("events are sent to subscribers of the table", func(t *testing.T) {
+		broker := changefeed.NewBroker(8)
+		authors, _ := broker.Subscribe("public", "authors", 0)
+		books, _ := broker.Subscribe("public", "books", 0)
+
+		err := broker.Publish(`{"schema":"public","table":"authors","op":"insert","pk":4,"row":{"id":4,"surname":"Jemisin"}}`)
+		assert.Try(t, err)
+
+		e := <-authors.C
+		assert.IsEq(t, e.ID, int64(1))
+		assert.IsEq(t, e.Op, "insert")
+		assert.IsEq(t, e.Row["surname"], "Jemisin")
+		assert.IsEq(t, len(books.C), 0)
+	})
+
+	t.Run("events are only sent to subscribers of the same schema", func(t *testing.T) {
+		broker := changefeed.NewBroker(8)
+		public, _ := broker.Subscribe("public", "authors", 0)
+		archive, _ := broker.Subscribe("archive", "authors", 0)
+
+		err := broker.Publish(`{"schema":"archive","table":"authors","op":"delete","pk":1}`)
+		assert.Try(t, err)
+
+		assert.IsEq(t, len(public.C), 0)
+		assert.IsEq(t, len(archive.C), 1)
+	})
+
+	t.Run("malformed payload", func(t *testing.T) {
+		broker := changefeed.NewBroker(8)
+		err := broker.Publish(`not json`)
+		assert.IsNotEq(t, err, nil)
+	})
+
+	t.Run("slow subscribers are dropped", func(t *testing.T) {
+		broker := changefeed.NewBroker(8)
+		sub, _ := broker.Subscribe("public", "authors", 0)
+		for i := range changefeed.SUBSCRIPTION_BUFFER + 1 {
+			err := broker.Publish(fmt.Sprintf(`{"schema":"public","table":"authors","op":"update","pk":%d}`, i))
+			assert.Try(t, err)
+		}
+		received := 0
+		for range sub.C {
+			received++
+		}
+		assert.IsEq(t, received, changefeed.SUBSCRIPTION_BUFFER)
+	})
+}
+
+func Test_BrokerReplay(t *testing.T) {
+	broker := changefeed.NewBroker(3)
+	for i := range 5 {
+		table := "authors"
+		if i == 3 {
+			table = "books"
+		}
+		err := broker.Publish(fmt.Sprintf(`{"schema":"public","table":"%s","op":"insert","pk":%d}`, table, i))
+		assert.Try(t, err)
+	}
+
+	t.Run("no last event id", func(t *testing.T) {
+		sub, replay := broker.Subscribe("public", "authors", 0)
+		assert.IsEq(t, len(replay), 0)
+		assert.IsEq(t, sub.LastID, int64(5))
+	})
+
+	// Events 1 and 2 have been overwritten, 4 is on another table
+	t.Run("only buffered events after last event id", func(t *testing.T) {
+		_, replay := broker.Subscribe("public", "authors", 1)
+		assert.IsEq(t, len(replay), 2)
+		assert.IsEq(t, replay[0].ID, int64(3))
+		assert.IsEq(t, replay[1].ID, int64(5))
+	})
+}
+
+// A subscriber dropped before it received any event resumes from the event
+// before it subscribed, rather than missing the buffered events
+func Test_BrokerResume(t *testing.T) {
+	broker := changefeed.NewBroker(changefeed.SUBSCRIPTION_BUFFER * 2)
+	sub, _ := broker.Subscribe("public", "authors", 0)
+	assert.IsEq(t, sub.LastID, int64(0))
+	for i := range changefeed.SUBSCRIPTION_BUFFER + 1 {
+		err := broker.Publish(fmt.Sprintf(`{"schema":"public","table":"authors","op":"insert","pk":%d}`, i))
+		assert.Try(t, err)
+	}
+	for range sub.C {
+	}
+
+	_, replay := broker.Resume(sub, sub.LastID)
+	assert.IsEq(t, len(replay), changefeed.SUBSCRIPTION_BUFFER+1)
+	assert.IsEq(t, replay[0].ID, int64(1))
+}
+
+// matches compiles a filter from conditions and matches a row against it
+func matches(t *testing.T, conditions []rsql.Condition, row types.RowData) bool {
+	filter, err := changefeed.NewFilter(conditions)
+	assert.Try(t, err)
+	return filter.Matches(row)
+}
+
+func Test_Matches(t *testing.T) {
+	row := types.RowData{"surname": "Woolf", "forename": "Virginia", "born": float64(1882), "died": nil}
+
+	cases := []struct {
+		name     string
+		operator string
+		column   string
+		values   []string
+		exp      bool
+	}{
+		{"equality", "=", "surname", []string{"Woolf"}, true},
+		{"inequality", "!=", "surname", []string{"Woolf"}, false},
+		{"in", "IN", "surname", []string{"Carson", "Woolf"}, true},
+		{"not in", "NOT IN", "surname", []string{"Carson", "Woolf"}, false},
+		{"like", "LIKE", "forename", []string{"Virg%"}, true},
+		{"not like", "NOT LIKE", "forename", []string{"V_rginia"}, false},
+		{"escaped wildcard", "LIKE", "forename", []string{`Virgini\_`}, false},
+		{"ilike", "ILIKE", "forename", []string{"virg%"}, true},
+		{"not ilike", "NOT ILIKE", "forename", []string{"VIRGINIA"}, false},
+		{"regex", "~", "surname", []string{"^Wo+lf$"}, true},
+		{"case-insensitive regex", "~*", "surname", []string{"^woolf"}, true},
+		{"between", "BETWEEN", "born", []string{"1800", "1900"}, true},
+		{"not between", "NOT BETWEEN", "born", []string{"1800", "1900"}, false},
+		{"distinct from null", "IS DISTINCT FROM", "died", []string{"1941"}, true},
+		{"not distinct", "IS DISTINCT FROM", "surname", []string{"Woolf"}, false},
+		{"is null", "IS NULL", "died", []string{}, true},
+		{"is not null", "IS NOT NULL", "died", []string{}, false},
+		{"numeric less than", "<", "born", []string{"1900"}, true},
+		{"numeric greater than or equal", ">=", "born", []string{"1900"}, false},
+		{"comparison with null", "<", "died", []string{"1900"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conditions := []rsql.Condition{
+				{Column: rsql.Column{Name: c.column}, Values: c.values, SQLOperator: c.operator},
+			}
+			assert.IsEq(t, matches(t, conditions, row), c.exp)
+		})
+	}
+}
+
+func Test_Matches_JSONPath(t *testing.T) {
+	row := types.RowData{"meta": map[string]any{
+		"tags":      []any{"classic", "poetry"},
+		"publisher": map[string]any{"city": "London"},
+		"pages":     float64(304),
+	}}
+
+	cases := []struct {
+		name     string
+		operator string
+		path     []string
+		values   []string
+		exp      bool
+	}{
+		{"object key", "=", []string{"publisher", "city"}, []string{"London"}, true},
+		{"array index", "=", []string{"tags", "1"}, []string{"poetry"}, true},
+		{"negative array index", "=", []string{"tags", "-2"}, []string{"classic"}, true},
+		{"number", ">", []string{"pages"}, []string{"300"}, true},
+		{"missing key", "IS NULL", []string{"publisher", "country"}, []string{}, true},
+		{"index out of range", "IS NULL", []string{"tags", "2"}, []string{}, true},
+		{"key into array", "IS NOT NULL", []string{"tags", "city"}, []string{}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conditions := []rsql.Condition{
+				{Column: rsql.Column{Name: "meta", Path: c.path}, Values: c.values, SQLOperator: c.operator},
+			}
+			assert.IsEq(t, matches(t, conditions, row), c.exp)
+		})
+	}
+
+	t.Run("scanned json", func(t *testing.T) {
+		row := types.RowData{"meta": json.RawMessage(`{"publisher": {"city": "London"}}`)}
+		conditions := []rsql.Condition{
+			{Column: rsql.Column{Name: "meta", Path: []string{"publisher", "city"}, PathAsText: true}, Values: []string{"London"}, SQLOperator: "="},
+		}
+		assert.IsTrue(t, matches(t, conditions, row))
+	})
+}
+
+func Test_Matches_Array(t *testing.T) {
+	row := types.RowData{"formats": []any{"paperback", "ebook"}, "cover": "paperback"}
+
+	cases := []struct {
+		name     string
+		operator string
+		column   string
+		values   []string
+		exp      bool
+	}{
+		{"contains", "@>", "formats", []string{"ebook"}, true},
+		{"does not contain", "@>", "formats", []string{"ebook", "hardcover"}, false},
+		{"contained by", "<@", "formats", []string{"paperback", "ebook", "hardcover"}, true},
+		{"not contained by", "<@", "formats", []string{"paperback"}, false},
+		{"overlaps", "&&", "formats", []string{"hardcover", "ebook"}, true},
+		{"does not overlap", "&&", "formats", []string{"hardcover"}, false},
+		{"any", "= ANY", "formats", []string{"paperback"}, true},
+		{"not an array", "= ANY", "cover", []string{"paperback"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conditions := []rsql.Condition{
+				{Column: rsql.Column{Name: c.column}, Values: c.values, SQLOperator: c.operator},
+			}
+			assert.IsEq(t, matches(t, conditions, row), c.exp)
+		})
+	}
+}
+
+// Rows fetched from the database have int64 rather than float64 numbers
+func Test_Matches_ScannedRow(t *testing.T) {
+	row := types.RowData{"surname": "Woolf", "born": int64(1882)}
+	conditions := []rsql.Condition{
+		{Column: rsql.Column{Name: "born"}, Values: []string{"900"}, SQLOperator: ">"},
+	}
+	assert.IsTrue(t, matches(t, conditions, row))
+}
+
+func Test_NewFilter_InvalidRegex(t *testing.T) {
+	conditions := []rsql.Condition{
+		{Column: rsql.Column{Name: "surname"}, Values: []string{"^(Wo"}, SQLOperator: "~"},
+	}
+	_, err := changefeed.NewFilter(conditions)
+	assert.ErrorsIs(t, err, apperrors.InvalidConditionValue)
+}
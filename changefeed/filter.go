@@ -0,0 +1,234 @@
+package changefeed
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopgrest/apperrors"
+	"gopgrest/rsql"
+	"gopgrest/types"
+)
+
+// Filter is a change feed's conditions, with the LIKE patterns and regular
+// expressions of their values compiled once rather than for every event
+type Filter struct {
+	conditions []rsql.Condition
+	patterns   []*regexp.Regexp // each condition's compiled pattern, or nil
+}
+
+// NewFilter compiles the patterns of a change feed's conditions. Go's regular
+// expressions are close to, but not the same as, Postgres' POSIX regular
+// expressions, and one that Go can't compile is an error
+func NewFilter(conditions []rsql.Condition) (Filter, error) {
+	f := Filter{conditions: conditions, patterns: make([]*regexp.Regexp, len(conditions))}
+	for i, cond := range conditions {
+		if len(cond.Values) == 0 {
+			continue
+		}
+		var err error
+		switch cond.SQLOperator {
+		case "LIKE", "NOT LIKE":
+			f.patterns[i] = likeRegexp(cond.Values[0], false)
+		case "ILIKE", "NOT ILIKE":
+			f.patterns[i] = likeRegexp(cond.Values[0], true)
+		case "~":
+			f.patterns[i], err = regexp.Compile(cond.Values[0])
+		case "~*":
+			f.patterns[i], err = regexp.Compile("(?i)" + cond.Values[0])
+		}
+		if err != nil {
+			return Filter{}, fmt.Errorf("%w on col %s: %s", apperrors.InvalidConditionValue, cond.Column, err)
+		}
+	}
+	return f, nil
+}
+
+// Matches reports whether a row from an event payload satisfies all of the
+// conditions. The conditions are evaluated in Go against the JSON decoded row
+// rather than in the database, so column qualifiers are ignored
+func (f Filter) Matches(row types.RowData) bool {
+	for i, cond := range f.conditions {
+		val := walkPath(row[cond.Column.Name], cond.Column.Path)
+		if !matchesCondition(cond, f.patterns[i], val) {
+			return false
+		}
+	}
+	return true
+}
+
+// walkPath gets the value at a path of keys or array indexes into a JSON
+// decoded value, or nil if the path doesn't exist, as `->` would in SQL.
+// Negative indexes count from the end of an array. JSON scanned from the
+// database is decoded first
+func walkPath(val any, path []string) any {
+	if raw, ok := val.(json.RawMessage); ok && len(path) > 0 {
+		if err := json.Unmarshal(raw, &val); err != nil {
+			return nil
+		}
+	}
+	for _, key := range path {
+		switch v := val.(type) {
+		case map[string]any:
+			val = v[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil {
+				return nil
+			}
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return nil
+			}
+			val = v[i]
+		default:
+			return nil
+		}
+	}
+	return val
+}
+
+// matchesCondition evaluates a condition on a value, matching its compiled
+// pattern for LIKE and regular expression operators
+func matchesCondition(cond rsql.Condition, pattern *regexp.Regexp, val any) bool {
+	switch cond.SQLOperator {
+	case "IS NULL":
+		return val == nil
+	case "IS NOT NULL":
+		return val != nil
+	case "IS DISTINCT FROM":
+		return val == nil || len(cond.Values) == 0 || compare(val, cond.Values[0]) != 0
+	}
+
+	// Every other operator is false for NULL, as it would be in SQL
+	if val == nil || len(cond.Values) == 0 {
+		return false
+	}
+
+	switch cond.SQLOperator {
+	case "=":
+		return compare(val, cond.Values[0]) == 0
+	case "!=":
+		return compare(val, cond.Values[0]) != 0
+	case "IN":
+		return slices.ContainsFunc(cond.Values, func(v string) bool { return compare(val, v) == 0 })
+	case "NOT IN":
+		return !slices.ContainsFunc(cond.Values, func(v string) bool { return compare(val, v) == 0 })
+	case "LIKE", "ILIKE", "~", "~*":
+		return pattern.MatchString(fmt.Sprintf("%v", val))
+	case "NOT LIKE", "NOT ILIKE":
+		return !pattern.MatchString(fmt.Sprintf("%v", val))
+	case "BETWEEN":
+		return len(cond.Values) == 2 && compare(val, cond.Values[0]) >= 0 && compare(val, cond.Values[1]) <= 0
+	case "NOT BETWEEN":
+		return len(cond.Values) == 2 && (compare(val, cond.Values[0]) < 0 || compare(val, cond.Values[1]) > 0)
+	case "<":
+		return compare(val, cond.Values[0]) < 0
+	case "<=":
+		return compare(val, cond.Values[0]) <= 0
+	case ">":
+		return compare(val, cond.Values[0]) > 0
+	case ">=":
+		return compare(val, cond.Values[0]) >= 0
+	case "@>", "<@", "&&", "= ANY":
+		return matchesArray(cond, val)
+	}
+	return false
+}
+
+// matchesArray evaluates an array operator on an array value, whose elements
+// are compared with the condition's values as with `=`
+func matchesArray(cond rsql.Condition, val any) bool {
+	elems, ok := val.([]any)
+	if !ok {
+		return false
+	}
+	hasElem := func(v string) bool {
+		return slices.ContainsFunc(elems, func(e any) bool { return e != nil && compare(e, v) == 0 })
+	}
+	switch cond.SQLOperator {
+	case "@>":
+		return !slices.ContainsFunc(cond.Values, func(v string) bool { return !hasElem(v) })
+	case "<@":
+		return !slices.ContainsFunc(elems, func(e any) bool {
+			return e == nil || !slices.ContainsFunc(cond.Values, func(v string) bool { return compare(e, v) == 0 })
+		})
+	case "&&":
+		return slices.ContainsFunc(cond.Values, hasElem)
+	case "= ANY":
+		return hasElem(cond.Values[0])
+	}
+	return false
+}
+
+// compare compares a row value with a condition value, numerically if both
+// are numbers and as strings otherwise
+func compare(val any, condVal string) int {
+	if f, ok := toFloat(val); ok {
+		if c, err := strconv.ParseFloat(condVal, 64); err == nil {
+			switch {
+			case f < c:
+				return -1
+			case f > c:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	return strings.Compare(fmt.Sprintf("%v", val), condVal)
+}
+
+// toFloat converts the numeric values found in a row, either decoded from a
+// JSON payload or scanned from the database, to float64
+func toFloat(val any) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// likeRegexp compiles a SQL LIKE pattern, where `%` matches any sequence of
+// characters, `_` matches any single character and `\` escapes the next
+// character, to a regular expression. ILIKE patterns ignore case
+func likeRegexp(pattern string, ignoreCase bool) *regexp.Regexp {
+	var re strings.Builder
+	re.WriteString("(?s)^")
+	if ignoreCase {
+		re.WriteString("(?i)")
+	}
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			re.WriteString(".*")
+		case r == '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String())
+}
@@ -0,0 +1,38 @@
+package changefeed
+
+// ring is a fixed size buffer of the most recent events, oldest first
+type ring struct {
+	events []Event
+	start  int // index of the oldest event
+	count  int // number of events held
+}
+
+func newRing(size int) *ring {
+	return &ring{events: make([]Event, size)}
+}
+
+// push adds an event, overwriting the oldest one if the buffer is full
+func (r *ring) push(e Event) {
+	if len(r.events) == 0 {
+		return
+	}
+	if r.count < len(r.events) {
+		r.events[(r.start+r.count)%len(r.events)] = e
+		r.count++
+		return
+	}
+	r.events[r.start] = e
+	r.start = (r.start + 1) % len(r.events)
+}
+
+// since returns the buffered events with an ID greater than id, oldest first
+func (r *ring) since(id int64) []Event {
+	events := []Event{}
+	for i := range r.count {
+		e := r.events[(r.start+i)%len(r.events)]
+		if e.ID > id {
+			events = append(events, e)
+		}
+	}
+	return events
+}
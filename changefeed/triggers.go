@@ -0,0 +1,89 @@
+package changefeed
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"gopgrest/repository"
+	"gopgrest/sqlbuilder"
+)
+
+// notifyFunction sends a JSON payload describing the changed row on CHANNEL.
+// The trigger's arguments are the columns of the table's primary key: the pk
+// is the value of a single column, an object of the values of several
+// columns, or null if the table has no primary key. NOTIFY payloads are
+// limited to 8000 bytes, so the row is left out of the payload for large rows
+// and only the primary key is sent
+var notifyFunction = fmt.Sprintf(`
+CREATE OR REPLACE FUNCTION gopgrest_notify_change() RETURNS trigger AS $$
+DECLARE
+    changed jsonb;
+    pk jsonb;
+    payload jsonb;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed := to_jsonb(OLD);
+    ELSE
+        changed := to_jsonb(NEW);
+    END IF;
+    IF TG_NARGS = 1 THEN
+        pk := changed->TG_ARGV[0];
+    ELSIF TG_NARGS > 1 THEN
+        pk := '{}'::jsonb;
+        FOR i IN 0 .. TG_NARGS - 1 LOOP
+            pk := pk || jsonb_build_object(TG_ARGV[i], changed->TG_ARGV[i]);
+        END LOOP;
+    END IF;
+    payload := jsonb_build_object(
+        'schema', TG_TABLE_SCHEMA,
+        'table', TG_TABLE_NAME,
+        'op', lower(TG_OP),
+        'pk', pk,
+        'row', changed
+    );
+    IF octet_length(payload::text) > 7900 THEN
+        payload := payload - 'row';
+    END IF;
+    PERFORM pg_notify('%s', payload::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`, CHANNEL)
+
+// notifyTrigger attaches gopgrest_notify_change to a table, passing it the
+// columns of the table's primary key
+const notifyTrigger = `
+CREATE OR REPLACE TRIGGER gopgrest_changes
+AFTER INSERT OR UPDATE OR DELETE ON %s
+FOR EACH ROW EXECUTE FUNCTION gopgrest_notify_change(%s)`
+
+// InstallTriggers creates the notify function and adds a trigger to each
+// table that sends a notification for every inserted, updated or deleted row.
+// Views can't have row triggers, changes made through a view are sent by the
+// trigger on the underlying table
+func InstallTriggers(db repository.QueryExecutor, tables []repository.Table) error {
+	if _, err := db.Exec(notifyFunction); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if t.Kind != repository.TABLE {
+			continue
+		}
+		table := sqlbuilder.QuoteIdent(t.Schema, t.Name)
+		pk := []string{}
+		for _, col := range t.PrimaryKey {
+			pk = append(pk, quoteLiteral(col))
+		}
+		if _, err := db.Exec(fmt.Sprintf(notifyTrigger, table, strings.Join(pk, ", "))); err != nil {
+			return err
+		}
+		log.Printf("Installed change trigger on %s\n", t.QualifiedName())
+	}
+	return nil
+}
+
+// quoteLiteral quotes a string as a SQL string literal, as trigger arguments
+// can't be passed as placeholders
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -39,3 +39,159 @@ VALUES
 ('The Tenant of Wildfell Hall', '2', '2'),
 ('To The Lighthouse', '3', '1'),
 ('Mrs. Dalloway', '3', null);
+
+-- CREATE TYPE has no IF NOT EXISTS
+DO $$ BEGIN
+    CREATE TYPE cover_type AS ENUM ('hardcover', 'paperback');
+EXCEPTION WHEN duplicate_object THEN null;
+END $$;
+
+CREATE TABLE IF NOT EXISTS editions (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    book_id integer NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    published date,
+    price numeric(6, 2) CHECK (price >= 0),
+    in_print boolean NOT NULL DEFAULT true,
+    added timestamptz NOT NULL DEFAULT now(),
+    ref uuid,
+    formats text[],
+    cover cover_type,
+    meta jsonb,
+    note varchar(10),
+    search tsvector
+);
+
+INSERT INTO editions (book_id, published, price, in_print, added, ref, formats, cover, meta)
+VALUES
+('1', '1998-01-01', 15.00, true, '2024-01-15 10:00:00+00', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', '{paperback,ebook}', 'paperback', '{"tags": ["classic", "poetry"], "publisher": {"name": "Vintage", "city": "New York"}, "pages": 304}'),
+('3', '1927-05-05', 120.50, false, '2024-02-01 12:30:00+00', null, '{hardcover}', 'hardcover', '{"tags": ["classic"], "publisher": {"name": "Hogarth Press", "city": "London"}, "pages": 209}'),
+('4', '1925-05-14', 9.99, true, '2024-03-10 08:00:00+00', null, '{paperback}', 'paperback', null);
+
+-- Auto-updatable view, writable through the API
+CREATE OR REPLACE VIEW nineteenth_century_authors AS
+SELECT * FROM authors WHERE born >= 1800 AND born < 1900;
+
+-- Views with joins are not auto-updatable, read-only through the API
+CREATE OR REPLACE VIEW book_authors AS
+SELECT books.id, title, surname, forename FROM books
+JOIN authors ON books.author_id = authors.id;
+
+-- DISTINCT views are not auto-updatable, but its triggers let rows be updated
+-- and deleted through the API. Without an INSERT trigger it can't be inserted
+-- into
+CREATE OR REPLACE VIEW genre_names AS
+SELECT DISTINCT id, name FROM genres;
+
+CREATE OR REPLACE FUNCTION genre_names_write() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        UPDATE genres SET name = NEW.name WHERE id = OLD.id;
+        RETURN NEW;
+    END IF;
+    DELETE FROM genres WHERE id = OLD.id;
+    RETURN OLD;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS genre_names_write ON genre_names;
+CREATE TRIGGER genre_names_write INSTEAD OF UPDATE OR DELETE ON genre_names
+FOR EACH ROW EXECUTE FUNCTION genre_names_write();
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS genre_counts AS
+SELECT genres.id, genres.name, count(books.id) AS book_count FROM genres
+LEFT JOIN books ON books.genre_id = genres.id
+GROUP BY genres.id;
+
+-- Required to refresh the view concurrently
+CREATE UNIQUE INDEX IF NOT EXISTS genre_counts_id ON genre_counts (id);
+
+-- Set-returning function, callable with GET and RSQL query params
+CREATE OR REPLACE FUNCTION books_by_author(author_surname text)
+RETURNS SETOF books AS $$
+    SELECT books.* FROM books
+    JOIN authors ON books.author_id = authors.id
+    WHERE authors.surname = author_surname
+$$ LANGUAGE sql STABLE;
+
+-- Scalar function with a default argument
+CREATE OR REPLACE FUNCTION lifespan(author integer, if_living integer DEFAULT NULL)
+RETURNS integer AS $$
+    SELECT coalesce(died - born, if_living) FROM authors WHERE id = author
+$$ LANGUAGE sql STABLE;
+
+-- Echoes numbers as text, to check their precision is kept
+CREATE OR REPLACE FUNCTION numbers_text(big bigint, exact numeric)
+RETURNS text AS $$
+    SELECT big || ' ' || exact
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Volatile function, only callable with POST
+CREATE OR REPLACE FUNCTION add_genre(genre_name text)
+RETURNS integer AS $$
+    INSERT INTO genres (name) VALUES (genre_name) RETURNING id
+$$ LANGUAGE sql VOLATILE;
+
+-- Shares a name with the archive schema, so it's only reachable at
+-- /public/archive
+CREATE TABLE IF NOT EXISTS archive (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    shelf varchar(50) NOT NULL
+);
+
+INSERT INTO archive (shelf) VALUES ('Basement');
+
+-- Second schema, exposed alongside public in tests. Its authors table shares
+-- a name with public.authors
+CREATE SCHEMA IF NOT EXISTS archive;
+
+CREATE TABLE IF NOT EXISTS archive.authors (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    surname varchar(150) NOT NULL,
+    forename varchar(150) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS archive.letters (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    title varchar(500) NOT NULL,
+    author_id integer NOT NULL REFERENCES archive.authors (id) ON DELETE CASCADE
+);
+
+-- Primary key of two columns, neither named id
+CREATE TABLE IF NOT EXISTS archive.recipients (
+    letter_id integer NOT NULL REFERENCES archive.letters (id) ON DELETE CASCADE,
+    recipient varchar(150) NOT NULL,
+    PRIMARY KEY (letter_id, recipient)
+);
+
+INSERT INTO archive.authors (surname, forename)
+VALUES
+('Sappho', ''),
+('Seneca', 'Lucius Annaeus');
+
+INSERT INTO archive.letters (title, author_id)
+VALUES
+('Moral Letters to Lucilius', '2');
+
+INSERT INTO archive.recipients (letter_id, recipient)
+VALUES
+(1, 'Lucilius');
+
+-- Names that must be quoted: a reserved word, mixed case and non-ASCII
+CREATE TABLE IF NOT EXISTS archive."user" (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    "DisplayName" varchar(150) NOT NULL,
+    "order" integer
+);
+
+CREATE TABLE IF NOT EXISTS archive."éditeurs" (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    nom varchar(150) NOT NULL
+);
+
+INSERT INTO archive."user" ("DisplayName", "order")
+VALUES
+('Anne', 2),
+('Virginia', 1);
+
+INSERT INTO archive."éditeurs" (nom)
+VALUES
+('Gallimard');
@@ -7,12 +7,16 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	"gopgrest/api"
+	"gopgrest/changefeed"
 	"gopgrest/repository"
+	"gopgrest/service"
 )
 
 func startServer() {
@@ -40,11 +44,40 @@ func startServer() {
 		panic(err)
 	}
 
-	tables, err := repository.GetPublicTables(db)
+	schemas := lookupSchemas()
+	tables, err := repository.GetTables(db, schemas)
 	if err != nil {
 		panic(err)
 	}
-	APIHandler := api.NewAPIHandler(db, tables)
+	functions, err := repository.GetFunctions(db, schemas)
+	if err != nil {
+		panic(err)
+	}
+	APIHandler := api.NewAPIHandler(db, tables, functions, schemas)
+	// Optionally output numeric values as JSON numbers rather than strings
+	APIHandler.Service.Decoder.NumericAsNumber = os.Getenv("NUMERIC_AS_NUMBER") == "true"
+
+	// Optionally serve the saved queries of a JSON file at /_queries/{name}
+	if path := os.Getenv("SAVED_QUERIES"); path != "" {
+		queries, err := service.ReadSavedQueries(path)
+		if err != nil {
+			panic(err)
+		}
+		if err := APIHandler.Service.SetSavedQueries(queries); err != nil {
+			panic(err)
+		}
+	}
+
+	// Optionally stream table changes over LISTEN/NOTIFY
+	if os.Getenv("CHANGE_FEED") == "true" {
+		APIHandler.Changes = startChangeFeed(db, dbparams, tables)
+	}
+	// Comma separated origins of other sites allowed to open WebSockets
+	if origins := os.Getenv("ALLOWED_ORIGINS"); origins != "" {
+		for origin := range strings.SplitSeq(origins, ",") {
+			APIHandler.AllowedOrigins = append(APIHandler.AllowedOrigins, strings.TrimSpace(origin))
+		}
+	}
 
 	// Create server and routes
 	mux := http.NewServeMux()
@@ -55,6 +88,22 @@ func startServer() {
 	http.ListenAndServe(":"+apiport, mux)
 }
 
+// startChangeFeed installs the change triggers on each table and starts
+// listening for their notifications
+func startChangeFeed(db *sql.DB, dbparams string, tables []repository.Table) *changefeed.Broker {
+	err := changefeed.InstallTriggers(db, tables)
+	if err != nil {
+		panic(err)
+	}
+	listener := pq.NewListener(dbparams, 10*time.Second, time.Minute, nil)
+	broker := changefeed.NewBroker(changefeed.BUFFER_SIZE)
+	err = broker.Listen(listener)
+	if err != nil {
+		panic(err)
+	}
+	return broker
+}
+
 func main() {
 	go startServer()
 
@@ -71,6 +120,20 @@ func makeQuitListener() chan os.Signal {
 	return quit
 }
 
+// lookupSchemas gets the comma separated list of schemas to expose from the
+// optional DB_SCHEMAS variable. The first schema is the default schema
+func lookupSchemas() []string {
+	schemasVar := os.Getenv("DB_SCHEMAS")
+	if schemasVar == "" {
+		return []string{repository.DEFAULT_SCHEMA}
+	}
+	schemas := []string{}
+	for schema := range strings.SplitSeq(schemasVar, ",") {
+		schemas = append(schemas, strings.TrimSpace(schema))
+	}
+	return schemas
+}
+
 func lookupEnv(varName string) string {
 	varValue, exists := os.LookupEnv(varName)
 	if !exists {
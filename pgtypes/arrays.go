@@ -0,0 +1,159 @@
+package pgtypes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseArray parses Postgres' text output of an array, e.g. `{a,"b c",NULL}`,
+// into a slice of its elements. Elements are strings, nil for NULL, or slices
+// for the inner arrays of multidimensional arrays
+func ParseArray(text string) ([]any, error) {
+	// Arrays with custom bounds are prefixed with their dimensions, e.g.
+	// `[0:1]={a,b}`
+	if strings.HasPrefix(text, "[") {
+		_, text, _ = strings.Cut(text, "=")
+	}
+	p := arrayParser{text: text}
+	elems, err := p.parseArray()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.text) {
+		return nil, fmt.Errorf("unexpected %q after array", p.text[p.pos:])
+	}
+	return elems, nil
+}
+
+// arrayParser parses an array's text output from pos
+type arrayParser struct {
+	text string
+	pos  int
+}
+
+func (p *arrayParser) parseArray() ([]any, error) {
+	if !p.consume('{') {
+		return nil, fmt.Errorf("expected { at %d in %s", p.pos, p.text)
+	}
+	elems := []any{}
+	if p.consume('}') {
+		return elems, nil
+	}
+	for {
+		if p.pos >= len(p.text) {
+			return nil, fmt.Errorf("unterminated array %s", p.text)
+		}
+		switch p.text[p.pos] {
+		case '{':
+			inner, err := p.parseArray()
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, inner)
+		case '"':
+			elem, err := p.parseQuoted()
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		default:
+			start := p.pos
+			for p.pos < len(p.text) && p.text[p.pos] != ',' && p.text[p.pos] != '}' {
+				p.pos++
+			}
+			elem := p.text[start:p.pos]
+			if strings.EqualFold(elem, "NULL") {
+				elems = append(elems, nil)
+			} else {
+				elems = append(elems, elem)
+			}
+		}
+
+		if p.consume(',') {
+			continue
+		}
+		if p.consume('}') {
+			return elems, nil
+		}
+		return nil, fmt.Errorf("expected , or } at %d in %s", p.pos, p.text)
+	}
+}
+
+// parseQuoted parses a double quoted element, where `\` escapes the next
+// character
+func (p *arrayParser) parseQuoted() (string, error) {
+	p.pos++
+	var elem strings.Builder
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			if p.pos < len(p.text) {
+				elem.WriteByte(p.text[p.pos])
+				p.pos++
+			}
+		case '"':
+			return elem.String(), nil
+		default:
+			elem.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted element in %s", p.text)
+}
+
+// consume advances past c if it is the next character
+func (p *arrayParser) consume(c byte) bool {
+	if p.pos < len(p.text) && p.text[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// EncodeArray encodes a slice of values, e.g. a JSON array from a request
+// body, as a Postgres array literal, e.g. `{"a","b c",NULL}`. Nested slices
+// are encoded as inner arrays, and objects as JSON strings
+func EncodeArray(elems []any) (string, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, elem := range elems {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		switch e := elem.(type) {
+		case nil:
+			b.WriteString("NULL")
+		case []any:
+			inner, err := EncodeArray(e)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(inner)
+		case string:
+			b.WriteString(quoteArrayElem(e))
+		case bool:
+			b.WriteString(strconv.FormatBool(e))
+		case float64:
+			b.WriteString(strconv.FormatFloat(e, 'f', -1, 64))
+		case json.Number:
+			b.WriteString(e.String())
+		default:
+			encoded, err := json.Marshal(e)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(quoteArrayElem(string(encoded)))
+		}
+	}
+	b.WriteByte('}')
+	return b.String(), nil
+}
+
+// quoteArrayElem double quotes an array element, escaping `"` and `\`
+func quoteArrayElem(elem string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(elem)
+	return `"` + escaped + `"`
+}
@@ -0,0 +1,53 @@
+package pgtypes
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Encode converts a value from a JSON request body, decoded with
+// json.Decoder.UseNumber, to a value the driver can send for a column of a
+// type. It reverses Decode:
+//   - any value for a json or jsonb column is sent as JSON, so strings are
+//     stored as JSON strings
+//   - arrays are sent as Postgres array literals
+//   - numbers are sent as integers or floats for those types, or else as
+//     their exact text, so numeric values keep their precision
+//
+// Strings for any other type are sent as they are for Postgres to parse,
+// e.g. "\\x0102" for a bytea or "[1,10)" for an int4range
+func Encode(dbType string, val any) (any, error) {
+	if val == nil {
+		return nil, nil
+	}
+	if dbType == "JSON" || dbType == "JSONB" {
+		encoded, err := json.Marshal(val)
+		return string(encoded), err
+	}
+
+	switch v := val.(type) {
+	case []any:
+		return EncodeArray(v)
+	case map[string]any:
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	case json.Number:
+		switch dbType {
+		case "INT2", "INT4", "INT8":
+			if i, err := v.Int64(); err == nil {
+				return i, nil
+			}
+			// Whole numbers may be written with a fraction or exponent, e.g.
+			// `1.0` or `1e3`
+			f, err := v.Float64()
+			if err != nil || f != float64(int64(f)) {
+				return nil, fmt.Errorf("invalid integer %s", v)
+			}
+			return int64(f), nil
+		case "FLOAT4", "FLOAT8":
+			return v.Float64()
+		}
+		return v.String(), nil
+	}
+	return val, nil
+}
@@ -0,0 +1,144 @@
+package pgtypes
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts of date and time values in JSON output. Timestamps with a time zone
+// are output as RFC 3339
+const (
+	TIME_LAYOUT      = "15:04:05.999999"
+	TIMETZ_LAYOUT    = "15:04:05.999999Z07:00"
+	TIMESTAMP_LAYOUT = "2006-01-02T15:04:05.999999"
+)
+
+// Layouts of timestamps in Postgres' text output, e.g. in arrays
+const (
+	pgTimestampLayout   = "2006-01-02 15:04:05.999999"
+	pgTimestamptzLayout = "2006-01-02 15:04:05.999999Z07"
+)
+
+// Decoder converts the values scanned from the driver to values that marshal
+// to JSON in the same form they are accepted as input, keyed on the column's
+// type name as reported by the driver, e.g. `NUMERIC` or `_INT4`
+type Decoder struct {
+	// NumericAsNumber outputs numeric values as JSON numbers rather than
+	// strings, which can lose precision in clients that parse numbers as
+	// floats
+	NumericAsNumber bool
+}
+
+// Decode converts a scanned value of a type to a value that marshals to JSON:
+//   - numeric as a string, e.g. "9.99", or a number with NumericAsNumber
+//   - json and jsonb as embedded JSON
+//   - arrays as JSON arrays of their decoded elements
+//   - date as "2006-01-02", time as "15:04:05" and timestamp without time
+//     zone as "2006-01-02T15:04:05"
+//   - bytea as a hex string, e.g. "\\x0102"
+//   - non-finite floats as "NaN", "Infinity" and "-Infinity"
+//   - any other type, e.g. uuid, interval, inet or ranges, as the string
+//     Postgres outputs for it, e.g. "[1,10)" for an int4range
+func (d Decoder) Decode(dbType string, val any) any {
+	switch v := val.(type) {
+	case time.Time:
+		switch dbType {
+		case "DATE":
+			return v.Format(time.DateOnly)
+		case "TIME":
+			return v.Format(TIME_LAYOUT)
+		case "TIMETZ":
+			return v.Format(TIMETZ_LAYOUT)
+		case "TIMESTAMP":
+			return v.Format(TIMESTAMP_LAYOUT)
+		}
+	case float64:
+		return decodeFloat(v)
+	case []byte:
+		if dbType == "BYTEA" {
+			return `\x` + hex.EncodeToString(v)
+		}
+		if elemType, isArray := strings.CutPrefix(dbType, "_"); isArray {
+			elems, err := ParseArray(string(v))
+			if err != nil {
+				return string(v)
+			}
+			return d.decodeElems(elemType, elems)
+		}
+		return d.decodeText(dbType, string(v))
+	}
+	return val
+}
+
+// decodeElems decodes the elements of a parsed array, which are nil, strings
+// or nested arrays
+func (d Decoder) decodeElems(elemType string, elems []any) []any {
+	decoded := make([]any, len(elems))
+	for i, elem := range elems {
+		switch e := elem.(type) {
+		case string:
+			decoded[i] = d.decodeText(elemType, e)
+		case []any:
+			decoded[i] = d.decodeElems(elemType, e)
+		}
+	}
+	return decoded
+}
+
+// decodeText decodes a value of a type from Postgres' text output. Values
+// that can't be parsed are left as strings
+func (d Decoder) decodeText(dbType, text string) any {
+	switch dbType {
+	case "INT2", "INT4", "INT8":
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i
+		}
+	case "FLOAT4", "FLOAT8":
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return decodeFloat(f)
+		}
+	case "BOOL":
+		switch text {
+		case "t":
+			return true
+		case "f":
+			return false
+		}
+	case "NUMERIC":
+		// NaN and infinities aren't valid JSON numbers
+		if d.NumericAsNumber && !strings.ContainsAny(text, "NI") {
+			return json.Number(text)
+		}
+	case "JSON", "JSONB":
+		if json.Valid([]byte(text)) {
+			return json.RawMessage(text)
+		}
+	case "TIMESTAMP":
+		if t, err := time.Parse(pgTimestampLayout, text); err == nil {
+			return t.Format(TIMESTAMP_LAYOUT)
+		}
+	case "TIMESTAMPTZ":
+		if t, err := time.Parse(pgTimestamptzLayout, text); err == nil {
+			return t
+		}
+	}
+	return text
+}
+
+// decodeFloat returns non-finite floats, which can't be marshalled to JSON,
+// as the strings Postgres accepts for them
+func decodeFloat(f float64) any {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
@@ -0,0 +1,110 @@
+package pgtypes_test
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopgrest/assert"
+	"gopgrest/pgtypes"
+)
+
+func Test_Decode(t *testing.T) {
+	date := time.Date(1998, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name   string
+		dbType string
+		val    any
+		exp    any
+	}{
+		{"numeric", "NUMERIC", []byte("15.00"), "15.00"},
+		{"json", "JSONB", []byte(`{"a": [1, 2]}`), json.RawMessage(`{"a": [1, 2]}`)},
+		{"uuid", "UUID", []byte("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"), "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{"interval", "INTERVAL", []byte("1 day 02:00:00"), "1 day 02:00:00"},
+		{"range", "INT4RANGE", []byte("[1,10)"), "[1,10)"},
+		{"inet", "INET", []byte("192.168.0.1/24"), "192.168.0.1/24"},
+		{"bytea", "BYTEA", []byte{0x01, 0xab}, `\x01ab`},
+		{"date", "DATE", date, "1998-01-01"},
+		{"timestamp", "TIMESTAMP", date.Add(90 * time.Minute), "1998-01-01T01:30:00"},
+		{"timestamptz", "TIMESTAMPTZ", date, date},
+		{"NaN", "FLOAT8", math.NaN(), "NaN"},
+		{"infinity", "FLOAT8", math.Inf(-1), "-Infinity"},
+		{"text array", "_TEXT", []byte(`{paperback,"e book",NULL}`), []any{"paperback", "e book", nil}},
+		{"escaped array", "_TEXT", []byte(`{"say \"hi\"","back\\slash"}`), []any{`say "hi"`, `back\slash`}},
+		{"int array", "_INT4", []byte("{{1,2},{3,4}}"), []any{[]any{int64(1), int64(2)}, []any{int64(3), int64(4)}}},
+		{"bool array", "_BOOL", []byte("{t,f}"), []any{true, false}},
+		{"empty array", "_INT4", []byte("{}"), []any{}},
+		{"array with bounds", "_INT4", []byte("[0:1]={1,2}"), []any{int64(1), int64(2)}},
+		{"unknown type", "", []byte("hardcover"), "hardcover"},
+		{"null", "NUMERIC", nil, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := pgtypes.Decoder{}.Decode(c.dbType, c.val)
+			assert.IsTrue(t, reflect.DeepEqual(got, c.exp))
+		})
+	}
+
+	t.Run("numeric as number", func(t *testing.T) {
+		decoder := pgtypes.Decoder{NumericAsNumber: true}
+		assert.IsEq(t, decoder.Decode("NUMERIC", []byte("15.00")), any(json.Number("15.00")))
+		assert.IsEq(t, decoder.Decode("NUMERIC", []byte("NaN")), any("NaN"))
+		got, err := json.Marshal(decoder.Decode("_NUMERIC", []byte("{1.50,2}")))
+		assert.Try(t, err)
+		assert.IsEq(t, string(got), "[1.50,2]")
+	})
+}
+
+func Test_ParseArray_Invalid(t *testing.T) {
+	for _, text := range []string{"", "a,b", "{a,b", `{"a}`, "{a}b", "{a b"} {
+		t.Run(text, func(t *testing.T) {
+			_, err := pgtypes.ParseArray(text)
+			assert.IsTrue(t, err != nil)
+		})
+	}
+}
+
+func Test_Encode(t *testing.T) {
+	cases := []struct {
+		name   string
+		dbType string
+		val    any
+		exp    any
+	}{
+		{"integer", "INT4", json.Number("1947"), int64(1947)},
+		{"whole number", "INT4", json.Number("1e3"), int64(1000)},
+		{"float", "FLOAT8", json.Number("1.5"), 1.5},
+		{"numeric keeps precision", "NUMERIC", json.Number("12345678901234567890.12"), "12345678901234567890.12"},
+		{"json object", "JSONB", map[string]any{"a": []any{json.Number("1")}}, `{"a":[1]}`},
+		{"json string", "JSON", "text", `"text"`},
+		{"array", "_TEXT", []any{"a", `b "c"`, nil}, `{"a","b \"c\"",NULL}`},
+		{"nested array", "_INT4", []any{[]any{json.Number("1")}, []any{json.Number("2")}}, "{{1},{2}}"},
+		{"array literal", "_TEXT", "{a,b}", "{a,b}"},
+		{"string", "UUID", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{"null", "JSONB", nil, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := pgtypes.Encode(c.dbType, c.val)
+			assert.Try(t, err)
+			assert.IsEq(t, got, c.exp)
+		})
+	}
+
+	t.Run("fractional integer", func(t *testing.T) {
+		_, err := pgtypes.Encode("INT4", json.Number("1.5"))
+		assert.IsTrue(t, err != nil)
+	})
+}
+
+// Arrays decode to the same elements they were encoded from
+func Test_EncodeArray_RoundTrip(t *testing.T) {
+	elems := []any{"plain", "with space", `quote " and \ backslash`, "{braces}", "NULL", nil, ""}
+	encoded, err := pgtypes.EncodeArray(elems)
+	assert.Try(t, err)
+	decoded, err := pgtypes.ParseArray(encoded)
+	assert.Try(t, err)
+	assert.IsTrue(t, reflect.DeepEqual(decoded, elems))
+}
@@ -2,11 +2,21 @@ package repatterns
 
 import "regexp"
 
+// Ident matches a table, column or function name in a URL. Unlike `\w`, it
+// matches non-ASCII letters and digits
+const Ident = `[\p{L}\p{N}_]+`
+
 var (
-	ReqWithId         = regexp.MustCompile(`^/(\w+)/([0-9]+)$`)
-	ReqOptionalParams = regexp.MustCompile(`^/(\w+)(\?.*)?$`)
-	ReqNoParams       = regexp.MustCompile(`^/(\w+)/?$`)
-	ReqHasParams      = regexp.MustCompile(`^/(\w+)\?(.*)$`)
+	ReqWithId         = regexp.MustCompile(`^/(` + Ident + `)/([0-9]+)$`)
+	ReqOptionalParams = regexp.MustCompile(`^/(` + Ident + `)(\?.*)?$`)
+	ReqNoParams       = regexp.MustCompile(`^/(` + Ident + `)/?$`)
+	ReqHasParams      = regexp.MustCompile(`^/(` + Ident + `)\?(.*)$`)
+	ReqChanges        = regexp.MustCompile(`^/(` + Ident + `)/_changes/?$`)
+	ReqSubscribe      = regexp.MustCompile(`^/_changes/?$`)
+	ReqRefresh        = regexp.MustCompile(`^/(` + Ident + `)/_refresh/?$`)
+	ReqRPC            = regexp.MustCompile(`^/rpc/(` + Ident + `)/?$`)
+	ReqSavedQuery     = regexp.MustCompile(`^/_queries/(` + Ident + `)/?$`)
+	ReqSchemaPrefix   = regexp.MustCompile(`^/(` + Ident + `)(/.*)?$`)
 
 	TrailingChars = regexp.MustCompile(`/?\??$`)
 )
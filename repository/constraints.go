@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"github.com/lib/pq"
+
+	"gopgrest/sqlbuilder"
+)
+
+// ColumnConstraints are the constraints on a column's values, used to
+// validate request bodies before they are sent to Postgres.
+// MaxLength is the maximum number of characters of a varchar or char column,
+// and Precision and Scale are the digits of a numeric column, each 0 if the
+// column has no limit. For array columns they apply to each element.
+// EnumLabels are the labels of an enum column, in order.
+// CHECK constraints aren't read, they are left for Postgres to enforce
+type ColumnConstraints struct {
+	NotNull    bool
+	HasDefault bool
+	MaxLength  int
+	Precision  int
+	Scale      int
+	EnumLabels []string
+}
+
+// Required reports whether a value must be given for the column on insert
+func (c ColumnConstraints) Required() bool {
+	return c.NotNull && !c.HasDefault
+}
+
+// columnConstraintsQuery lists the constraints of each column of the relation
+// passed as $1. Length and precision are decoded from the type modifier of
+// the column, or of its elements if it is an array. Identity and generated
+// columns count as having a default
+const columnConstraintsQuery = `
+SELECT a.attname,
+    a.attnotnull,
+    a.atthasdef OR a.attidentity <> '' OR a.attgenerated <> '',
+    CASE WHEN et.typname IN ('varchar', 'bpchar') AND a.atttypmod > 0
+        THEN a.atttypmod - 4 ELSE 0 END,
+    CASE WHEN et.typname = 'numeric' AND a.atttypmod > 0
+        THEN ((a.atttypmod - 4) >> 16) & 65535 ELSE 0 END,
+    CASE WHEN et.typname = 'numeric' AND a.atttypmod > 0
+        THEN (a.atttypmod - 4) & 65535 ELSE 0 END,
+    ARRAY(
+        SELECT e.enumlabel::text FROM pg_catalog.pg_enum e
+        WHERE e.enumtypid = et.oid ORDER BY e.enumsortorder
+    )
+FROM pg_catalog.pg_attribute a
+JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+JOIN pg_catalog.pg_type et
+    ON et.oid = CASE WHEN t.typcategory = 'A' THEN t.typelem ELSE t.oid END
+WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped`
+
+// getColumnConstraints gets the constraints of each column of a table by
+// column name
+func getColumnConstraints(db QueryExecutor, schema, tableName string) (map[string]ColumnConstraints, error) {
+	rows, err := db.Query(columnConstraintsQuery, sqlbuilder.QuoteIdent(schema, tableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	constraints := map[string]ColumnConstraints{}
+	for rows.Next() {
+		var name string
+		var c ColumnConstraints
+		err := rows.Scan(
+			&name,
+			&c.NotNull,
+			&c.HasDefault,
+			&c.MaxLength,
+			&c.Precision,
+			&c.Scale,
+			pq.Array(&c.EnumLabels),
+		)
+		if err != nil {
+			return nil, err
+		}
+		constraints[name] = c
+	}
+	return constraints, rows.Err()
+}
+
+// primaryKeyQuery lists the columns of the primary key of the relation passed
+// as $1, in the key's order
+const primaryKeyQuery = `
+SELECT a.attname FROM pg_catalog.pg_index i
+JOIN pg_catalog.pg_attribute a
+    ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+WHERE i.indrelid = $1::regclass AND i.indisprimary
+ORDER BY array_position(i.indkey::int2[], a.attnum)`
+
+// getPrimaryKey gets the columns of a table's primary key, which are none if
+// it has no primary key
+func getPrimaryKey(db QueryExecutor, schema, tableName string) ([]string, error) {
+	rows, err := db.Query(primaryKeyQuery, sqlbuilder.QuoteIdent(schema, tableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
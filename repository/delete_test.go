@@ -13,7 +13,7 @@ func Test_DeleteRowsByRSQL(t *testing.T) {
 	// DELETE /authors?...
 	t.Run("No query", func(t *testing.T) {
 		repo := tests.NewTestRepo(t)
-		deletedIDs, err := repo.DeleteRowsByRSQL("authors", []rsql.Condition{})
+		deletedIDs, err := repo.DeleteRowsByRSQL("authors", rsql.QueryParams{})
 		assert.ErrorsIs(t, err, apperrors.DeleteWithNoConditions)
 		assert.IsTrue(t, len(deletedIDs) == 0)
 	})
@@ -27,7 +27,7 @@ func Test_DeleteRowsByRSQL(t *testing.T) {
 		conditions := []rsql.Condition{
 			{Column: rsql.Column{Name: "forename"}, Values: []string{"Anne"}, SQLOperator: "="},
 		}
-		deletedIDs, err := repo.DeleteRowsByRSQL("authors", conditions)
+		deletedIDs, err := repo.DeleteRowsByRSQL("authors", rsql.QueryParams{Conditions: conditions})
 		assert.Try(t, err)
 		assert.IsEq(t, len(deletedIDs), expCount)
 
@@ -46,7 +46,7 @@ func Test_DeleteRowsByRSQL(t *testing.T) {
 			{Column: rsql.Column{Name: "forename"}, Values: []string{"Anne"}, SQLOperator: "="},
 			{Column: rsql.Column{Name: "born"}, Values: []string{"1900"}, SQLOperator: "<"},
 		}
-		deletedIDs, err := repo.DeleteRowsByRSQL("authors", conditions)
+		deletedIDs, err := repo.DeleteRowsByRSQL("authors", rsql.QueryParams{Conditions: conditions})
 		assert.Try(t, err)
 
 		assert.IsEq(t, len(deletedIDs), expCount)
@@ -58,4 +58,37 @@ func Test_DeleteRowsByRSQL(t *testing.T) {
 		)
 		assert.IsTrue(t, len(gotRows) == 0)
 	})
+	// DELETE /books?authors.born<1900&join=authors:books.author_id==authors.id
+	t.Run("Delete using a joined table", func(t *testing.T) {
+		repo := tests.NewTestRepo(t)
+		expCount, err := tests.CountRows(repo, "books", "JOIN authors ON books.author_id = authors.id WHERE born < 1900")
+		assert.Try(t, err)
+
+		query := rsql.QueryParams{
+			Conditions: []rsql.Condition{
+				{Column: rsql.Column{Qualifier: "authors", Name: "born"}, Values: []string{"1900"}, SQLOperator: "<"},
+			},
+			Joins: []rsql.JoinRelation{{
+				Type:  "JOIN",
+				Table: "authors",
+				On: []rsql.JoinOn{{
+					Left:  rsql.Column{Qualifier: "books", Name: "author_id"},
+					Right: rsql.Column{Qualifier: "authors", Name: "id"},
+				}},
+			}},
+		}
+		deletedIDs, err := repo.DeleteRowsByRSQL("books", query)
+		assert.Try(t, err)
+		assert.IsEq(t, len(deletedIDs), expCount)
+
+		// Confirm books no longer in DB, while their authors are
+		gotRows, err := tests.SelectRows(
+			repo,
+			"SELECT * FROM books JOIN authors ON books.author_id = authors.id WHERE born < 1900",
+		)
+		assert.IsTrue(t, len(gotRows) == 0)
+		authorCount, err := tests.CountRows(repo, "authors", "WHERE born < 1900")
+		assert.Try(t, err)
+		assert.IsTrue(t, authorCount > 0)
+	})
 }
@@ -0,0 +1,272 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"gopgrest/apperrors"
+	"gopgrest/rsql"
+	"gopgrest/sqlbuilder"
+)
+
+// Function volatility categories, see
+// https://www.postgresql.org/docs/current/xfunc-volatility.html
+const (
+	IMMUTABLE = "i"
+	STABLE    = "s"
+	VOLATILE  = "v"
+)
+
+// FunctionArg is an input argument of a Function. Type is the argument's type
+// as formatted by Postgres, e.g. `integer` or `text[]`
+type FunctionArg struct {
+	Name       string
+	Type       string
+	HasDefault bool
+}
+
+// Function represents a function in the database that can be called over RPC.
+// Columns holds the names of the columns of the function's result, which is
+// either the function's OUT/TABLE arguments, the columns of a returned table
+// type, or a single column named after the function
+type Function struct {
+	Schema     string
+	Name       string
+	Args       []FunctionArg
+	ReturnType string
+	ReturnsSet bool
+	Volatility string
+	Columns    []string
+}
+
+// IsReadOnly reports whether the function can be called with GET, i.e. it is
+// STABLE or IMMUTABLE and won't modify the database
+func (f *Function) IsReadOnly() bool {
+	return f.Volatility != VOLATILE
+}
+
+// GetArg gets an input argument by name
+func (f *Function) GetArg(name string) (FunctionArg, bool) {
+	for _, arg := range f.Args {
+		if arg.Name == name {
+			return arg, true
+		}
+	}
+	return FunctionArg{}, false
+}
+
+// functionsQuery lists the functions in the schemas passed as $1 that can be
+// called over RPC, excluding trigger functions and procedures. Arrays are
+// aggregated as JSON to avoid scanning Postgres arrays
+const functionsQuery = `
+SELECT
+    n.nspname,
+    p.proname,
+    p.proretset,
+    p.provolatile,
+    p.pronargdefaults,
+    coalesce(array_to_json(p.proargnames), '[]')::text,
+    coalesce(array_to_json(p.proargmodes), '[]')::text,
+    coalesce((
+        SELECT json_agg(format_type(a.t, NULL) ORDER BY a.i)
+        FROM unnest(p.proargtypes::oid[]) WITH ORDINALITY AS a(t, i)
+    ), '[]')::text,
+    format_type(p.prorettype, NULL),
+    coalesce((
+        SELECT json_agg(a.attname ORDER BY a.attnum) FROM pg_attribute a
+        WHERE a.attrelid = rt.typrelid AND a.attnum > 0 AND NOT a.attisdropped
+    ), '[]')::text
+FROM pg_catalog.pg_proc p
+JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+JOIN pg_catalog.pg_type rt ON rt.oid = p.prorettype
+WHERE n.nspname = ANY($1::text[])
+    AND p.prokind = 'f'
+    AND rt.typname NOT IN ('trigger', 'event_trigger')
+ORDER BY array_position($1::text[], n.nspname::text), p.proname`
+
+// GetPublicFunctions gets the functions in the public schema
+func GetPublicFunctions(db QueryExecutor) ([]Function, error) {
+	return GetFunctions(db, []string{DEFAULT_SCHEMA})
+}
+
+// GetFunctions gets the functions in the schemas and builds a slice of
+// Function structs to assign to the functions field of the Repository.
+// Functions with unnamed input arguments can't be called with named arguments
+// and are skipped, as are overloads of a function name
+func GetFunctions(db QueryExecutor, schemas []string) ([]Function, error) {
+	rows, err := db.Query(functionsQuery, pq.Array(schemas))
+	if err != nil {
+		return []Function{}, err
+	}
+	defer rows.Close()
+
+	functions := []Function{}
+	for rows.Next() {
+		var fn Function
+		var nDefaults int
+		var namesJSON, modesJSON, typesJSON, attrsJSON string
+		err := rows.Scan(
+			&fn.Schema,
+			&fn.Name,
+			&fn.ReturnsSet,
+			&fn.Volatility,
+			&nDefaults,
+			&namesJSON,
+			&modesJSON,
+			&typesJSON,
+			&fn.ReturnType,
+			&attrsJSON,
+		)
+		if err != nil {
+			return []Function{}, err
+		}
+
+		var names, modes, argTypes, attrs []string
+		for _, a := range []struct {
+			data string
+			dest *[]string
+		}{
+			{namesJSON, &names},
+			{modesJSON, &modes},
+			{typesJSON, &argTypes},
+			{attrsJSON, &attrs},
+		} {
+			if err := json.Unmarshal([]byte(a.data), a.dest); err != nil {
+				return []Function{}, err
+			}
+		}
+
+		isOverload := func(f Function) bool { return f.Schema == fn.Schema && f.Name == fn.Name }
+		if slices.ContainsFunc(functions, isOverload) {
+			log.Printf("Skipping overloaded function %s\n", fn.Name)
+			continue
+		}
+
+		if err := fn.setArgsAndColumns(names, modes, argTypes, attrs, nDefaults); err != nil {
+			log.Printf("Skipping function %s: %s\n", fn.Name, err)
+			continue
+		}
+		functions = append(functions, fn)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("Error after iterating rows for db functions: %v", err)
+		return nil, err
+	}
+
+	log.Println("Found functions in database:")
+	for _, fn := range functions {
+		args := []string{}
+		for _, arg := range fn.Args {
+			args = append(args, fmt.Sprintf("%s %s", arg.Name, arg.Type))
+		}
+		log.Printf("\t%s.%s(%s) : %s", fn.Schema, fn.Name, strings.Join(args, ", "), fn.ReturnType)
+	}
+	log.Println()
+
+	return functions, nil
+}
+
+// setArgsAndColumns matches the names of a function's arguments with their
+// modes and types. When a function has OUT arguments, names and modes cover
+// all arguments while argTypes only covers the input arguments. The last
+// nDefaults input arguments have default values
+func (fn *Function) setArgsAndColumns(names, modes, argTypes, attrs []string, nDefaults int) error {
+	outColumns := []string{}
+	inputNames := []string{}
+	for i, name := range names {
+		mode := "i"
+		if len(modes) > 0 {
+			mode = modes[i]
+		}
+		switch mode {
+		case "i", "b", "v": // IN, INOUT, VARIADIC
+			inputNames = append(inputNames, name)
+			if mode == "b" {
+				outColumns = append(outColumns, name)
+			}
+		case "o", "t": // OUT, TABLE
+			outColumns = append(outColumns, name)
+		}
+	}
+
+	if len(inputNames) != len(argTypes) || slices.Contains(inputNames, "") {
+		return fmt.Errorf("all input arguments must be named")
+	}
+	for i, name := range inputNames {
+		fn.Args = append(fn.Args, FunctionArg{
+			Name:       name,
+			Type:       argTypes[i],
+			HasDefault: i >= len(inputNames)-nDefaults,
+		})
+	}
+
+	switch {
+	case len(outColumns) > 0:
+		fn.Columns = outColumns
+	case len(attrs) > 0:
+		fn.Columns = attrs
+	default:
+		fn.Columns = []string{fn.Name}
+	}
+	return nil
+}
+
+// GetFunction gets a function in the Repository's schema from the functions
+// slice by name
+func (r *Repository) GetFunction(name string) (*Function, error) {
+	for _, fn := range r.Functions {
+		if fn.Schema == r.Schema && fn.Name == name {
+			return &fn, nil
+		}
+	}
+	return nil, apperrors.FunctionDoesNotExist
+}
+
+// CallFunction calls a function with named arguments and returns its result
+// rows. The query params are applied to the function's result as if it were a
+// table, e.g. `SELECT "title" FROM "public"."books_by_author"("author_surname"
+// => $1::text) AS "books_by_author" WHERE ...`. Each argument is cast to its
+// declared type
+func (r *Repository) CallFunction(fn *Function, args map[string]any, query rsql.QueryParams) (*sql.Rows, error) {
+	// Sort argument names for a stable order of placeholders
+	argNames := []string{}
+	for name := range args {
+		argNames = append(argNames, name)
+	}
+	slices.Sort(argNames)
+
+	b := sqlbuilder.New()
+	b.Write("SELECT ")
+	writeDistinct(b, query)
+	writeSelectColumns(b, query)
+	b.Write(" FROM ").Ident(fn.Schema, fn.Name).Write("(")
+	for i, name := range argNames {
+		arg, ok := fn.GetArg(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", apperrors.InvalidFunctionArgs, name)
+		}
+		if i > 0 {
+			b.Write(", ")
+		}
+		// Argument types are formatted by Postgres, not taken from the request
+		b.Ident(name).Write(" => ").Value(args[name]).Write("::", arg.Type)
+	}
+	b.Write(") AS ").Ident(fn.Name)
+
+	if err := writeWhereClause(b, query); err != nil {
+		return nil, err
+	}
+	writeOrderByClause(b, query)
+	writeLimitClause(b, query)
+	writeOffsetClause(b, query)
+	log.Printf("Exec: %s", replacePlaceholders(b.String(), b.Values()))
+
+	return r.DB.Query(b.String(), b.Values()...)
+}
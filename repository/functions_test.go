@@ -0,0 +1,75 @@
+package repository_test
+
+import (
+	"slices"
+	"testing"
+
+	"gopgrest/assert"
+	"gopgrest/repository"
+	"gopgrest/rsql"
+	"gopgrest/service"
+	"gopgrest/tests"
+	"gopgrest/types"
+)
+
+func Test_GetPublicFunctions(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	functions, err := repository.GetPublicFunctions(tdb.DB)
+	assert.Try(t, err)
+	repo := repository.NewRepository(tdb.DB, tdb.Tables, functions, tests.SCHEMAS)
+
+	t.Run("set-returning function", func(t *testing.T) {
+		fn, err := repo.GetFunction("books_by_author")
+		assert.Try(t, err)
+		assert.IsTrue(t, fn.ReturnsSet)
+		assert.IsTrue(t, fn.IsReadOnly())
+		assert.IsEq(t, len(fn.Args), 1)
+		assert.IsEq(t, fn.Args[0].Name, "author_surname")
+		assert.IsEq(t, fn.Args[0].Type, "text")
+		// Columns of the returned table type
+		assert.IsTrue(t, slices.Equal(fn.Columns, []string{"id", "title", "author_id", "genre_id"}))
+	})
+
+	t.Run("scalar function with default argument", func(t *testing.T) {
+		fn, err := repo.GetFunction("lifespan")
+		assert.Try(t, err)
+		assert.IsTrue(t, !fn.ReturnsSet)
+		assert.IsEq(t, fn.Args[0].HasDefault, false)
+		assert.IsEq(t, fn.Args[1].HasDefault, true)
+		assert.IsTrue(t, slices.Equal(fn.Columns, []string{"lifespan"}))
+	})
+
+	t.Run("volatile function", func(t *testing.T) {
+		fn, err := repo.GetFunction("add_genre")
+		assert.Try(t, err)
+		assert.IsTrue(t, !fn.IsReadOnly())
+	})
+
+	// Trigger functions can't be called
+	t.Run("no trigger functions", func(t *testing.T) {
+		for _, fn := range functions {
+			assert.IsNotEq(t, fn.ReturnType, "trigger")
+		}
+	})
+}
+
+func Test_RepoCallFunction(t *testing.T) {
+	repo := tests.NewTestRepo(t)
+	fn, err := repo.GetFunction("books_by_author")
+	assert.Try(t, err)
+
+	// GET /rpc/books_by_author?author_surname=Woolf&select=title&order_by=title:desc
+	query := rsql.QueryParams{
+		Limit:   -1,
+		Columns: []rsql.Column{{Name: "title"}},
+		OrderBy: []rsql.OrderBy{{Column: rsql.Column{Name: "title"}, Desc: true}},
+	}
+	rows, err := repo.CallFunction(fn, map[string]any{"author_surname": "Woolf"}, query)
+	assert.Try(t, err)
+	defer rows.Close()
+	gotRows, err := service.ScanRows(rows)
+	assert.Try(t, err)
+
+	expRows := []types.RowData{{"title": "To The Lighthouse"}, {"title": "Mrs. Dalloway"}}
+	assert.Try(t, tests.CheckMapEquality(expRows, gotRows))
+}
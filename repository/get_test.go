@@ -290,12 +290,12 @@ func Test_RepoGetRows_Joins(t *testing.T) {
 		}
 		joins := []rsql.JoinRelation{
 			{
-				Type:           "JOIN",
-				Table:          "genres",
-				LeftQualifier:  "books",
-				LeftCol:        "genre_id",
-				RightQualifier: "genres",
-				RightCol:       "id",
+				Type:  "JOIN",
+				Table: "genres",
+				On: []rsql.JoinOn{{
+					Left:  rsql.Column{Qualifier: "books", Name: "genre_id"},
+					Right: rsql.Column{Qualifier: "genres", Name: "id"},
+				}},
 			},
 		}
 		rawQuery := "SELECT title, name AS genre FROM books JOIN genres ON books.genre_id = genres.id"
@@ -313,20 +313,20 @@ func Test_RepoGetRows_Joins(t *testing.T) {
 			}
 			joins := []rsql.JoinRelation{
 				{
-					Type:           "JOIN",
-					Table:          "authors",
-					LeftQualifier:  "books",
-					LeftCol:        "author_id",
-					RightQualifier: "authors",
-					RightCol:       "id",
+					Type:  "JOIN",
+					Table: "authors",
+					On: []rsql.JoinOn{{
+						Left:  rsql.Column{Qualifier: "books", Name: "author_id"},
+						Right: rsql.Column{Qualifier: "authors", Name: "id"},
+					}},
 				},
 				{
-					Type:           "JOIN",
-					Table:          "genres",
-					LeftQualifier:  "books",
-					LeftCol:        "genre_id",
-					RightQualifier: "genres",
-					RightCol:       "id",
+					Type:  "JOIN",
+					Table: "genres",
+					On: []rsql.JoinOn{{
+						Left:  rsql.Column{Qualifier: "books", Name: "genre_id"},
+						Right: rsql.Column{Qualifier: "genres", Name: "id"},
+					}},
 				},
 			}
 			rawQuery := "SELECT title, name AS genre, surname FROM books JOIN authors ON books.author_id = authors.id JOIN genres ON books.genre_id = genres.id"
@@ -342,12 +342,12 @@ func Test_RepoGetRows_Joins(t *testing.T) {
 		}
 		joins := []rsql.JoinRelation{
 			{
-				Type:           "JOIN",
-				Table:          "genres",
-				LeftQualifier:  "books",
-				LeftCol:        "genre_id",
-				RightQualifier: "genres",
-				RightCol:       "id",
+				Type:  "JOIN",
+				Table: "genres",
+				On: []rsql.JoinOn{{
+					Left:  rsql.Column{Qualifier: "books", Name: "genre_id"},
+					Right: rsql.Column{Qualifier: "genres", Name: "id"},
+				}},
 			},
 		}
 		rawQuery := "SELECT title, name AS genre FROM books INNER JOIN genres on books.genre_id = genres.id"
@@ -363,12 +363,12 @@ func Test_RepoGetRows_Joins(t *testing.T) {
 		}
 		joins := []rsql.JoinRelation{
 			{
-				Type:           "LEFT JOIN",
-				Table:          "genres",
-				LeftQualifier:  "books",
-				LeftCol:        "genre_id",
-				RightQualifier: "genres",
-				RightCol:       "id",
+				Type:  "LEFT JOIN",
+				Table: "genres",
+				On: []rsql.JoinOn{{
+					Left:  rsql.Column{Qualifier: "books", Name: "genre_id"},
+					Right: rsql.Column{Qualifier: "genres", Name: "id"},
+				}},
 			},
 		}
 		rawQuery := "SELECT title, name AS genre FROM books LEFT JOIN genres ON books.genre_id = genres.id"
@@ -384,12 +384,12 @@ func Test_RepoGetRows_Joins(t *testing.T) {
 		}
 		joins := []rsql.JoinRelation{
 			{
-				Type:           "RIGHT JOIN",
-				Table:          "genres",
-				LeftQualifier:  "books",
-				LeftCol:        "genre_id",
-				RightQualifier: "genres",
-				RightCol:       "id",
+				Type:  "RIGHT JOIN",
+				Table: "genres",
+				On: []rsql.JoinOn{{
+					Left:  rsql.Column{Qualifier: "books", Name: "genre_id"},
+					Right: rsql.Column{Qualifier: "genres", Name: "id"},
+				}},
 			},
 		}
 		rawQuery := "SELECT title, name AS genre FROM books RIGHT JOIN genres ON books.genre_id = genres.id"
@@ -397,6 +397,132 @@ func Test_RepoGetRows_Joins(t *testing.T) {
 		repoGetRowsTester(t, rawQuery, "books", rsqlQuery)
 	})
 
+	// GET /books?select=title,name:genre&full_join=genres:books.genre_id==genres.id
+	t.Run("FULL JOIN relation (rsql `full_join`, SQL `FULL JOIN`)", func(t *testing.T) {
+		columns := []rsql.Column{
+			{Name: "title"},
+			{Name: "name", Alias: "genre"},
+		}
+		joins := []rsql.JoinRelation{
+			{
+				Type:  "FULL JOIN",
+				Table: "genres",
+				On: []rsql.JoinOn{{
+					Left:  rsql.Column{Qualifier: "books", Name: "genre_id"},
+					Right: rsql.Column{Qualifier: "genres", Name: "id"},
+				}},
+			},
+		}
+		rawQuery := "SELECT title, name AS genre FROM books FULL JOIN genres ON books.genre_id = genres.id"
+		rsqlQuery := rsql.QueryParams{Limit: -1, Columns: columns, Joins: joins}
+		repoGetRowsTester(t, rawQuery, "books", rsqlQuery)
+	})
+
+	// GET /authors?select=surname,name:genre&cross_join=genres
+	t.Run("CROSS JOIN relation (rsql `cross_join`, SQL `CROSS JOIN`)", func(t *testing.T) {
+		columns := []rsql.Column{
+			{Name: "surname"},
+			{Name: "name", Alias: "genre"},
+		}
+		joins := []rsql.JoinRelation{{Type: "CROSS JOIN", Table: "genres"}}
+		rawQuery := "SELECT surname, name AS genre FROM authors CROSS JOIN genres"
+		rsqlQuery := rsql.QueryParams{Limit: -1, Columns: columns, Joins: joins}
+		repoGetRowsTester(t, rawQuery, "authors", rsqlQuery)
+	})
+
+	// GET /authors?select=surname,recent.title&left_lateral_join=books@recent:recent.author_id==authors.id;order_by=id:desc;limit=1
+	t.Run("Lateral top-N join (rsql `left_lateral_join`, SQL `LEFT JOIN LATERAL`)", func(t *testing.T) {
+		columns := []rsql.Column{
+			{Name: "surname"},
+			{Qualifier: "recent", Name: "title"},
+		}
+		joins := []rsql.JoinRelation{
+			{
+				Type:  "LEFT JOIN LATERAL",
+				Table: "books",
+				Alias: "recent",
+				On: []rsql.JoinOn{{
+					Left:  rsql.Column{Qualifier: "recent", Name: "author_id"},
+					Right: rsql.Column{Qualifier: "authors", Name: "id"},
+				}},
+				OrderBy: []rsql.OrderBy{{Column: rsql.Column{Name: "id"}, Desc: true}},
+				Limit:   1,
+			},
+		}
+		rawQuery := `SELECT surname, recent.title FROM authors LEFT JOIN LATERAL (
+			SELECT * FROM books AS recent WHERE recent.author_id = authors.id ORDER BY id DESC LIMIT 1
+		) AS recent ON true`
+		rsqlQuery := rsql.QueryParams{Limit: -1, Columns: columns, Joins: joins}
+		repoGetRowsTester(t, rawQuery, "authors", rsqlQuery)
+	})
+}
+
+func Test_RepoGetRows_Subqueries(t *testing.T) {
+	// GET /authors?where=born>1800;id=insub=books.author_id?genre_id==3
+	rawQuery := "SELECT * FROM authors WHERE born > 1800 AND id IN (SELECT author_id FROM books WHERE genre_id = 3)"
+	rsqlQuery := rsql.QueryParams{
+		Limit: -1,
+		Conditions: []rsql.Condition{
+			{Column: rsql.Column{Name: "born"}, Values: []string{"1800"}, SQLOperator: ">"},
+			{
+				Column:      rsql.Column{Qualifier: "authors", Name: "id"},
+				SQLOperator: "IN",
+				Subquery: &rsql.Subquery{
+					Schema: "public",
+					Table:  "books",
+					Column: "author_id",
+					Conditions: []rsql.Condition{
+						{Column: rsql.Column{Name: "genre_id"}, Values: []string{"3"}, SQLOperator: "="},
+					},
+				},
+			},
+		},
+	}
+	repoGetRowsTester(t, rawQuery, "authors", rsqlQuery)
+}
+
+func Test_RepoGetRows_Distinct(t *testing.T) {
+	// GET /authors?distinct=true&select=forename
+	t.Run("DISTINCT", func(t *testing.T) {
+		rawQuery := "SELECT DISTINCT forename FROM authors"
+		rsqlQuery := rsql.QueryParams{Limit: -1, Distinct: true, Columns: []rsql.Column{{Name: "forename"}}}
+		repoGetRowsTester(t, rawQuery, "authors", rsqlQuery)
+	})
+
+	// GET /books?distinct_on=author_id&order_by=author_id,id:desc
+	t.Run("DISTINCT ON", func(t *testing.T) {
+		rawQuery := "SELECT DISTINCT ON (author_id) * FROM books ORDER BY author_id, id DESC"
+		rsqlQuery := rsql.QueryParams{
+			Limit:      -1,
+			DistinctOn: []rsql.Column{{Name: "author_id"}},
+			OrderBy: []rsql.OrderBy{
+				{Column: rsql.Column{Name: "author_id"}},
+				{Column: rsql.Column{Name: "id"}, Desc: true},
+			},
+		}
+		repoGetRowsTester(t, rawQuery, "books", rsqlQuery)
+	})
+}
+
+func Test_RepoGetRows_OrderBy(t *testing.T) {
+	// GET /authors?order_by=born
+	t.Run("Single column, ascending by default", func(t *testing.T) {
+		orderBy := []rsql.OrderBy{{Column: rsql.Column{Name: "born"}}}
+		rawQuery := "SELECT * FROM authors ORDER BY born ASC"
+		rsqlQuery := rsql.QueryParams{Limit: -1, OrderBy: orderBy}
+		repoGetRowsTester(t, rawQuery, "authors", rsqlQuery)
+	})
+
+	// GET /authors?order_by=forename:desc,authors.surname:asc
+	t.Run("Multiple columns with directions and qualifiers", func(t *testing.T) {
+		orderBy := []rsql.OrderBy{
+			{Column: rsql.Column{Name: "forename"}, Desc: true},
+			{Column: rsql.Column{Name: "surname", Qualifier: "authors"}},
+		}
+		rawQuery := "SELECT * FROM authors ORDER BY forename DESC, authors.surname ASC"
+		rsqlQuery := rsql.QueryParams{Limit: -1, OrderBy: orderBy}
+		repoGetRowsTester(t, rawQuery, "authors", rsqlQuery)
+	})
 }
 
 func Test_RepoGetRows_LIMIT(t *testing.T) {
@@ -450,3 +576,131 @@ func repoGetRowsTester(
 	err = tests.CheckMapEquality(expRows, gotRows)
 	assert.Try(t, err)
 }
+
+func Test_RepoGetRows_Schema(t *testing.T) {
+	repo, err := tests.NewTestRepo(t).WithSchema("archive")
+	assert.Try(t, err)
+
+	// GET /archive/letters?select=title,authors.surname&join=authors:letters.author_id==authors.id
+	query := rsql.QueryParams{
+		Limit:   -1,
+		Columns: []rsql.Column{{Name: "title"}, {Qualifier: "authors", Name: "surname"}},
+		Joins: []rsql.JoinRelation{{
+			Type:  "JOIN",
+			Table: "authors",
+			On: []rsql.JoinOn{{
+				Left:  rsql.Column{Qualifier: "letters", Name: "author_id"},
+				Right: rsql.Column{Qualifier: "authors", Name: "id"},
+			}},
+		}},
+	}
+	rows, err := repo.GetRowsByRSQL("letters", query)
+	assert.Try(t, err)
+	defer rows.Close()
+	gotRows, err := service.ScanRows(rows)
+	assert.Try(t, err)
+
+	expRows := []types.RowData{{"title": "Moral Letters to Lucilius", "surname": "Seneca"}}
+	assert.Try(t, tests.CheckMapEquality(expRows, gotRows))
+}
+
+func Test_RepoGetRows_QuotedIdentifiers(t *testing.T) {
+	repo, err := tests.NewTestRepo(t).WithSchema("archive")
+	assert.Try(t, err)
+
+	getRows := func(tableName string, query rsql.QueryParams) []types.RowData {
+		rows, err := repo.GetRowsByRSQL(tableName, query)
+		assert.Try(t, err)
+		defer rows.Close()
+		gotRows, err := service.ScanRows(rows)
+		assert.Try(t, err)
+		return gotRows
+	}
+
+	// GET /archive/user?select=DisplayName&where=order>=1&order_by=order
+	t.Run("reserved word and mixed case", func(t *testing.T) {
+		query := rsql.QueryParams{
+			Limit:      -1,
+			Columns:    []rsql.Column{{Name: "DisplayName"}},
+			Conditions: []rsql.Condition{{Column: rsql.Column{Name: "order"}, Values: []string{"1"}, SQLOperator: ">="}},
+			OrderBy:    []rsql.OrderBy{{Column: rsql.Column{Name: "order"}}},
+		}
+		expRows := []types.RowData{{"DisplayName": "Virginia"}, {"DisplayName": "Anne"}}
+		assert.Try(t, tests.CheckMapEquality(expRows, getRows("user", query)))
+	})
+
+	// GET /archive/éditeurs
+	t.Run("non-ASCII table name", func(t *testing.T) {
+		expRows := []types.RowData{{"nom": "Gallimard"}}
+		assert.Try(t, tests.CheckMapEquality(expRows, getRows("éditeurs", rsql.QueryParams{Limit: -1})))
+	})
+
+	// An alias is quoted as an identifier rather than spliced into the query
+	t.Run("alias is not injected", func(t *testing.T) {
+		alias := `name" FROM archive.authors; DROP TABLE archive.letters; --`
+		query := rsql.QueryParams{
+			Limit:   -1,
+			Columns: []rsql.Column{{Name: "nom", Alias: alias}},
+		}
+		expRows := []types.RowData{{alias: "Gallimard"}}
+		assert.Try(t, tests.CheckMapEquality(expRows, getRows("éditeurs", query)))
+	})
+}
+
+func Test_RepoGetRows_ComputedColumns(t *testing.T) {
+	// GET /authors?select=surname||', '||forename:full_name,coalesce(died,2000)-born:age&order_by=age
+	rawQuery := "SELECT surname || ', ' || forename AS full_name, coalesce(died, 2000) - born AS age FROM authors ORDER BY age"
+	fullName := rsql.Expr{Kind: rsql.EXPR_OPERATOR, Op: "||", Operands: []rsql.Expr{
+		{Kind: rsql.EXPR_OPERATOR, Op: "||", Operands: []rsql.Expr{
+			{Kind: rsql.EXPR_COLUMN, Column: rsql.Column{Name: "surname"}},
+			{Kind: rsql.EXPR_STRING, Value: ", "},
+		}},
+		{Kind: rsql.EXPR_COLUMN, Column: rsql.Column{Name: "forename"}},
+	}}
+	age := rsql.Expr{Kind: rsql.EXPR_OPERATOR, Op: "-", Operands: []rsql.Expr{
+		{Kind: rsql.EXPR_FUNCTION, Op: "coalesce", Operands: []rsql.Expr{
+			{Kind: rsql.EXPR_COLUMN, Column: rsql.Column{Name: "died"}},
+			{Kind: rsql.EXPR_NUMBER, Value: "2000"},
+		}},
+		{Kind: rsql.EXPR_COLUMN, Column: rsql.Column{Name: "born"}},
+	}}
+	rsqlQuery := rsql.QueryParams{
+		Limit: -1,
+		Columns: []rsql.Column{
+			{Expr: &fullName, Alias: "full_name"},
+			{Expr: &age, Alias: "age"},
+		},
+		OrderBy: []rsql.OrderBy{{Column: rsql.Column{Name: "age"}}},
+	}
+	repoGetRowsTester(t, rawQuery, "authors", rsqlQuery)
+}
+
+func Test_RepoGetRows_WindowFunctions(t *testing.T) {
+	// GET /books?select=title,row_number()over(partition_by=author_id;order_by=id:desc):n,sum(id)over(order_by=id):total&order_by=id
+	rawQuery := `SELECT title, row_number() OVER (PARTITION BY author_id ORDER BY id DESC) AS n,
+		sum(id) OVER (ORDER BY id) AS total FROM books ORDER BY id`
+	rowNumber := rsql.Expr{
+		Kind: rsql.EXPR_FUNCTION,
+		Op:   "row_number",
+		Over: &rsql.Window{
+			PartitionBy: []rsql.Column{{Name: "author_id"}},
+			OrderBy:     []rsql.OrderBy{{Column: rsql.Column{Name: "id"}, Desc: true}},
+		},
+	}
+	total := rsql.Expr{
+		Kind:     rsql.EXPR_FUNCTION,
+		Op:       "sum",
+		Operands: []rsql.Expr{{Kind: rsql.EXPR_COLUMN, Column: rsql.Column{Name: "id"}}},
+		Over:     &rsql.Window{OrderBy: []rsql.OrderBy{{Column: rsql.Column{Name: "id"}}}},
+	}
+	rsqlQuery := rsql.QueryParams{
+		Limit: -1,
+		Columns: []rsql.Column{
+			{Name: "title"},
+			{Expr: &rowNumber, Alias: "n"},
+			{Expr: &total, Alias: "total"},
+		},
+		OrderBy: []rsql.OrderBy{{Column: rsql.Column{Name: "id"}}},
+	}
+	repoGetRowsTester(t, rawQuery, "books", rsqlQuery)
+}
@@ -59,3 +59,23 @@ func Test_RepoInsertRow_NoRows(t *testing.T) {
 	_, err := repo.InsertRows("authors", []types.RowData{})
 	assert.ErrorsIs(t, err, apperrors.InsertWithNoRows)
 }
+
+func Test_RepoInsertRows_DifferentColumns(t *testing.T) {
+	repo := tests.NewTestRepo(t)
+
+	// Columns missing from a row are set to their DEFAULT
+	newRows := []types.RowData{
+		{"surname": "Plato"},
+		{"surname": "Jemisin", "forename": "N.K."},
+	}
+	ids, err := repo.InsertRows("authors", newRows)
+	assert.Try(t, err)
+
+	count, err := tests.CountRows(
+		repo,
+		"authors",
+		fmt.Sprintf("WHERE id = %d AND forename = ''", ids[0]),
+	)
+	assert.Try(t, err)
+	assert.IsEq(t, count, 1)
+}
@@ -5,24 +5,72 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"slices"
+
+	"github.com/lib/pq"
+
+	"gopgrest/apperrors"
+	"gopgrest/sqlbuilder"
 )
 
-// TableColumn represents a column in a table
+// TableColumn represents a column in a table. DBType is the column's type
+// name as reported by the driver, e.g. `INT4`, or `_INT4` for an array of
+// INT4, and is empty if the driver doesn't know the type
 type TableColumn struct {
-	Name string
-	Type reflect.Type
+	Name        string
+	Type        reflect.Type
+	DBType      string
+	Constraints ColumnConstraints
 }
 
 // ColumnMap is a map of column names in a row and their type
 type ColumnMap map[string]reflect.Type
 
-// Table represents a table in the database
+// Kinds of relations that can be served as a Table
+const (
+	TABLE   = "table"
+	VIEW    = "view"
+	MATVIEW = "matview"
+)
+
+// DEFAULT_SCHEMA is the schema exposed when no schemas are configured
+const DEFAULT_SCHEMA = "public"
+
+// Table represents a table, view or materialized view in the database
+// Schema is the schema the table belongs to.
 // The Columns slice preserves the column order.
 // The ColumnMap is used for fast lookup to check if a column exists
+// Insertable is whether rows can be inserted, and Updatable whether they can
+// be updated and deleted. Both are set for tables and neither for
+// materialized views. Views have each if Postgres can automatically update
+// them or they have INSTEAD OF triggers or rules for it
+// PrimaryKey is the columns of the table's primary key, in order, and is
+// empty for views and tables without one
 type Table struct {
-	Name      string
-	Columns   []TableColumn
-	ColumnMap ColumnMap
+	Schema     string
+	Name       string
+	Columns    []TableColumn
+	ColumnMap  ColumnMap
+	Kind       string
+	Insertable bool
+	Updatable  bool
+	PrimaryKey []string
+}
+
+// QualifiedName returns the table's name qualified with its schema as it
+// would be used in a SQL statement, e.g. `public.authors`
+func (t *Table) QualifiedName() string {
+	return fmt.Sprintf("%s.%s", t.Schema, t.Name)
+}
+
+// GetColumn gets a column of the table by name
+func (t *Table) GetColumn(name string) (TableColumn, bool) {
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return TableColumn{}, false
 }
 
 // ColData is a tuple of a column's name and its database type used for JSON
@@ -45,27 +93,76 @@ type QueryExecutor interface {
 }
 
 // Repository handles database transactions
+// Tables and Functions hold the relations of every exposed schema, listed in
+// Schemas. Tables and functions are looked up in Schema, which defaults to
+// the first exposed schema and can be changed per request with WithSchema.
+// TablesRepr only represents the tables in Schema
 type Repository struct {
 	DB         QueryExecutor
 	Tables     []Table
 	TablesRepr TablesRepr
+	Functions  []Function
+	Schemas    []string
+	Schema     string
+}
+
+// NewRepository returns a new Repository exposing the schemas in order, even
+// those without tables or functions, or only DEFAULT_SCHEMA if there are none
+func NewRepository(db QueryExecutor, tables []Table, functions []Function, schemas []string) Repository {
+	if len(schemas) == 0 {
+		schemas = []string{DEFAULT_SCHEMA}
+	}
+
+	r := Repository{
+		DB:        db,
+		Tables:    tables,
+		Functions: functions,
+		Schemas:   schemas,
+		Schema:    schemas[0],
+	}
+	r.TablesRepr = NewTablesRepr(r.SchemaTables())
+	return r
 }
 
-// NewRepository returns a new Repository
-func NewRepository(db QueryExecutor, tables []Table) Repository {
-	return Repository{
-		DB:         db,
-		Tables:     tables,
-		TablesRepr: NewTablesRepr(tables),
+// WithSchema returns a copy of the Repository that looks up tables and
+// functions in another exposed schema
+func (r Repository) WithSchema(schema string) (Repository, error) {
+	if !slices.Contains(r.Schemas, schema) {
+		return r, fmt.Errorf("%w (%s)", apperrors.SchemaNotExposed, schema)
 	}
+	r.Schema = schema
+	r.TablesRepr = NewTablesRepr(r.SchemaTables())
+	return r, nil
+}
+
+// SchemaTables returns the tables in the Repository's current schema
+func (r *Repository) SchemaTables() []Table {
+	tables := []Table{}
+	for _, t := range r.Tables {
+		if t.Schema == r.Schema {
+			tables = append(tables, t)
+		}
+	}
+	return tables
 }
 
 // NewTable returns a new Table struct if tableName is a valid table in the
-// database
-func NewTable(db QueryExecutor, tableName string) (*Table, error) {
+// schema
+func NewTable(db QueryExecutor, schema, tableName string) (*Table, error) {
+	// Get column constraints before the dummy row, as a transaction can only
+	// run one query at a time
+	constraints, err := getColumnConstraints(db, schema, tableName)
+	if err != nil {
+		return &Table{}, err
+	}
+	primaryKey, err := getPrimaryKey(db, schema, tableName)
+	if err != nil {
+		return &Table{}, err
+	}
+
 	// Get a dummy row of the table with `limit 0`
-	query := fmt.Sprintf(`select * from %s limit 0;`, tableName)
-	rows, err := db.Query(query)
+	b := sqlbuilder.New().Write("SELECT * FROM ").Ident(schema, tableName).Write(" LIMIT 0")
+	rows, err := db.Query(b.String())
 	if err != nil {
 		return &Table{}, err
 	}
@@ -93,25 +190,65 @@ func NewTable(db QueryExecutor, tableName string) (*Table, error) {
 			TableColumn{
 				name,
 				coltypes[i].ScanType(),
+				coltypes[i].DatabaseTypeName(),
+				constraints[name],
 			},
 		)
 		columnMap[name] = coltypes[i].ScanType()
 	}
 
 	return &Table{
-		tableName,
-		tableColumns,
-		columnMap,
+		Schema:     schema,
+		Name:       tableName,
+		Columns:    tableColumns,
+		ColumnMap:  columnMap,
+		Kind:       TABLE,
+		Insertable: true,
+		Updatable:  true,
+		PrimaryKey: primaryKey,
 	}, nil
 }
 
-// GetPublicTables gets the public tables in the database and builds a slice of
-// Table structs to assign to the table field of the Repository
+// ReadOnly reports whether the table allows no writes at all
+func (t *Table) ReadOnly() bool {
+	return !t.Insertable && !t.Updatable
+}
+
+// relationsQuery lists the tables, views and materialized views in the
+// schemas passed as $1 with their kind and whether rows can be inserted, and
+// updated and deleted. The events a view supports are a bitmask of
+// 8 (INSERT), 4 (UPDATE) and 16 (DELETE), counting its INSTEAD OF triggers.
+// Relations are ordered by the position of their schema in $1
+const relationsQuery = `
+SELECT schemaname, relname, kind, insertable, updatable FROM (
+    SELECT schemaname, tablename AS relname, 'table' AS kind,
+        true AS insertable, true AS updatable
+    FROM pg_catalog.pg_tables
+    UNION ALL
+    SELECT schemaname, viewname, 'view', events & 8 = 8, events & 20 = 20
+    FROM pg_catalog.pg_views,
+        pg_catalog.pg_relation_is_updatable(
+            format('%I.%I', schemaname, viewname)::regclass, true
+        ) AS events
+    UNION ALL
+    SELECT schemaname, matviewname, 'matview', false, false
+    FROM pg_catalog.pg_matviews
+) AS relations
+WHERE schemaname = ANY($1::text[])
+ORDER BY array_position($1::text[], schemaname::text)`
+
+// GetPublicTables gets the tables, views and materialized views in the public
+// schema
 func GetPublicTables(db QueryExecutor) ([]Table, error) {
+	return GetTables(db, []string{DEFAULT_SCHEMA})
+}
+
+// GetTables gets the tables, views and materialized views in the schemas and
+// builds a slice of Table structs to assign to the table field of the
+// Repository
+func GetTables(db QueryExecutor, schemas []string) ([]Table, error) {
 	// Get table names with query
-	rows, err := db.Query(
-		`SELECT tablename FROM Pg_catalog.pg_tables WHERE schemaname='public'`,
-	)
+	rows, err := db.Query(relationsQuery, pq.Array(schemas))
 	if err != nil {
 		return []Table{}, err
 	}
@@ -120,17 +257,21 @@ func GetPublicTables(db QueryExecutor) ([]Table, error) {
 	// Build the slice of tables for the Repository
 	var tables []Table
 	for rows.Next() {
-		var tableName string
-		err := rows.Scan(&tableName)
+		var schema, tableName, kind string
+		var insertable, updatable bool
+		err := rows.Scan(&schema, &tableName, &kind, &insertable, &updatable)
 		if err != nil {
 			return []Table{}, err
 		}
 
 		// Make a new table
-		newTable, err := NewTable(db, tableName)
+		newTable, err := NewTable(db, schema, tableName)
 		if err != nil {
 			return []Table{}, err
 		}
+		newTable.Kind = kind
+		newTable.Insertable = insertable
+		newTable.Updatable = updatable
 		tables = append(tables, *newTable)
 	}
 
@@ -142,7 +283,7 @@ func GetPublicTables(db QueryExecutor) ([]Table, error) {
 	// Log the tables
 	log.Println("Found tables in database:")
 	for _, table := range tables {
-		log.Printf("\t%s : %d cols", table.Name, len(table.Columns))
+		log.Printf("\t%s (%s) : %d cols", table.QualifiedName(), table.Kind, len(table.Columns))
 		for _, col := range table.Columns {
 			log.Printf("\t\t%-15s\t%s", col.Name, col.Type)
 		}
@@ -2,100 +2,120 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"slices"
 	"strings"
 
+	"github.com/lib/pq"
+
 	"gopgrest/apperrors"
 	"gopgrest/rsql"
+	"gopgrest/sqlbuilder"
 	"gopgrest/types"
 )
 
 // GetRowByID gets a row from a table by id
 func (r *Repository) GetRowByID(tableName string, id int64) (*sql.Rows, error) {
-	log.Printf(
-		"Exec query\n\tSELECT * FROM %s WHERE id = %d",
-		tableName, id,
-	)
-
-	return r.DB.Query(
-		fmt.Sprintf("SELECT * FROM %s WHERE id=$1", tableName),
-		id,
-	)
+	b := sqlbuilder.New()
+	b.Write("SELECT * FROM ").Ident(r.Schema, tableName).Write(" WHERE ").Ident("id").Write(" = ").Value(id)
+	log.Printf("Exec query\n\t%s", replacePlaceholders(b.String(), b.Values()))
+
+	return r.DB.Query(b.String(), b.Values()...)
+}
+
+// GetRowByPK gets a row from a table by the values of the columns of its
+// primary key. Values are compared as text so that Postgres parses them as
+// the columns' types
+func (r *Repository) GetRowByPK(tableName string, columns []string, values []string) (*sql.Rows, error) {
+	b := sqlbuilder.New()
+	b.Write("SELECT * FROM ").Ident(r.Schema, tableName).Write(" WHERE ")
+	for i, col := range columns {
+		if i > 0 {
+			b.Write(" AND ")
+		}
+		b.Ident(col).Write(" = ").Value(values[i])
+	}
+	log.Printf("Exec query\n\t%s", replacePlaceholders(b.String(), b.Values()))
+
+	return r.DB.Query(b.String(), b.Values()...)
 }
 
 // GetRowsByRSQL gets rows from a table with optional query params
 func (r *Repository) GetRowsByRSQL(tableName string, query rsql.QueryParams) (*sql.Rows, error) {
+	b := sqlbuilder.New()
 	// Build list of columns to select
-	cols := buildSelectColumns(query)
+	b.Write("SELECT ")
+	writeDistinct(b, query)
+	writeSelectColumns(b, query)
+	b.Write(" FROM ").Ident(r.Schema, tableName)
+	// Build list of optional JOIN relations
+	if err := writeJoinRelations(b, query, r.Schema); err != nil {
+		return nil, err
+	}
 	// Build list query with optional WHERE conditional statements
-	conditional, values, err := buildWhereConditions(query.Conditions, 0)
-	if err != nil {
+	if err := writeWhereClause(b, query); err != nil {
 		return nil, err
 	}
-	// Build list of optional JOIN relations
-	joins := buildJoinRelations(query)
-	limit := buildLimitClause(query)
-	offset := buildOffsetClause(query)
-
-	clauses := strings.Join([]string{joins, conditional, limit, offset}, " ")
-	listStmt := fmt.Sprintf("SELECT %s FROM %s %s", cols, tableName, clauses)
-	log.Printf("Exec: %s", replacePlaceholders(listStmt, values))
+	writeOrderByClause(b, query)
+	writeLimitClause(b, query)
+	writeOffsetClause(b, query)
+	log.Printf("Exec: %s", replacePlaceholders(b.String(), b.Values()))
 
 	// Execute list query
-	rows, err := r.DB.Query(listStmt, values...)
+	rows, err := r.DB.Query(b.String(), b.Values()...)
 	if err != nil {
 		return nil, err
 	}
 	return rows, nil
 }
 
-// InsertRows inserts a new row into a specified table
+// InsertRows inserts new rows into a specified table. Rows may have
+// different columns, and columns missing from a row are set to their DEFAULT
 func (r *Repository) InsertRows(tableName string, newRows []types.RowData) ([]int64, error) {
 	insertedIDs := make([]int64, len(newRows))
 	if len(newRows) == 0 {
 		return insertedIDs, apperrors.InsertWithNoRows
 	}
 
-	var cols []string         // column names for `INSERT INTO (col1, col2...)`
-	var values []any            // values to pass to QueryRow
-	var placeholders []string // incrementing placeholders e.g. `VALUES (($1, $2), ($3, $4)...)`
-	var p int                 // tracks the value of the placeholder
-
-	for k := range newRows[0] {
-		cols = append(cols, k)
+	var cols []string // column names for `INSERT INTO (col1, col2...)`
+	for _, row := range newRows {
+		for k := range row {
+			if !slices.Contains(cols, k) {
+				cols = append(cols, k)
+			}
+		}
 	}
 	// Sort cols so we insert values alphabetically
 	slices.Sort(cols)
 
-	for _, newRow := range newRows {
-		rowPlaceholders := make([]string, len(cols))
-		colIdx := 0
-		// Append values in corresponding order of cols
-		for _, col := range cols {
-			val := newRow[col]
-			values = append(values, val)
-			rowPlaceholders[colIdx] = fmt.Sprintf("$%d", p+1)
-			p++
-			colIdx++
-		}
-		placeholders = append(placeholders, fmt.Sprintf("(%s)", strings.Join(rowPlaceholders, ",")))
-	}
-
 	// Build create query
-	createStmnt := fmt.Sprintf("INSERT INTO %s (", tableName) +
-		strings.Join(cols, ", ") +
-		") VALUES " +
-		strings.Join(placeholders, ",") +
-		" RETURNING id"
-
-	log.Printf("Exec: %s", replacePlaceholders(createStmnt, values))
+	b := sqlbuilder.New()
+	b.Write("INSERT INTO ").Ident(r.Schema, tableName).Write(" (")
+	b.Join(", ", len(cols), func(i int) { b.Ident(cols[i]) })
+	b.Write(") VALUES ")
+	// Incrementing placeholders e.g. `VALUES ($1,$2),($3,DEFAULT)...`, with
+	// values appended in corresponding order of cols
+	b.Join(",", len(newRows), func(i int) {
+		b.Write("(")
+		b.Join(",", len(cols), func(j int) {
+			if val, ok := newRows[i][cols[j]]; ok {
+				b.Value(val)
+			} else {
+				b.Write("DEFAULT")
+			}
+		})
+		b.Write(")")
+	})
+	b.Write(" RETURNING ").Ident("id")
+
+	log.Printf("Exec: %s", replacePlaceholders(b.String(), b.Values()))
 
 	// Execute insert query
-	rows, err := r.DB.Query(createStmnt, values...)
+	rows, err := r.DB.Query(b.String(), b.Values()...)
 	if err != nil {
-		return insertedIDs, err
+		return insertedIDs, wrapCheckViolation(err)
 	}
 	defer rows.Close()
 
@@ -105,46 +125,38 @@ func (r *Repository) InsertRows(tableName string, newRows []types.RowData) ([]in
 		i++
 	}
 
-	return insertedIDs, nil
+	return insertedIDs, wrapCheckViolation(rows.Err())
 }
 
 // UpdateRowsByRSQL updates rows matching conditions and returns the ids of
 // updated rows
-func (r *Repository) UpdateRowsByRSQL(tableName string, conditions []rsql.Condition, updatedRow *types.RowData) ([]int64, error) {
+func (r *Repository) UpdateRowsByRSQL(tableName string, query rsql.QueryParams, updatedRow *types.RowData) ([]int64, error) {
 	// Do not exec update with empty query
-	if len(conditions) == 0 {
+	if len(query.Conditions) == 0 {
 		return []int64{}, apperrors.UpdateWithNoConditions
 	}
-	var assignments []string
-	var values []any
-	var assignmentVals []any
-	var placeholder int
-	for k, v := range *updatedRow {
-		assignments = append(assignments, fmt.Sprintf("%s = $%d", k, placeholder+1))
-		assignmentVals = append(assignmentVals, v)
-		placeholder++
+
+	// Build update query
+	b := sqlbuilder.New()
+	b.Write("UPDATE ").Ident(r.Schema, tableName).Write(" SET ")
+	cols := []string{}
+	for k := range *updatedRow {
+		cols = append(cols, k)
 	}
-	conditional, conditionalVals, err := buildWhereConditions(conditions, placeholder)
-	if err != nil {
+	b.Join(", ", len(cols), func(i int) {
+		b.Ident(cols[i]).Write(" = ").Value((*updatedRow)[cols[i]])
+	})
+	if err := writeJoinedWhereClause(b, " FROM ", query, r.Schema); err != nil {
 		return []int64{}, err
 	}
+	b.Write(" RETURNING ").Ident(tableName, "id")
 
-	values = slices.Concat(assignmentVals, conditionalVals)
-
-	// Build update query
-	updateStmnt := fmt.Sprintf(
-		"UPDATE %s SET %s %s RETURNING id",
-		tableName,
-		strings.Join(assignments, ", "),
-		conditional,
-	)
-
-	log.Printf("Exec: %s", replacePlaceholders(updateStmnt, values))
+	log.Printf("Exec: %s", replacePlaceholders(b.String(), b.Values()))
 
 	// Execute update query
-	rows, err := r.DB.Query(updateStmnt, values...)
+	rows, err := r.DB.Query(b.String(), b.Values()...)
 	if err != nil {
-		return []int64{}, err
+		return []int64{}, wrapCheckViolation(err)
 	}
 
 	defer rows.Close()
@@ -156,23 +168,35 @@ func (r *Repository) UpdateRowsByRSQL(tableName string, conditions []rsql.Condit
 		updatedIDs = append(updatedIDs, id)
 	}
 
-	return updatedIDs, nil
+	return updatedIDs, wrapCheckViolation(rows.Err())
+}
+
+// wrapCheckViolation wraps an error of a row violating a CHECK constraint in
+// apperrors.CheckViolation, as it is caused by the request's values. Postgres
+// may only report it once rows are read, so it's also checked after them
+func wrapCheckViolation(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23514" {
+		return fmt.Errorf("%w: %s", apperrors.CheckViolation, pqErr.Message)
+	}
+	return err
 }
 
 // DeleteRowsByRSQL removes any rows matching the Condition in the Query
-func (r *Repository) DeleteRowsByRSQL(tableName string, conditions []rsql.Condition) ([]int64, error) {
+func (r *Repository) DeleteRowsByRSQL(tableName string, query rsql.QueryParams) ([]int64, error) {
 	// Do not exec delete with empty query
-	if len(conditions) == 0 {
+	if len(query.Conditions) == 0 {
 		return []int64{}, apperrors.DeleteWithNoConditions
 	}
-	conditional, values, err := buildWhereConditions(conditions, 0)
-	if err != nil {
+	b := sqlbuilder.New()
+	b.Write("DELETE FROM ").Ident(r.Schema, tableName)
+	if err := writeJoinedWhereClause(b, " USING ", query, r.Schema); err != nil {
 		return []int64{}, err
 	}
-	deleteStmnt := fmt.Sprintf("DELETE FROM %s %s RETURNING id", tableName, conditional)
-	log.Printf("Exec: %s", replacePlaceholders(deleteStmnt, values))
+	b.Write(" RETURNING ").Ident(tableName, "id")
+	log.Printf("Exec: %s", replacePlaceholders(b.String(), b.Values()))
 	// Execute delete query
-	rows, err := r.DB.Query(deleteStmnt, values...)
+	rows, err := r.DB.Query(b.String(), b.Values()...)
 	if err != nil {
 		return []int64{}, err
 	}
@@ -189,9 +213,27 @@ func (r *Repository) DeleteRowsByRSQL(tableName string, conditions []rsql.Condit
 	return deletedIDs, nil
 }
 
+// RefreshMaterializedView refreshes the data in a materialized view.
+// Refreshing concurrently does not lock out reads, but requires a unique
+// index on the view
+func (r *Repository) RefreshMaterializedView(viewName string, concurrently bool) error {
+	b := sqlbuilder.New()
+	b.Write("REFRESH MATERIALIZED VIEW ")
+	if concurrently {
+		b.Write("CONCURRENTLY ")
+	}
+	b.Ident(r.Schema, viewName)
+	log.Printf("Exec: %s", b.String())
+	_, err := r.DB.Exec(b.String())
+	return err
+}
+
+// replacePlaceholders replaces the placeholders in a statement with their
+// values for logging. Placeholders are replaced from the last to the first so
+// that e.g. `$1` doesn't replace the start of `$10`
 func replacePlaceholders(stmnt string, values []any) string {
-	for idx, v := range values {
-		stmnt = strings.Replace(stmnt, fmt.Sprintf("$%d", idx+1), fmt.Sprintf("%v", v), 1)
+	for idx := len(values) - 1; idx >= 0; idx-- {
+		stmnt = strings.Replace(stmnt, fmt.Sprintf("$%d", idx+1), fmt.Sprintf("%v", values[idx]), 1)
 	}
 	return stmnt
 }
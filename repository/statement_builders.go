@@ -3,111 +3,526 @@ package repository
 import (
 	"fmt"
 	"slices"
+	"strconv"
 	"strings"
 
 	"gopgrest/rsql"
+	"gopgrest/sqlbuilder"
 )
 
-// buildWhereConditions builds a SQL WHERE clause from `conditions`.
-// Placeholders values begin at `start`+1, e.g. if `start` == 5, a
-// WHERE clause would begin with `WHERE x = $6 AND ...`
-func buildWhereConditions(conditions []rsql.Condition, start int) (string, []any, error) {
+// writeWhereConditions writes a SQL WHERE clause from `conditions` to the
+// builder, continuing from the builder's placeholders, e.g. if the builder
+// already has 5 values, the WHERE clause would begin with
+// `WHERE "x" = ($6) AND ...`
+func writeWhereConditions(b *sqlbuilder.Builder, conditions []rsql.Condition) error {
 	// If no params were passed, there should not be a WHERE clause
 	if len(conditions) == 0 {
-		return "", []any{}, nil
+		return nil
 	}
 
-	// values holds the order of column values that matches the placeholders
-	values := []any{}
-	// sqlConditions is an array of `col IN ([values])` statements joined by AND
-	sqlConditions := []string{}
-	// n is the number of the placeholder in the statement e.g. $1
-	n := start + 1
-
-	for _, cond := range conditions {
-		var condition string
-		columnName := cond.Column.ToSQLString()
+	b.Write(" WHERE ")
+	for i, cond := range conditions {
+		if i > 0 {
+			b.Write(" AND ")
+		}
+		if err := writeCondition(b, cond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		// Null checks do not require placeholders or appending values array
-		if slices.Contains([]string{"IS NULL", "IS NOT NULL"}, cond.SQLOperator) {
-			condition = fmt.Sprintf("%s %s", columnName, cond.SQLOperator)
-			sqlConditions = append(sqlConditions, condition)
+// writeJoinedWhereClause writes the tables joined to an UPDATE or DELETE after
+// a keyword, then a SQL WHERE clause from the terms of their joins and the
+// query's conditions, e.g.
+// ` USING "public"."authors" WHERE "books"."author_id" = "authors"."id" AND "born" < ($1)`
+func writeJoinedWhereClause(b *sqlbuilder.Builder, keyword string, query rsql.QueryParams, schema string) error {
+	if len(query.Joins) > 0 {
+		b.Write(keyword)
+		b.Join(", ", len(query.Joins), func(i int) {
+			j := query.Joins[i]
+			b.Ident(schema, j.Table)
+			if j.Alias != "" {
+				b.Write(" AS ").Ident(j.Alias)
+			}
+		})
+	}
+	keyword = " WHERE "
+	for _, j := range query.Joins {
+		if len(j.On) == 0 && len(j.Conditions) == 0 {
 			continue
 		}
+		if err := writeJoinTerms(b, keyword, j); err != nil {
+			return err
+		}
+		keyword = " AND "
+	}
+	for _, cond := range query.Conditions {
+		b.Write(keyword)
+		if err := writeCondition(b, cond); err != nil {
+			return err
+		}
+		keyword = " AND "
+	}
+	return nil
+}
+
+// writeWhereClause writes a SQL WHERE clause from a query's conditions, where
+// the conditions of its filter are combined as in the filter, e.g.
+// `WHERE "born" < ($1) AND ("surname" = ($2) OR "died" IS NULL)`
+func writeWhereClause(b *sqlbuilder.Builder, query rsql.QueryParams) error {
+	conditions := query.WhereConditions()
+	if err := writeWhereConditions(b, conditions); err != nil {
+		return err
+	}
+	if query.Filter == nil {
+		return nil
+	}
+	if len(conditions) == 0 {
+		b.Write(" WHERE ")
+	} else {
+		b.Write(" AND ")
+	}
+	return writeFilter(b, query.Conditions, *query.Filter)
+}
+
+// writeFilter writes a filter's expression, with each AND or OR in
+// parentheses
+func writeFilter(b *sqlbuilder.Builder, conditions []rsql.Condition, f rsql.Filter) error {
+	if f.Logic == "" {
+		return writeCondition(b, conditions[f.Condition])
+	}
+	b.Write("(")
+	for i, operand := range f.Operands {
+		if i > 0 {
+			b.Write(" ", f.Logic, " ")
+		}
+		if err := writeFilter(b, conditions, operand); err != nil {
+			return err
+		}
+	}
+	b.Write(")")
+	return nil
+}
+
+// writeCondition writes a single condition, e.g. `"forename" IN ($1,$2)`
+func writeCondition(b *sqlbuilder.Builder, cond rsql.Condition) error {
+	if cond.Subquery != nil {
+		return writeSubquery(b, cond)
+	}
 
-		// Check for empty condition values
+	if cond.TextSearch != nil {
 		if len(cond.Values) == 0 {
-			return "", []any{}, fmt.Errorf("Condition for col %s with no values", columnName)
+			return fmt.Errorf("Condition for col %s with no values", cond.Column)
 		}
+		writeTSVector(b, cond)
+		b.Write(" @@ ")
+		writeTSQuery(b, cond)
+		return nil
+	}
+
+	// `= ANY` compares a value with each element of an array column, e.g.
+	// `($1) = ANY("formats")`
+	if cond.SQLOperator == "= ANY" {
+		if len(cond.Values) == 0 {
+			return fmt.Errorf("Condition for col %s with no values", cond.Column)
+		}
+		b.Write("(").Value(conditionValues(cond)[0]).Write(") = ANY(")
+		writeConditionColumn(b, cond)
+		b.Write(")")
+		return nil
+	}
+
+	writeConditionColumn(b, cond)
+
+	// Null checks do not require placeholders or appending values array
+	if slices.Contains([]string{"IS NULL", "IS NOT NULL"}, cond.SQLOperator) {
+		b.Write(" ", cond.SQLOperator)
+		return nil
+	}
+
+	// Check for empty condition values
+	if len(cond.Values) == 0 {
+		return fmt.Errorf("Condition for col %s with no values", cond.Column)
+	}
 
-		// Append to values array in the same order we add conditions
-		placeholders := []string{}
-		for _, v := range cond.Values {
-			// Placeholder value +1 should match values index
-			placeholders = append(placeholders, fmt.Sprintf("$%d", n))
-			values = append(values, v)
-			n++
+	values := conditionValues(cond)
+	// Ranges are written `"born" BETWEEN ($1) AND ($2)`
+	if cond.SQLOperator == "BETWEEN" || cond.SQLOperator == "NOT BETWEEN" {
+		if len(values) != 2 {
+			return fmt.Errorf("Condition for col %s needs two values", cond.Column)
 		}
+		b.Write(" ", cond.SQLOperator, " (").Value(values[0]).
+			Write(") AND (").Value(values[1]).Write(")")
+		return nil
+	}
+
+	// Add `col {keyword} (...placeholders)` e.g.
+	// `"forename" IN ($1,$2)`
+	b.Write(" ", cond.SQLOperator, " (").ValueList(values)
+	// JSONPath expressions are cast explicitly, e.g. `@? ($1::jsonpath)`
+	if cond.SQLOperator == "@?" {
+		b.Write("::jsonpath")
+	}
+	b.Write(")")
+	return nil
+}
+
+// conditionValues returns a condition's values, preferring values parsed as
+// the column's type
+func conditionValues(cond rsql.Condition) []any {
+	if len(cond.Args) > 0 {
+		return cond.Args
+	}
+	values := []any{}
+	for _, v := range cond.Values {
+		values = append(values, v)
+	}
+	return values
+}
+
+// writeSubquery writes a condition with a subquery, whose own conditions are
+// written in the same statement so that placeholders are numbered in order,
+// e.g. `"authors"."id" IN (SELECT "books"."author_id" FROM "public"."books" WHERE "genre_id" = ($1))`
+// or, correlated with the column,
+// `EXISTS (SELECT 1 FROM "public"."books" WHERE "books"."author_id" = "authors"."id")`
+func writeSubquery(b *sqlbuilder.Builder, cond rsql.Condition) error {
+	subquery := cond.Subquery
+	keyword := " WHERE "
+	if cond.SQLOperator == "IN" {
+		writeConditionColumn(b, cond)
+		b.Write(" IN (SELECT ").Ident(subquery.Table, subquery.Column)
+		b.Write(" FROM ").Ident(subquery.Schema, subquery.Table)
+	} else {
+		b.Write(cond.SQLOperator, " (SELECT 1 FROM ").Ident(subquery.Schema, subquery.Table)
+		b.Write(" WHERE ").Ident(subquery.Table, subquery.Column).Write(" = ")
+		writeConditionColumn(b, cond)
+		keyword = " AND "
+	}
+	for _, c := range subquery.Conditions {
+		b.Write(keyword)
+		if err := writeCondition(b, c); err != nil {
+			return err
+		}
+		keyword = " AND "
+	}
+	b.Write(")")
+	return nil
+}
+
+// writeConditionColumn writes a condition's column and its cast, which is
+// validated by the service, e.g. `"born"::text`
+func writeConditionColumn(b *sqlbuilder.Builder, cond rsql.Condition) {
+	writeColumn(b, cond.Column)
+	if cond.Cast != "" {
+		b.Write("::", cond.Cast)
+	}
+}
 
-		// Add `col {keyword} (...placeholders)` e.g.
-		// `forename IN ($1,$2)`
-		condition = fmt.Sprintf(
-			"%s %s (%s)",
-			columnName,
-			cond.SQLOperator,
-			strings.Join(placeholders, ","),
-		)
+// writeTSVector writes the column of a full-text search condition, converted
+// with to_tsvector unless it is a tsvector, e.g.
+// `to_tsvector($1::regconfig, "title")`
+func writeTSVector(b *sqlbuilder.Builder, cond rsql.Condition) {
+	if !cond.TextSearch.ToTSVector {
+		writeConditionColumn(b, cond)
+		return
+	}
+	b.Write("to_tsvector(")
+	if cond.TextSearch.Config != "" {
+		b.Value(cond.TextSearch.Config).Write("::regconfig, ")
+	}
+	writeConditionColumn(b, cond)
+	b.Write(")")
+}
 
-		sqlConditions = append(sqlConditions, condition)
+// writeTSQuery writes the search terms of a full-text search condition
+// parsed by its tsquery function, e.g. `plainto_tsquery($1::regconfig, $2)`
+func writeTSQuery(b *sqlbuilder.Builder, cond rsql.Condition) {
+	b.Write(cond.TextSearch.Func, "(")
+	if cond.TextSearch.Config != "" {
+		b.Value(cond.TextSearch.Config).Write("::regconfig, ")
+	}
+	b.Value(cond.Values[0]).Write(")")
+}
+
+// writeColumn writes a column as it would be used in a WHERE or ORDER BY
+// clause, e.g. `"books"."title"`. Paths into json columns are written with
+// their keys as placeholders, e.g. `("meta" -> $1::text ->> $2::int)`, where
+// keys that are integers index json arrays
+func writeColumn(b *sqlbuilder.Builder, c rsql.Column) {
+	if len(c.Path) > 0 {
+		b.Write("(")
+	}
+	if c.Qualifier != "" {
+		b.Ident(c.Qualifier, c.Name)
+	} else {
+		b.Ident(c.Name)
 	}
+	for i, key := range c.Path {
+		if c.PathAsText && i == len(c.Path)-1 {
+			b.Write(" ->> ")
+		} else {
+			b.Write(" -> ")
+		}
+		if index, err := strconv.ParseInt(key, 10, 64); err == nil {
+			b.Value(index).Write("::int")
+		} else {
+			b.Value(key).Write("::text")
+		}
+	}
+	if len(c.Path) > 0 {
+		b.Write(")")
+	}
+}
 
-	conditional := fmt.Sprintf("WHERE %s", strings.Join(sqlConditions, " AND "))
-	return conditional, values, nil
+// writeDistinct writes DISTINCT, or DISTINCT ON and its columns, after the
+// SELECT keyword if the query has either, e.g.
+// `DISTINCT ON ("books"."author_id") `. Columns are written with the table
+// that validation resolved them to
+func writeDistinct(b *sqlbuilder.Builder, query rsql.QueryParams) {
+	if len(query.DistinctOn) > 0 {
+		b.Write("DISTINCT ON (")
+		b.Join(", ", len(query.DistinctOn), func(i int) {
+			writeColumn(b, query.DistinctOn[i])
+		})
+		b.Write(") ")
+	} else if query.Distinct {
+		b.Write("DISTINCT ")
+	}
 }
 
-func buildSelectColumns(query rsql.QueryParams) string {
+// writeSelectColumns writes the columns of a SELECT statement, e.g.
+// `"books"."title" AS "t"`. Paths into json columns are named after their
+// last key unless they have an alias
+func writeSelectColumns(b *sqlbuilder.Builder, query rsql.QueryParams) {
 	// If no columns were specified, the SELECT statement should be `SELECT *`
 	if len(query.Columns) == 0 {
-		return "*"
+		b.Write("*")
+		return
 	}
+	b.Join(", ", len(query.Columns), func(i int) {
+		c := query.Columns[i]
+		if c.Expr != nil {
+			writeExpr(b, *c.Expr)
+		} else {
+			writeColumn(b, c)
+		}
+		alias := c.Alias
+		if alias == "" && len(c.Path) > 0 {
+			alias = c.Path[len(c.Path)-1]
+		}
+		if alias != "" {
+			b.Write(" AS ").Ident(alias)
+		}
+	})
+}
 
-	cols := []string{}
-	for _, c := range query.Columns {
-		cols = append(cols, c.ToSQLString())
+// writeExpr writes the expression of a computed column, with its numbers and
+// strings as placeholders, e.g. `(("died" - "born") * $1::int4)`, and window
+// functions followed by their window. Numbers are typed as int4, int8 or
+// numeric, as they would be in SQL, while strings are left for Postgres to
+// type from their context
+func writeExpr(b *sqlbuilder.Builder, e rsql.Expr) {
+	switch e.Kind {
+	case rsql.EXPR_COLUMN:
+		writeColumn(b, e.Column)
+	case rsql.EXPR_NUMBER:
+		if _, err := strconv.ParseInt(e.Value, 10, 32); err == nil {
+			b.Value(e.Value).Write("::int4")
+		} else if _, err := strconv.ParseInt(e.Value, 10, 64); err == nil {
+			b.Value(e.Value).Write("::int8")
+		} else {
+			b.Value(e.Value).Write("::numeric")
+		}
+	case rsql.EXPR_STRING:
+		b.Value(e.Value)
+	case rsql.EXPR_CAST:
+		b.Write("(")
+		writeExpr(b, e.Operands[0])
+		b.Write(")::", e.Op)
+	case rsql.EXPR_FUNCTION:
+		if e.Op == "extract" {
+			b.Write("EXTRACT(", strings.ToUpper(e.Field), " FROM ")
+			writeExpr(b, e.Operands[0])
+			b.Write(")")
+			return
+		}
+		b.Write(e.Op, "(")
+		if e.Field != "" {
+			b.Value(e.Field).Write("::text, ")
+		}
+		b.Join(", ", len(e.Operands), func(i int) { writeExpr(b, e.Operands[i]) })
+		b.Write(")")
+		if e.Over != nil {
+			writeWindow(b, *e.Over)
+		}
+	case rsql.EXPR_OPERATOR:
+		b.Write("(")
+		if len(e.Operands) == 1 {
+			b.Write(e.Op)
+			writeExpr(b, e.Operands[0])
+		} else {
+			writeExpr(b, e.Operands[0])
+			b.Write(" ", e.Op, " ")
+			writeExpr(b, e.Operands[1])
+		}
+		b.Write(")")
 	}
-	return strings.Join(cols, ", ")
 }
 
-// buildJoinRelations builds SQL JOIN clauses
-func buildJoinRelations(query rsql.QueryParams) string {
-	if len(query.Joins) == 0 {
-		return ""
+// writeWindow writes the OVER clause of a window function, e.g.
+// ` OVER (PARTITION BY "author_id" ORDER BY "born" DESC)`
+func writeWindow(b *sqlbuilder.Builder, w rsql.Window) {
+	b.Write(" OVER (")
+	if len(w.PartitionBy) > 0 {
+		b.Write("PARTITION BY ")
+		b.Join(", ", len(w.PartitionBy), func(i int) { writeColumn(b, w.PartitionBy[i]) })
+	}
+	if len(w.OrderBy) > 0 {
+		if len(w.PartitionBy) > 0 {
+			b.Write(" ")
+		}
+		b.Write("ORDER BY ")
+		writeOrderByColumns(b, w.OrderBy, nil)
 	}
-	joins := []string{}
+	b.Write(")")
+}
+
+// writeJoinRelations writes SQL JOIN clauses. Joined tables are qualified
+// with the schema and given their alias, while the ON clause refers to them
+// by their alias or bare name, e.g.
+// `JOIN "public"."authors" AS "editor" ON "books"."editor_id" = "editor"."id" AND "editor"."born" > ($1)`
+func writeJoinRelations(b *sqlbuilder.Builder, query rsql.QueryParams, schema string) error {
 	for _, j := range query.Joins {
-		joins = append(
-			joins,
-			j.ToSQLString(),
-		)
+		b.Write(" ", j.Type, " ")
+		if j.IsLateral() {
+			if err := writeLateralJoin(b, j, schema); err != nil {
+				return err
+			}
+			continue
+		}
+		b.Ident(schema, j.Table)
+		if j.Alias != "" {
+			b.Write(" AS ").Ident(j.Alias)
+		}
+		// A cross join has no terms, so no ON clause
+		if err := writeJoinTerms(b, " ON ", j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLateralJoin writes a lateral join's subquery of the first rows of its
+// table, where the join's terms are, e.g.
+// `(SELECT * FROM "public"."books" WHERE "books"."author_id" = "authors"."id" ORDER BY "id" DESC LIMIT $1) AS "books" ON true`
+func writeLateralJoin(b *sqlbuilder.Builder, j rsql.JoinRelation, schema string) error {
+	b.Write("(SELECT * FROM ").Ident(schema, j.Table)
+	if j.Alias != "" {
+		b.Write(" AS ").Ident(j.Alias)
+	}
+	if err := writeJoinTerms(b, " WHERE ", j); err != nil {
+		return err
+	}
+	sub := rsql.QueryParams{Conditions: j.Conditions, OrderBy: j.OrderBy, Limit: -1}
+	if j.Limit > 0 {
+		sub.Limit = j.Limit
+	}
+	writeOrderByClause(b, sub)
+	writeLimitClause(b, sub)
+	b.Write(") AS ").Ident(j.Name()).Write(" ON true")
+	return nil
+}
+
+// writeJoinTerms writes a join's equalities of columns and conditions after
+// a keyword, combined with AND, if the join has any
+func writeJoinTerms(b *sqlbuilder.Builder, keyword string, j rsql.JoinRelation) error {
+	if len(j.On) == 0 && len(j.Conditions) == 0 {
+		return nil
+	}
+	b.Write(keyword)
+	b.Join(" AND ", len(j.On), func(i int) {
+		b.Ident(j.On[i].Left.Qualifier, j.On[i].Left.Name).
+			Write(" = ").
+			Ident(j.On[i].Right.Qualifier, j.On[i].Right.Name)
+	})
+	for i, cond := range j.Conditions {
+		if i > 0 || len(j.On) > 0 {
+			b.Write(" AND ")
+		}
+		if err := writeCondition(b, cond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOrderByClause writes SQL ORDER BY clause if any columns were set
+func writeOrderByClause(b *sqlbuilder.Builder, query rsql.QueryParams) {
+	if len(query.OrderBy) == 0 {
+		return
+	}
+	b.Write(" ORDER BY ")
+	writeOrderByColumns(b, query.OrderBy, query.Conditions)
+}
+
+// writeOrderByColumns writes the columns of an ORDER BY clause and their
+// direction, where the `rank` pseudo-column ranks rows by the full-text
+// search conditions, e.g. `"born" DESC, "id" ASC`
+func writeOrderByColumns(b *sqlbuilder.Builder, orderBy []rsql.OrderBy, conditions []rsql.Condition) {
+	b.Join(", ", len(orderBy), func(i int) {
+		o := orderBy[i]
+		if o.Rank {
+			writeRank(b, conditions)
+		} else {
+			writeColumn(b, o.Column)
+		}
+		if o.Desc {
+			b.Write(" DESC")
+		} else {
+			b.Write(" ASC")
+		}
+	})
+}
+
+// writeRank writes the `rank` pseudo-column, the sum of how well a row
+// matches each full-text search condition, e.g.
+// `ts_rank(to_tsvector("title"), to_tsquery($1))`
+func writeRank(b *sqlbuilder.Builder, conditions []rsql.Condition) {
+	b.Write("(")
+	first := true
+	for _, cond := range conditions {
+		if cond.TextSearch == nil {
+			continue
+		}
+		if !first {
+			b.Write(" + ")
+		}
+		first = false
+		b.Write("ts_rank(")
+		writeTSVector(b, cond)
+		b.Write(", ")
+		writeTSQuery(b, cond)
+		b.Write(")")
 	}
-	return strings.Join(joins, " ")
+	b.Write(")")
 }
 
-// buildLimitClause builds SQL LIMIT clause. query.Limit is initially set to -1
-// instead of the default `0` value for an int. If Limit is still -1 by the
+// writeLimitClause writes SQL LIMIT clause. query.Limit is initially set to
+// -1 instead of the default `0` value for an int. If Limit is still -1 by the
 // time we are creating this clause, then no Limit was set by the user, so
-// return an empty string.
-func buildLimitClause(query rsql.QueryParams) string {
+// nothing is written.
+func writeLimitClause(b *sqlbuilder.Builder, query rsql.QueryParams) {
 	if query.Limit == -1 {
-		return ""
+		return
 	}
-	return fmt.Sprintf("LIMIT %d", query.Limit)
+	b.Write(" LIMIT ").Value(query.Limit)
 }
 
-// buildOffsetClause builds SQL OFFSET clause if one was set (non-zero).
-func buildOffsetClause(query rsql.QueryParams) string {
+// writeOffsetClause writes SQL OFFSET clause if one was set (non-zero).
+func writeOffsetClause(b *sqlbuilder.Builder, query rsql.QueryParams) {
 	if query.Offset == 0 {
-		return ""
+		return
 	}
-	return fmt.Sprintf("OFFSET %d", query.Offset)
+	b.Write(" OFFSET ").Value(query.Offset)
 }
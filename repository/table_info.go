@@ -4,10 +4,11 @@ import (
 	"gopgrest/apperrors"
 )
 
-// GetTable gets a table from the tables slice by name
+// GetTable gets a table in the Repository's schema from the tables slice by
+// name
 func (r *Repository) GetTable(tableName string) (*Table, error) {
 	for _, t := range r.Tables {
-		if t.Name == tableName {
+		if t.Schema == r.Schema && t.Name == tableName {
 			return &t, nil
 		}
 	}
@@ -4,6 +4,7 @@ import (
 	"slices"
 	"testing"
 
+	"gopgrest/apperrors"
 	"gopgrest/assert"
 	"gopgrest/repository"
 	"gopgrest/tests"
@@ -16,7 +17,17 @@ func Test_GetPublicTables(t *testing.T) {
 	tables, err := repository.GetPublicTables(tdb.DB)
 	assert.Try(t, err)
 
-	expectedTables := []string{"authors", "books", "genres"}
+	expectedTables := []string{
+		"authors",
+		"books",
+		"genres",
+		"editions",
+		"nineteenth_century_authors",
+		"book_authors",
+		"genre_names",
+		"genre_counts",
+		"archive",
+	}
 	foundTables := []string{}
 	for _, table := range tables {
 		// Check for extraneous tables
@@ -27,3 +38,123 @@ func Test_GetPublicTables(t *testing.T) {
 		assert.IsTrue(t, slices.Contains(foundTables, table))
 	}
 }
+
+func Test_GetPublicTables_Views(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	repo := repository.NewRepository(tdb.DB, tdb.Tables, tdb.Functions, tests.SCHEMAS)
+
+	cases := []struct {
+		name       string
+		kind       string
+		insertable bool
+		updatable  bool
+	}{
+		{"authors", repository.TABLE, true, true},
+		{"nineteenth_century_authors", repository.VIEW, true, true},
+		{"book_authors", repository.VIEW, false, false},
+		{"genre_names", repository.VIEW, false, true},
+		{"genre_counts", repository.MATVIEW, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			table, err := repo.GetTable(c.name)
+			assert.Try(t, err)
+			assert.IsEq(t, table.Kind, c.kind)
+			assert.IsEq(t, table.Insertable, c.insertable)
+			assert.IsEq(t, table.Updatable, c.updatable)
+		})
+	}
+}
+
+func Test_GetTables_Schemas(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	tables, err := repository.GetTables(tdb.DB, tests.SCHEMAS)
+	assert.Try(t, err)
+	repo := repository.NewRepository(tdb.DB, tables, tdb.Functions, tests.SCHEMAS)
+
+	// Schemas are exposed in the order they were configured
+	assert.IsTrue(t, slices.Equal(repo.Schemas, tests.SCHEMAS))
+	assert.IsEq(t, repo.Schema, "public")
+
+	t.Run("tables are looked up in the default schema", func(t *testing.T) {
+		table, err := repo.GetTable("authors")
+		assert.Try(t, err)
+		assert.IsEq(t, table.QualifiedName(), "public.authors")
+		_, err = repo.GetTable("letters")
+		assert.ErrorsIs(t, err, apperrors.TableDoesNotExist)
+	})
+
+	t.Run("tables are looked up in another schema", func(t *testing.T) {
+		archive, err := repo.WithSchema("archive")
+		assert.Try(t, err)
+		table, err := archive.GetTable("authors")
+		assert.Try(t, err)
+		assert.IsEq(t, table.QualifiedName(), "archive.authors")
+		assert.IsEq(t, len(table.Columns), 3)
+		_, err = archive.GetTable("books")
+		assert.ErrorsIs(t, err, apperrors.TableDoesNotExist)
+		assert.IsEq(t, len(archive.TablesRepr), 5)
+	})
+
+	t.Run("schema without tables is exposed", func(t *testing.T) {
+		schemas := append(slices.Clone(tests.SCHEMAS), "drafts")
+		repo := repository.NewRepository(tdb.DB, tables, tdb.Functions, schemas)
+		assert.IsTrue(t, slices.Equal(repo.Schemas, schemas))
+		drafts, err := repo.WithSchema("drafts")
+		assert.Try(t, err)
+		assert.IsEq(t, len(drafts.TablesRepr), 0)
+	})
+
+	t.Run("schema is not exposed", func(t *testing.T) {
+		_, err := repo.WithSchema("pg_catalog")
+		assert.ErrorsIs(t, err, apperrors.SchemaNotExposed)
+	})
+}
+
+func Test_GetPublicTables_Constraints(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	repo := repository.NewRepository(tdb.DB, tdb.Tables, tdb.Functions, tests.SCHEMAS)
+
+	authors, err := repo.GetTable("authors")
+	assert.Try(t, err)
+	surname, _ := authors.GetColumn("surname")
+	assert.IsTrue(t, surname.Constraints.Required())
+	assert.IsEq(t, surname.Constraints.MaxLength, 150)
+	forename, _ := authors.GetColumn("forename")
+	assert.IsTrue(t, forename.Constraints.NotNull)
+	assert.IsTrue(t, !forename.Constraints.Required())
+	id, _ := authors.GetColumn("id")
+	assert.IsTrue(t, id.Constraints.HasDefault)
+
+	editions, err := repo.GetTable("editions")
+	assert.Try(t, err)
+	price, _ := editions.GetColumn("price")
+	assert.IsEq(t, price.Constraints.Precision, 6)
+	assert.IsEq(t, price.Constraints.Scale, 2)
+	cover, _ := editions.GetColumn("cover")
+	assert.IsTrue(t, slices.Equal(cover.Constraints.EnumLabels, []string{"hardcover", "paperback"}))
+}
+
+func Test_GetTables_PrimaryKey(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	repo := repository.NewRepository(tdb.DB, tdb.Tables, tdb.Functions, tests.SCHEMAS)
+	archive, err := repo.WithSchema("archive")
+	assert.Try(t, err)
+
+	cases := []struct {
+		name       string
+		repo       repository.Repository
+		primaryKey []string
+	}{
+		{"authors", repo, []string{"id"}},
+		{"recipients", archive, []string{"letter_id", "recipient"}},
+		{"book_authors", repo, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			table, err := c.repo.GetTable(c.name)
+			assert.Try(t, err)
+			assert.IsTrue(t, slices.Equal(table.PrimaryKey, c.primaryKey))
+		})
+	}
+}
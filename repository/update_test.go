@@ -24,7 +24,7 @@ func Test_RepoUpdateRowByRSQL(t *testing.T) {
 	conditions := []rsql.Condition{
 		{Column: rsql.Column{Name: "forename"}, Values: []string{"Anne"}, SQLOperator: "="},
 	}
-	ids, err := repo.UpdateRowsByRSQL("authors", conditions, &update)
+	ids, err := repo.UpdateRowsByRSQL("authors", rsql.QueryParams{Conditions: conditions}, &update)
 	assert.Try(t, err)
 	assert.IsTrue(t, len(ids) == len(expAuthors))
 
@@ -0,0 +1,182 @@
+package rsql
+
+import (
+	"strconv"
+	"strings"
+
+	"gopgrest/repatterns"
+)
+
+// Query is the syntax tree of a URL: the table in its path and the clauses of
+// its query in the order they were written, e.g.
+// `/authors?where=born<1900&select=surname`
+type Query struct {
+	Table   string
+	Clauses []Clause
+}
+
+// Clause is one of the `&` separated clauses of a URL query, e.g.
+// `where=born<1900`. Its Keyword decides which of the other fields is set:
+// Conditions for `where`, Filter for `filter`, whose conditions are the
+// clause's Conditions, Columns for `select` and `distinct_on`, Joins for join
+// clauses, OrderBy for `order_by`, Number for `limit` and `offset` and Bool
+// for `distinct`
+type Clause struct {
+	Keyword    string
+	Conditions []Condition
+	Filter     *Filter
+	Columns    []Column
+	Joins      []JoinRelation
+	OrderBy    []OrderBy
+	Number     int
+	Bool       bool
+}
+
+// Parse parses a URL into the syntax tree of its query
+func Parse(url string) (Query, error) {
+	pq, err := newPathQuery(url)
+	if err != nil {
+		return Query{}, err
+	}
+	// Has table in URL but no query
+	if pq == nil {
+		return Query{Table: repatterns.ReqNoParams.FindStringSubmatch(url)[1]}, nil
+	}
+	q := Query{Table: pq.Resource}
+
+	// Clauses are split at "&" before they are decoded, e.g. "where=..." +
+	// "select=..."
+	for clauseStr := range strings.SplitSeq(pq.Query, CLAUSE_SEP) {
+		c, err := newClause(clauseStr)
+		if err != nil {
+			return Query{}, err
+		}
+		q.Clauses = append(q.Clauses, c)
+	}
+	return q, nil
+}
+
+// newClause parses a clause string, e.g. `select=forename,surname`
+func newClause(clauseStr string) (Clause, error) {
+	keyword, p, err := parseClause(clauseStr)
+	if err != nil {
+		return Clause{}, err
+	}
+	c := Clause{Keyword: keyword}
+	switch keyword {
+	case WHERE: // e.g ?where=forename=in=Ann,Anne;surname==Carson
+		c.Conditions, err = p.parseConditions()
+	case FILTER: // e.g. ?filter=forename=in=(Ann,Anne),born=gt=1900
+		c.Filter, err = p.parseFilter(&c.Conditions)
+	case SELECT: // e.g. ?select=forename,surname:last_name
+		c.Columns, err = p.parseSelect()
+	case DISTINCT: // e.g. ?distinct=true
+		c.Bool, err = p.parseBool()
+	case DISTINCTON: // e.g. ?distinct_on=author_id&order_by=author_id,born:desc
+		c.Columns, err = p.parseColumns()
+	case JOIN, INNERJOIN, LEFTJOIN, RIGHTJOIN, FULLJOIN, CROSSJOIN, LATERALJOIN, LEFTLATERALJOIN:
+		c.Joins, err = p.parseJoins(keyword)
+	case ORDERBY: // e.g. ?order_by=born:desc,surname
+		c.OrderBy, err = p.parseOrderBy()
+	case LIMIT, OFFSET:
+		c.Number, err = p.parseInt()
+	}
+	if err != nil {
+		return Clause{}, err
+	}
+	return c, nil
+}
+
+// Params gathers the clauses of a Query into the QueryParams of a SQL query.
+// The conditions of every `where` and `filter` clause and the joins of every
+// join clause are kept, and the filters of several `filter` clauses must all
+// be true. A later clause of any other keyword replaces an earlier one
+func (q Query) Params() QueryParams {
+	// If Limit is still -1 before executing the query, then no limit was set
+	// by user
+	query := QueryParams{Limit: -1, Tables: []string{q.Table}}
+	for _, c := range q.Clauses {
+		switch c.Keyword {
+		case WHERE:
+			query.Conditions = append(query.Conditions, c.Conditions...)
+		case FILTER:
+			f := c.Filter.shift(len(query.Conditions))
+			if query.Filter != nil {
+				f = Filter{Logic: AND, Operands: []Filter{*query.Filter, f}}
+			}
+			query.Filter = &f
+			query.Conditions = append(query.Conditions, c.Conditions...)
+		case SELECT:
+			query.Columns = c.Columns
+		case DISTINCT:
+			query.Distinct = c.Bool
+		case DISTINCTON:
+			query.DistinctOn = c.Columns
+		case ORDERBY:
+			query.OrderBy = c.OrderBy
+		case LIMIT:
+			query.Limit = c.Number
+		case OFFSET:
+			query.Offset = c.Number
+		default:
+			query.Joins = append(query.Joins, c.Joins...)
+			// Add all referenced tables to the query
+			for _, j := range c.Joins {
+				query.Tables = append(query.Tables, j.Table)
+			}
+		}
+	}
+	return query
+}
+
+// shift returns the Filter with the indexes of its conditions moved by n, as
+// its conditions are appended after n others
+func (f Filter) shift(n int) Filter {
+	if f.Logic == "" {
+		return Filter{Condition: f.Condition + n}
+	}
+	operands := make([]Filter, len(f.Operands))
+	for i, operand := range f.Operands {
+		operands[i] = operand.shift(n)
+	}
+	return Filter{Logic: f.Logic, Operands: operands}
+}
+
+// String returns the Query as a URL with its clauses in the order they were
+// written, e.g. `/authors?where=surname=="Carson, Anne"&order_by=born:desc`,
+// which parses back to the same Query
+func (q Query) String() string {
+	if len(q.Clauses) == 0 {
+		return "/" + q.Table
+	}
+	clauses := make([]string, len(q.Clauses))
+	for i, c := range q.Clauses {
+		clauses[i] = c.Keyword + CLAUSE_ASSIGN + escapeParam(c.value())
+	}
+	return "/" + q.Table + "?" + strings.Join(clauses, CLAUSE_SEP)
+}
+
+// String returns the Clause as it would be written in a URL query, e.g.
+// `where=born<1900`
+func (c Clause) String() string {
+	return c.Keyword + CLAUSE_ASSIGN + c.value()
+}
+
+// value returns the value of the Clause after its keyword
+func (c Clause) value() string {
+	switch c.Keyword {
+	case WHERE:
+		return joinStrings(c.Conditions, ITEM_SEP)
+	case FILTER:
+		return QueryParams{Conditions: c.Conditions}.filterString(*c.Filter, false)
+	case SELECT, DISTINCTON:
+		return joinStrings(c.Columns, VALUES_LIST_SEP)
+	case DISTINCT:
+		return strconv.FormatBool(c.Bool)
+	case ORDERBY:
+		return joinStrings(c.OrderBy, VALUES_LIST_SEP)
+	case LIMIT, OFFSET:
+		return strconv.Itoa(c.Number)
+	}
+	return joinStrings(c.Joins, ITEM_SEP)
+}
@@ -0,0 +1,604 @@
+package rsql
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"gopgrest/repatterns"
+)
+
+// Kinds of Expr
+const (
+	EXPR_COLUMN   = "column"
+	EXPR_NUMBER   = "number"
+	EXPR_STRING   = "string"
+	EXPR_OPERATOR = "operator"
+	EXPR_FUNCTION = "function"
+	EXPR_CAST     = "cast"
+)
+
+// Terms of the window of a window function, e.g.
+// `over(partition_by=author_id;order_by=born)`
+const (
+	WINDOW_OVER = "over"
+	PARTITIONBY = "partition_by"
+)
+
+// exprOperators are the binary operators of a computed column, from the
+// loosest to the tightest binding. Operators of a level are left associative
+var exprOperators = [][]string{{"||"}, {"+", "-"}, {"*", "/", "%"}}
+
+// ExprFunction is a function a computed column may call, taking from MinArgs
+// to MaxArgs expressions, or any number of them if MaxArgs is -1. A window
+// function must be followed by its window, e.g. `rank()over(order_by=born)`
+type ExprFunction struct {
+	MinArgs int
+	MaxArgs int
+	Window  bool
+}
+
+// ExprFunctions are the functions a computed column may call, by name
+var ExprFunctions = map[string]ExprFunction{
+	"coalesce":     {MinArgs: 1, MaxArgs: -1},
+	"lower":        {MinArgs: 1, MaxArgs: 1},
+	"upper":        {MinArgs: 1, MaxArgs: 1},
+	"date_trunc":   {MinArgs: 1, MaxArgs: 1},
+	"extract":      {MinArgs: 1, MaxArgs: 1},
+	"row_number":   {Window: true},
+	"rank":         {Window: true},
+	"dense_rank":   {Window: true},
+	"percent_rank": {Window: true},
+	"cume_dist":    {Window: true},
+	"ntile":        {MinArgs: 1, MaxArgs: 1, Window: true},
+	"lag":          {MinArgs: 1, MaxArgs: 3, Window: true},
+	"lead":         {MinArgs: 1, MaxArgs: 3, Window: true},
+	"first_value":  {MinArgs: 1, MaxArgs: 1, Window: true},
+	"last_value":   {MinArgs: 1, MaxArgs: 1, Window: true},
+	"sum":          {MinArgs: 1, MaxArgs: 1, Window: true},
+	"avg":          {MinArgs: 1, MaxArgs: 1, Window: true},
+	"min":          {MinArgs: 1, MaxArgs: 1, Window: true},
+	"max":          {MinArgs: 1, MaxArgs: 1, Window: true},
+	"count":        {MinArgs: 1, MaxArgs: 1, Window: true},
+}
+
+// ExprDateFields maps the functions whose first argument is a date field to
+// the fields they accept, e.g. `date_trunc(month,born)` or `extract(year,born)`
+var ExprDateFields = map[string][]string{
+	"date_trunc": {
+		"microseconds", "milliseconds", "second", "minute", "hour", "day",
+		"week", "month", "quarter", "year", "decade", "century", "millennium",
+	},
+	"extract": {
+		"microseconds", "milliseconds", "second", "minute", "hour", "day",
+		"week", "month", "quarter", "year", "decade", "century", "millennium",
+		"dow", "doy", "epoch", "isodow", "isoyear",
+	},
+}
+
+var (
+	reExprIdent  = regexp.MustCompile(`^` + repatterns.Ident)
+	reExprNumber = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?`)
+	reExprCast   = regexp.MustCompile(`^` + repatterns.Ident + `(\[\])?`)
+)
+
+// Expr is a node of a computed column's expression, e.g. `died-born`. A
+// column, number or string is a leaf, while an operator, function or cast
+// applies to its Operands. Op is the operator, e.g. `||`, the function name or
+// the type of a cast. Field is the date field of `date_trunc` and `extract`,
+// and Over the window of a window function. Numbers and strings are passed to
+// the statement as arguments
+type Expr struct {
+	Kind     string
+	Column   Column
+	Value    string
+	Op       string
+	Field    string
+	Operands []Expr
+	Over     *Window
+}
+
+// Window is the window of a window function, the rows that share its
+// PartitionBy columns' values, in OrderBy's order, e.g.
+// `over(partition_by=author_id;order_by=born:desc)`
+type Window struct {
+	PartitionBy []Column
+	OrderBy     []OrderBy
+}
+
+// String returns the Window as it would be written after a window function
+func (w Window) String() string {
+	terms := []string{}
+	if len(w.PartitionBy) > 0 {
+		terms = append(terms, PARTITIONBY+CLAUSE_ASSIGN+joinStrings(w.PartitionBy, VALUES_LIST_SEP))
+	}
+	if len(w.OrderBy) > 0 {
+		terms = append(terms, ORDERBY+CLAUSE_ASSIGN+joinStrings(w.OrderBy, VALUES_LIST_SEP))
+	}
+	return WINDOW_OVER + "(" + strings.Join(terms, ITEM_SEP) + ")"
+}
+
+// String returns the Expr as it would be written in a select clause, e.g.
+// `surname||', '||forename`
+func (e Expr) String() string {
+	switch e.Kind {
+	case EXPR_COLUMN:
+		return e.Column.String()
+	case EXPR_NUMBER:
+		return e.Value
+	case EXPR_STRING:
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(e.Value) + "'"
+	case EXPR_CAST:
+		return e.Operands[0].operand(len(exprOperators)+1) + CAST_SEP + e.Op
+	case EXPR_FUNCTION:
+		args := []string{}
+		if e.Field != "" {
+			args = append(args, e.Field)
+		}
+		for _, o := range e.Operands {
+			args = append(args, o.String())
+		}
+		call := e.Op + "(" + strings.Join(args, VALUES_LIST_SEP) + ")"
+		if e.Over != nil {
+			call += e.Over.String()
+		}
+		return call
+	}
+	if len(e.Operands) == 1 {
+		return e.Op + e.Operands[0].operand(len(exprOperators))
+	}
+	level := e.level()
+	return e.Operands[0].operand(level) + e.Op + e.Operands[1].operand(level+1)
+}
+
+// level returns how tightly an expression binds, as the index of its level in
+// exprOperators. A negation binds tighter than any binary operator, and a
+// leaf, function or cast tighter still
+func (e Expr) level() int {
+	if e.Kind != EXPR_OPERATOR {
+		return len(exprOperators) + 1
+	}
+	if len(e.Operands) == 1 {
+		return len(exprOperators)
+	}
+	return slices.IndexFunc(exprOperators, func(ops []string) bool { return slices.Contains(ops, e.Op) })
+}
+
+// operand formats an operand of an operator or cast, in parentheses if it
+// binds less tightly than level
+func (e Expr) operand(level int) string {
+	if e.level() < level {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+// Columns returns pointers to the columns an expression refers to
+func (e *Expr) Columns() []*Column {
+	if e.Kind == EXPR_COLUMN {
+		return []*Column{&e.Column}
+	}
+	columns := []*Column{}
+	for i := range e.Operands {
+		columns = append(columns, e.Operands[i].Columns()...)
+	}
+	if e.Over != nil {
+		for i := range e.Over.PartitionBy {
+			columns = append(columns, &e.Over.PartitionBy[i])
+		}
+		for i := range e.Over.OrderBy {
+			columns = append(columns, &e.Over.OrderBy[i].Column)
+		}
+	}
+	return columns
+}
+
+// selectItemEnd returns the offset of the `,` that ends the column of a select
+// clause beginning at start, or the end of the clause, and whether the column
+// is computed. A computed column contains an operator, parentheses, a cast, a
+// string literal or a space, e.g. `died-born`, but not `meta->tags`. A `,` in
+// parentheses or a string literal doesn't end the column
+func selectItemEnd(clause string, start int) (int, bool) {
+	computed, inAlias, depth := false, false, 0
+	for i := start; i < len(clause); i++ {
+		c := clause[i]
+		if inAlias {
+			if isQuoteStart(clause, i) {
+				end, err := quoteEnd(clause, i)
+				if err != nil {
+					return len(clause), computed
+				}
+				i = end
+			} else if c == ',' {
+				return i, computed
+			}
+			continue
+		}
+		switch {
+		case c == '\'' || isQuoteStart(clause, i):
+			computed = computed || c == '\''
+			end, err := quoteEnd(clause, i)
+			if err != nil {
+				return len(clause), computed
+			}
+			i = end
+		case c == '(':
+			computed = true
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth <= 0:
+			return i, computed
+		case strings.HasPrefix(clause[i:], CAST_SEP):
+			computed = true
+			i++
+		case c == ':' && depth <= 0:
+			inAlias = true
+		case strings.HasPrefix(clause[i:], JSON_SEP):
+			i++
+		case strings.IndexByte("|+-*/% ", c) >= 0:
+			computed = true
+		}
+	}
+	return len(clause), computed
+}
+
+// exprParser is a recursive-descent parser of a computed column's expression,
+// from byte offset i up to end. Its characters are parsed rather than its
+// tokens, as the lexer doesn't split operators such as `||` or `-`, and a
+// quote in an expression always begins a string literal
+type exprParser struct {
+	clause string
+	i      int
+	end    int
+}
+
+func (p *exprParser) errorf(pos int, format string, args ...any) error {
+	return &ParseError{Clause: p.clause, Offset: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// describe describes the character at i
+func (p *exprParser) describe() string {
+	if p.i >= p.end {
+		return "end of column"
+	}
+	r, _ := utf8.DecodeRuneInString(p.clause[p.i:])
+	return fmt.Sprintf("%q", string(r))
+}
+
+func (p *exprParser) skipSpace() {
+	for p.i < p.end && p.clause[p.i] == ' ' {
+		p.i++
+	}
+}
+
+// accept consumes s if it is next, after any spaces
+func (p *exprParser) accept(s string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.clause[p.i:p.end], s) {
+		p.i += len(s)
+		return true
+	}
+	return false
+}
+
+// match consumes the match of re at i, if any
+func (p *exprParser) match(re *regexp.Regexp) string {
+	m := re.FindString(p.clause[p.i:p.end])
+	p.i += len(m)
+	return m
+}
+
+// parse parses a whole expression, which ends at the end of the column or
+// the `:` before its alias
+func (p *exprParser) parse() (Expr, error) {
+	expr, err := p.parseBinary(0)
+	if err != nil {
+		return Expr{}, err
+	}
+	p.skipSpace()
+	if p.i < p.end && p.clause[p.i] != ':' {
+		return Expr{}, p.errorf(p.i, "unexpected %s", p.describe())
+	}
+	return expr, nil
+}
+
+// parseBinary parses operands separated by the operators of a level of
+// exprOperators
+func (p *exprParser) parseBinary(level int) (Expr, error) {
+	if level == len(exprOperators) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return Expr{}, err
+	}
+	for {
+		op := p.acceptOperator(exprOperators[level])
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return Expr{}, err
+		}
+		left = Expr{Kind: EXPR_OPERATOR, Op: op, Operands: []Expr{left, right}}
+	}
+}
+
+// acceptOperator consumes the next operator if it is one of ops
+func (p *exprParser) acceptOperator(ops []string) string {
+	p.skipSpace()
+	if strings.HasPrefix(p.clause[p.i:p.end], JSON_SEP) {
+		return ""
+	}
+	for _, op := range ops {
+		if p.accept(op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// parseUnary parses an optionally negated operand with any casts, e.g.
+// `-born::int8`, which is negated after it is cast
+func (p *exprParser) parseUnary() (Expr, error) {
+	if p.acceptOperator([]string{"-"}) != "" {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return Expr{}, err
+		}
+		return Expr{Kind: EXPR_OPERATOR, Op: "-", Operands: []Expr{operand}}, nil
+	}
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return Expr{}, err
+	}
+	for p.accept(CAST_SEP) {
+		cast := p.match(reExprCast)
+		if cast == "" {
+			return Expr{}, p.errorf(p.i, "expected type after %s, found %s", CAST_SEP, p.describe())
+		}
+		expr = Expr{Kind: EXPR_CAST, Op: strings.ToLower(cast), Operands: []Expr{expr}}
+	}
+	return expr, nil
+}
+
+// parsePrimary parses an expression in parentheses, a string literal, a
+// number, a function call or a column
+func (p *exprParser) parsePrimary() (Expr, error) {
+	p.skipSpace()
+	start := p.i
+	if p.accept("(") {
+		expr, err := p.parseBinary(0)
+		if err != nil {
+			return Expr{}, err
+		}
+		if !p.accept(")") {
+			return Expr{}, p.errorf(p.i, "expected \")\", found %s", p.describe())
+		}
+		return expr, nil
+	}
+	if p.i < p.end && p.clause[p.i] == '\'' {
+		value, err := p.parseString()
+		return Expr{Kind: EXPR_STRING, Value: value}, err
+	}
+
+	// A number unless it is the start of a name, e.g. `1900` but not `1st`
+	rest := p.clause[p.i:p.end]
+	if number := reExprNumber.FindString(rest); number != "" && len(number) >= len(reExprIdent.FindString(rest)) {
+		p.i += len(number)
+		return Expr{Kind: EXPR_NUMBER, Value: number}, nil
+	}
+	name := p.match(reExprIdent)
+	if name == "" {
+		return Expr{}, p.errorf(p.i, "expected expression, found %s", p.describe())
+	}
+	if p.accept("(") {
+		return p.parseFunction(strings.ToLower(name), start)
+	}
+	return p.parseColumn(name)
+}
+
+// parseString parses a string literal in single quotes, in which `\` escapes
+// the next character, e.g. `'O\'Brien'`
+func (p *exprParser) parseString() (string, error) {
+	end, err := quoteEnd(p.clause[:p.end], p.i)
+	if err != nil {
+		return "", p.errorf(p.i, "unterminated string literal")
+	}
+	value, err := unquote(p.clause[p.i : end+1])
+	p.i = end + 1
+	return value, err
+}
+
+// parseFunction parses the arguments of a call of function fn after its
+// `(`, e.g. `coalesce(died,2000)`. A date field is the first argument of
+// `date_trunc` and `extract`, e.g. `extract(year,born)`, and a window
+// function is followed by its window
+func (p *exprParser) parseFunction(fn string, start int) (Expr, error) {
+	function, ok := ExprFunctions[fn]
+	if !ok {
+		return Expr{}, p.errorf(start, "unknown function %s", fn)
+	}
+	expr := Expr{Kind: EXPR_FUNCTION, Op: fn}
+	if fields, ok := ExprDateFields[fn]; ok {
+		p.skipSpace()
+		fieldPos := p.i
+		var field string
+		if p.i < p.end && p.clause[p.i] == '\'' {
+			var err error
+			if field, err = p.parseString(); err != nil {
+				return Expr{}, err
+			}
+		} else {
+			field = p.match(reExprIdent)
+		}
+		expr.Field = strings.ToLower(field)
+		if !slices.Contains(fields, expr.Field) {
+			return Expr{}, p.errorf(fieldPos, "expected a date field of %s, one of %s", fn, strings.Join(fields, ", "))
+		}
+		if !p.accept(VALUES_LIST_SEP) {
+			return Expr{}, p.errorf(p.i, "expected \",\", found %s", p.describe())
+		}
+	}
+	for !p.accept(")") {
+		if len(expr.Operands) > 0 && !p.accept(VALUES_LIST_SEP) {
+			return Expr{}, p.errorf(p.i, "expected \",\" or \")\", found %s", p.describe())
+		}
+		arg, err := p.parseBinary(0)
+		if err != nil {
+			return Expr{}, err
+		}
+		expr.Operands = append(expr.Operands, arg)
+	}
+	n := len(expr.Operands)
+	if n < function.MinArgs || (function.MaxArgs >= 0 && n > function.MaxArgs) {
+		return Expr{}, p.errorf(start, "%s takes %s, found %d", fn, function.arguments(), n)
+	}
+	if function.Window {
+		p.skipSpace()
+		if !strings.HasPrefix(p.clause[p.i:p.end], WINDOW_OVER+"(") {
+			return Expr{}, p.errorf(p.i, "expected %s(...) after window function %s, found %s", WINDOW_OVER, fn, p.describe())
+		}
+		p.i += len(WINDOW_OVER + "(")
+		var err error
+		if expr.Over, err = p.parseWindow(); err != nil {
+			return Expr{}, err
+		}
+	}
+	return expr, nil
+}
+
+// arguments describes how many arguments a function takes, e.g. `1 to 3
+// arguments`
+func (f ExprFunction) arguments() string {
+	switch {
+	case f.MaxArgs < 0:
+		return fmt.Sprintf("at least %d argument(s)", f.MinArgs)
+	case f.MinArgs == f.MaxArgs:
+		return fmt.Sprintf("%d argument(s)", f.MinArgs)
+	}
+	return fmt.Sprintf("%d to %d arguments", f.MinArgs, f.MaxArgs)
+}
+
+// parseWindow parses the `;` separated terms of a window after its `(`, its
+// partition_by and order_by columns, e.g.
+// `partition_by=author_id;order_by=born:desc,id)`. Both are optional
+func (p *exprParser) parseWindow() (*Window, error) {
+	window := &Window{}
+	for i := 0; !p.accept(")"); i++ {
+		if i > 0 && !p.accept(ITEM_SEP) {
+			return nil, p.errorf(p.i, "expected \";\" or \")\", found %s", p.describe())
+		}
+		p.skipSpace()
+		start := p.i
+		term := p.match(reExprIdent)
+		if !p.accept(CLAUSE_ASSIGN) {
+			return nil, p.errorf(start, "expected %s= or %s=, found %s", PARTITIONBY, ORDERBY, p.describe())
+		}
+		var err error
+		switch {
+		case term == PARTITIONBY && window.PartitionBy == nil:
+			window.PartitionBy, err = p.parseWindowColumns()
+		case term == ORDERBY && window.OrderBy == nil:
+			window.OrderBy, err = p.parseWindowOrder()
+		default:
+			return nil, p.errorf(start, "expected %s= or %s= once each, found %q", PARTITIONBY, ORDERBY, term)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return window, nil
+}
+
+// parseWindowColumns parses the `,` separated columns of a window's
+// partition_by, e.g. `author_id,genre_id`
+func (p *exprParser) parseWindowColumns() ([]Column, error) {
+	columns := []Column{}
+	for {
+		p.skipSpace()
+		name := p.match(reExprIdent)
+		if name == "" {
+			return nil, p.errorf(p.i, "expected column, found %s", p.describe())
+		}
+		column, err := p.parseColumn(name)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column.Column)
+		if !p.accept(VALUES_LIST_SEP) {
+			return columns, nil
+		}
+	}
+}
+
+// parseWindowOrder parses the `,` separated columns of a window's order_by,
+// each in ascending order unless followed by `:desc`, e.g. `born:desc,id`
+func (p *exprParser) parseWindowOrder() ([]OrderBy, error) {
+	orderBy := []OrderBy{}
+	for {
+		p.skipSpace()
+		name := p.match(reExprIdent)
+		if name == "" {
+			return nil, p.errorf(p.i, "expected column, found %s", p.describe())
+		}
+		column, err := p.parseColumn(name)
+		if err != nil {
+			return nil, err
+		}
+		o := OrderBy{Column: column.Column}
+		if p.accept(ALIAS_SEP) {
+			start := p.i
+			switch strings.ToLower(p.match(reExprIdent)) {
+			case "desc":
+				o.Desc = true
+			case "asc":
+			default:
+				p.i = start
+				return nil, p.errorf(start, "expected asc or desc, found %s", p.describe())
+			}
+		}
+		orderBy = append(orderBy, o)
+		if !p.accept(VALUES_LIST_SEP) {
+			return orderBy, nil
+		}
+	}
+}
+
+// parseColumn parses a column after its first name, e.g. `authors.surname`
+// or `meta->tags->>0`
+func (p *exprParser) parseColumn(name string) (Expr, error) {
+	names := []string{name}
+	for strings.HasPrefix(p.clause[p.i:p.end], QUALIFIER_SEP) {
+		p.i += len(QUALIFIER_SEP)
+		name := p.match(reExprIdent)
+		if name == "" {
+			return Expr{}, p.errorf(p.i, "expected column, found %s", p.describe())
+		}
+		names = append(names, name)
+	}
+	column := newColumn(names)
+	asText := false
+	for strings.HasPrefix(p.clause[p.i:p.end], JSON_SEP) {
+		if asText {
+			return Expr{}, p.errorf(p.i, "%s must be the last step of a json path", JSON_TEXT_SEP)
+		}
+		arrow := JSON_SEP
+		if strings.HasPrefix(p.clause[p.i:p.end], JSON_TEXT_SEP) {
+			arrow = JSON_TEXT_SEP
+		}
+		p.i += len(arrow)
+		key := p.match(reExprIdent)
+		if key == "" {
+			return Expr{}, p.errorf(p.i, "expected json key, found %s", p.describe())
+		}
+		asText = arrow == JSON_TEXT_SEP
+		column.Path = append(column.Path, key)
+		column.PathAsText = asText
+	}
+	return Expr{Kind: EXPR_COLUMN, Column: column}, nil
+}
@@ -0,0 +1,147 @@
+package rsql
+
+import (
+	"cmp"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopgrest/repatterns"
+)
+
+type tokenKind int
+
+const (
+	tokEOF       tokenKind = iota
+	tokText                // run of characters that aren't punctuation, e.g. `surname` or `1800`
+	tokQuoted              // quoted value, e.g. `"Carson, Anne"`
+	tokOperator            // comparison operator, e.g. `==`, `<` or `=fts(english)=`
+	tokSemicolon           // `;` between conditions or joins
+	tokComma               // `,` between columns or values
+	tokColon               // `:` before an alias, direction or join condition
+	tokDot                 // `.` between a qualifier and column
+	tokCast                // `::` before a cast
+	tokArrow               // `->` before a json key
+	tokTextArrow           // `->>` before a json key extracted as text
+	tokAssign              // `=` that isn't part of an operator
+	tokLParen              // `(` opening a group or list in a filter
+	tokRParen              // `)` closing a group or list in a filter
+)
+
+// token is a lexeme of a clause, at byte offset pos
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators are the keys of OperatorToSQLMap, longest first so that e.g. `<=`
+// is lexed before `<`
+var operators = slices.SortedFunc(maps.Keys(OperatorToSQLMap), func(a, b string) int {
+	return cmp.Or(len(b)-len(a), strings.Compare(a, b))
+})
+
+// reFTSOperator matches a full-text search operator with a text search
+// configuration at the start of a string, e.g. `=fts(english)=`
+var reFTSOperator = regexp.MustCompile(`^(=[a-z]+)\((` + repatterns.Ident + `)\)=`)
+
+// lex splits a clause into tokens, starting at byte offset start. A quoted
+// value is a single token, so the separators in it aren't lexed. Parentheses
+// are only lexed in a standard RSQL filter, where a quote after `(` also
+// begins a quoted value
+func lex(clause string, start int, filter bool) ([]token, error) {
+	tokens := []token{}
+	emit := func(kind tokenKind, pos, end int) {
+		tokens = append(tokens, token{kind: kind, text: clause[pos:end], pos: pos})
+	}
+	for i := start; i < len(clause); {
+		switch {
+		case isQuoteStart(clause, i) || (filter && isQuoteAfterParen(clause, i)):
+			end, err := quoteEnd(clause, i)
+			if err != nil {
+				return nil, &ParseError{Clause: clause, Offset: i, Msg: "unterminated quoted value"}
+			}
+			emit(tokQuoted, i, end+1)
+			i = end + 1
+		case strings.HasPrefix(clause[i:], CAST_SEP):
+			emit(tokCast, i, i+len(CAST_SEP))
+			i += len(CAST_SEP)
+		case strings.HasPrefix(clause[i:], JSON_TEXT_SEP):
+			emit(tokTextArrow, i, i+len(JSON_TEXT_SEP))
+			i += len(JSON_TEXT_SEP)
+		case strings.HasPrefix(clause[i:], JSON_SEP):
+			emit(tokArrow, i, i+len(JSON_SEP))
+			i += len(JSON_SEP)
+		case clause[i] == ';':
+			emit(tokSemicolon, i, i+1)
+			i++
+		case clause[i] == ',':
+			emit(tokComma, i, i+1)
+			i++
+		case clause[i] == ':':
+			emit(tokColon, i, i+1)
+			i++
+		case clause[i] == '.':
+			emit(tokDot, i, i+1)
+			i++
+		case filter && clause[i] == '(':
+			emit(tokLParen, i, i+1)
+			i++
+		case filter && clause[i] == ')':
+			emit(tokRParen, i, i+1)
+			i++
+		default:
+			if n := operatorLen(clause[i:]); n > 0 {
+				emit(tokOperator, i, i+n)
+				i += n
+				continue
+			}
+			if clause[i] == '=' {
+				emit(tokAssign, i, i+1)
+				i++
+				continue
+			}
+			end := i + 1
+			for end < len(clause) && !isTokenStart(clause, end, filter) {
+				end++
+			}
+			emit(tokText, i, end)
+			i = end
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, pos: len(clause)})
+	return tokens, nil
+}
+
+// operatorLen returns the length of the operator at the start of s, or 0 if
+// s doesn't start with an operator
+func operatorLen(s string) int {
+	if m := reFTSOperator.FindStringSubmatch(s); m != nil {
+		if _, ok := FTSFunctions[m[1]+"="]; ok {
+			return len(m[0])
+		}
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return len(op)
+		}
+	}
+	return 0
+}
+
+// isTokenStart reports whether a token other than text begins at i, which
+// ends a run of text
+func isTokenStart(s string, i int, filter bool) bool {
+	return strings.IndexByte(";,:.=<>", s[i]) >= 0 ||
+		(filter && (s[i] == '(' || s[i] == ')')) ||
+		strings.HasPrefix(s[i:], "!=") ||
+		strings.HasPrefix(s[i:], JSON_SEP) ||
+		isQuoteStart(s, i)
+}
+
+// isQuoteAfterParen reports whether the character at i is a quote that
+// begins the first value of a list in a filter, e.g. `=in=("a b",c)`
+func isQuoteAfterParen(s string, i int) bool {
+	return i > 0 && s[i-1] == '(' && (s[i] == '"' || s[i] == '\'')
+}
@@ -0,0 +1,194 @@
+package rsql
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// structuralChars are the characters after which a `"` or `'` begins a
+// quoted value, which may contain separator characters, e.g.
+// `where=title=="Autobiography; of Red"`. Within quotes, `\` escapes the next
+// character, e.g. `"say \"hi\""`. Quotes elsewhere are kept as they are, so
+// `surname==O'Brien` needs no quotes
+const structuralChars = "=,;:"
+
+// Unescape decodes a percent-encoded query parameter, where `+` is a space. A
+// `%` that doesn't begin an escape is kept, e.g. in the unencoded LIKE pattern
+// `title=like=18%`
+func Unescape(s string) string {
+	if decoded, err := url.QueryUnescape(s); err == nil {
+		return decoded
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '+':
+			b.WriteByte(' ')
+		case s[i] == '%' && i+2 < len(s):
+			if c, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Quote quotes a value so that it is parsed as is, whatever characters it
+// contains, e.g. `"a,b"` for `a,b`
+func Quote(val string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(val) + `"`
+}
+
+// SplitValues splits a `,` separated list of values, unquoting each value,
+// e.g. `Ann,"Carson, Anne"` into `Ann` and `Carson, Anne`
+func SplitValues(s string) ([]string, error) {
+	parts, err := splitUnquoted(s, VALUES_LIST_SEP)
+	if err != nil {
+		return nil, err
+	}
+	values := []string{}
+	for _, part := range parts {
+		val, err := unquote(part)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+	return values, nil
+}
+
+// splitUnquoted splits s at each sep that isn't inside a quoted value
+func splitUnquoted(s, sep string) ([]string, error) {
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if isQuoteStart(s, i) {
+			end, err := quoteEnd(s, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			continue
+		}
+		if strings.HasPrefix(s[i:], sep) {
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	return append(parts, s[start:]), nil
+}
+
+// unquote removes the quotes and escapes from a quoted value. Values that
+// aren't quoted are returned as they are
+func unquote(val string) (string, error) {
+	if !isQuoteStart(val, 0) {
+		return val, nil
+	}
+	end, err := quoteEnd(val, 0)
+	if err != nil {
+		return "", err
+	}
+	if end != len(val)-1 {
+		return "", fmt.Errorf("unexpected %s after quoted value in %s", val[end+1:], val)
+	}
+	var b strings.Builder
+	for i := 1; i < end; i++ {
+		if val[i] == '\\' {
+			i++
+		}
+		b.WriteByte(val[i])
+	}
+	return b.String(), nil
+}
+
+// isQuoteStart reports whether the character at i begins a quoted value
+func isQuoteStart(s string, i int) bool {
+	if s[i] != '"' && s[i] != '\'' {
+		return false
+	}
+	return i == 0 || strings.IndexByte(structuralChars, s[i-1]) >= 0
+}
+
+// quoteEnd returns the index of the quote that closes the quoted value
+// beginning at start
+func quoteEnd(s string, start int) (int, error) {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case s[start]:
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated quoted value %s", s[start:])
+}
+
+// quoteValue quotes a value that wouldn't otherwise be parsed as it is, e.g.
+// one containing a separator or operator
+func quoteValue(val string) string {
+	for i := range len(val) {
+		if isQuoteStart(val, i) || strings.IndexByte(";,", val[i]) >= 0 || operatorLen(val[i:]) > 0 {
+			return Quote(val)
+		}
+	}
+	return val
+}
+
+// quoteFilterValue quotes a value in a filter that wouldn't otherwise be
+// parsed as it is, which also includes one containing a parenthesis
+func quoteFilterValue(val string) string {
+	if strings.ContainsAny(val, "()") {
+		return Quote(val)
+	}
+	return quoteValue(val)
+}
+
+// quoteSubquery quotes the value of a subquery operator that would otherwise
+// end early, at a `;` between its conditions or, in a filter, a `,` or
+// parenthesis
+func quoteSubquery(val string, filter bool) string {
+	special := ";"
+	if filter {
+		special = ";,()"
+	}
+	if strings.ContainsAny(val, special) {
+		return Quote(val)
+	}
+	return val
+}
+
+// quoteAlias quotes an alias that isn't parsed as a single word, e.g.
+// `"Title: subtitle"`
+func quoteAlias(alias string) string {
+	tokens, err := lex(alias, 0, false)
+	if err == nil && len(tokens) == 2 && tokens[0].kind == tokText {
+		return alias
+	}
+	return Quote(alias)
+}
+
+// unescapeLike reverses escapeLike
+func unescapeLike(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String()
+}
+
+// escapeParam percent-encodes the characters of a query parameter that would
+// end it or be decoded, e.g. `&` and `%`
+func escapeParam(s string) string {
+	return strings.NewReplacer("%", "%25", "&", "%26", "+", "%2B", "#", "%23", " ", "%20").Replace(s)
+}
@@ -1,93 +1,60 @@
 package rsql
 
 import (
+	"errors"
 	"fmt"
-	"maps"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"gopgrest/repatterns"
 )
 
 // Separator characters in query
 var (
-	CLAUSE_ASSIGN   = "=" // assign value `name` to clause `select`: `select=name`
-	CLAUSE_SEP      = "&" // separate `select=...` and `where=...`: `select=name&where=name==bob`
-	ITEM_SEP        = ";" // separate multiple equality checks: `where=name==bob;age==42`
-	ALIAS_SEP       = ":" // separate column name and alias: `select=surname:last_name`
-	QUALIFIER_SEP   = "." // qualify column `surname` w/ table `authors`: `select=authors.surname`
-	JOIN_ON_ASSIGN  = ":" // `JOIN ON authors WHERE...`: `join=authors:books.author_id==authors.id`
-	VALUES_LIST_SEP = "," // separate list of values e.g. `select=surname,forename,died`
+	CLAUSE_ASSIGN   = "="   // assign value `name` to clause `select`: `select=name`
+	CLAUSE_SEP      = "&"   // separate `select=...` and `where=...`: `select=name&where=name==bob`
+	ITEM_SEP        = ";"   // separate multiple equality checks: `where=name==bob;age==42`
+	ALIAS_SEP       = ":"   // separate column name and alias: `select=surname:last_name`
+	QUALIFIER_SEP   = "."   // qualify column `surname` w/ table `authors`: `select=authors.surname`
+	CAST_SEP        = "::"  // cast column `born` to type `text`: `where=born::text=like=18%`
+	JSON_SEP        = "->"  // get key `tags` of json column `meta`: `select=meta->tags`
+	JSON_TEXT_SEP   = "->>" // get key `city` of json column `meta` as text: `select=meta->>city`
+	JOIN_ON_ASSIGN  = ":"   // `JOIN ON authors WHERE...`: `join=authors:books.author_id==authors.id`
+	TABLE_ALIAS_SEP = "@"   // alias joined table `authors` as `editor`: `join=authors@editor:books.editor_id==editor.id`
+	SUBQUERY_SEP    = "?"   // separate a subquery's column from its conditions: `where=id=insub=books.author_id?genre_id==3`
+	VALUES_LIST_SEP = ","   // separate list of values e.g. `select=surname,forename,died`
 )
 
-// newRSQLQuery builds a Pars from the URL
-func NewRSQLQuery(url string) (QueryParams, error) {
-	query := QueryParams{}
-	// If Limit is still -1 before executing the query, then no limit was set
-	// by user
-	query.Limit = -1
-
-	// Separate table (t) from URL query params (p)
-	pq, err := newPathQuery(url)
-	if err != nil || pq == nil {
-		return query, err
-	}
-	query.Tables = append(query.Tables, pq.Resource)
-
-	// Example URL query:
-	// /authors?where=forename=in=Ann,Anne;surname=Carson&select=forename,surname
-
-	// Parse clauses from URL query params, split at "&"
-	// e.g. "where=..." + "select=..."
-	for clause := range strings.SplitSeq(pq.Query, CLAUSE_SEP) {
-		keyword, assignment, err := parseClause(clause)
-		if err != nil {
-			return QueryParams{}, err
-		}
+// ParseError is a syntax error in a clause of a URL query, at byte offset
+// Offset of the decoded clause, e.g. `where=title=="Mrs Dalloway`
+type ParseError struct {
+	Clause string
+	Offset int
+	Msg    string
+}
 
-		var clauseErr error
-		switch keyword {
-		case WHERE: // e.g ?where=
-			// WHERE conditions are split at ";" and checked with "==" or "=in="
-			conditions, err := NewWhereConditions(assignment)
-			clauseErr = err
-			query.Conditions = conditions
-		case SELECT: // e.g. ?select=
-			// Select columns are split at ","
-			columns, err := newSelect(assignment)
-			clauseErr = err
-			query.Columns = columns
-		case JOIN:
-			fallthrough
-		case INNERJOIN:
-			fallthrough
-		case LEFTJOIN:
-			fallthrough
-		case RIGHTJOIN:
-			joins, err := newJoins(keyword, assignment)
-			clauseErr = err
-			query.Joins = joins
-			// Add all referenced tables to the query
-			for _, j := range joins {
-				query.Tables = append(query.Tables, j.Table)
-			}
-		case LIMIT:
-			var limit int
-			limit, clauseErr = strconv.Atoi(assignment)
-			query.Limit = limit
-		case OFFSET:
-			var offset int
-			offset, clauseErr = strconv.Atoi(assignment)
-			query.Offset = offset
-		}
-		if clauseErr != nil {
-			return QueryParams{}, clauseErr
-		}
+// Error shows the clause with a caret under the offending character, e.g.
+//
+//	unterminated quoted value at offset 13
+//	where=title=="Mrs Dalloway
+//	             ^
+func (e *ParseError) Error() string {
+	caret := strings.Repeat(" ", utf8.RuneCountInString(e.Clause[:e.Offset])) + "^"
+	return fmt.Sprintf("%s at offset %d\n%s\n%s", e.Msg, e.Offset, e.Clause, caret)
+}
 
+// NewRSQLQuery parses a URL into QueryParams, e.g.
+// `/authors?where=forename=in=Ann,Anne;surname=Carson&select=forename,surname`
+func NewRSQLQuery(url string) (QueryParams, error) {
+	q, err := Parse(url)
+	if err != nil {
+		return QueryParams{}, err
 	}
-	return query, nil
+	return q.Params(), nil
 }
 
 // newPathQuery parses a URL, checking for a table name and an optional query
@@ -105,188 +72,861 @@ func newPathQuery(url string) (*PathQuery, error) {
 	return nil, fmt.Errorf("could not parse url %s", url)
 }
 
-// parseClause validates the lhs and rhs of a clause string, e.g.
-// `select=forename,surname` should split into two substrings at the `=` char
-// and the keyword (lhs) should be an implemented clause keyword
-func parseClause(clauseStr string) (string, string, error) {
-	// Split clause at assignment '=' char, not equality '==' chars
-	clause := strings.SplitN(clauseStr, CLAUSE_ASSIGN, 2)
-	// Clause requires values on left and right of assignment
-	if len(clause) != 2 {
-		return "", "", fmt.Errorf("Malformed clause: %s\n", clauseStr)
+// parseClause decodes a clause string, e.g. `select=forename,surname`, whose
+// keyword before the first `=` must be an implemented clause keyword, and
+// returns a parser for its value
+func parseClause(clauseStr string) (string, *parser, error) {
+	key, value, ok := strings.Cut(clauseStr, CLAUSE_ASSIGN)
+	keyword := Unescape(key)
+	clause := keyword + CLAUSE_ASSIGN + Unescape(value)
+	if !ok {
+		return "", nil, &ParseError{Clause: keyword, Offset: len(keyword), Msg: "expected " + CLAUSE_ASSIGN}
 	}
-	// Keyword must be one in a list of implemented clauses
-	keyword := clause[0]
 	if !slices.Contains(VALIDKEYWORDS, keyword) {
-		return "", "", fmt.Errorf("Invalid clause keyword '%s'\n", keyword)
+		return "", nil, &ParseError{Clause: clause, Msg: fmt.Sprintf("invalid clause keyword %q", keyword)}
+	}
+	start := len(keyword) + len(CLAUSE_ASSIGN)
+	// Joins were once matched anywhere in their clause's value, so a second
+	// `=` after a join keyword is still accepted, e.g. `join==genres:...`
+	if _, isJoin := JoinTypes[keyword]; isJoin && strings.HasPrefix(clause[start:], CLAUSE_ASSIGN) {
+		start += len(CLAUSE_ASSIGN)
 	}
-	values := clause[1]
-	return keyword, values, nil
+	if keyword == SELECT {
+		// A select clause is lexed one column at a time by parseSelect
+		return keyword, &parser{clause: clause, tokens: []token{{kind: tokEOF, pos: start}}}, nil
+	}
+	p, err := newParser(clause, start, keyword == FILTER)
+	return keyword, p, err
 }
 
 // NewWhereConditions makes a rsql.Conditions value from the rhs of a URL 'WHERE' query param
 // e.g. the rhs of `where=forename=in=Anne,Ann;surname=Carson`
 func NewWhereConditions(whereConditions string) ([]Condition, error) {
+	p, err := newParser(whereConditions, 0, false)
+	if err != nil {
+		return []Condition{}, err
+	}
+	conditions, err := p.parseConditions()
+	if err != nil {
+		return []Condition{}, err
+	}
+	return conditions, nil
+}
+
+// NewWriteQuery parses the URL of a PUT or DELETE request into QueryParams. Its
+// query is the rhs of a 'WHERE' query param without the `where` keyword,
+// optionally with join clauses that restrict the rows to change, e.g.
+// `/books?authors.born<1900&join=authors:books.author_id==authors.id`
+func NewWriteQuery(url string) (QueryParams, error) {
+	query := QueryParams{Limit: -1}
+	pq, err := newPathQuery(url)
+	if err != nil || pq == nil {
+		return query, err
+	}
+	query.Tables = append(query.Tables, pq.Resource)
+
+	// Clauses that aren't joins are the conditions, as they were before
+	// joins were supported
+	conditions := []string{}
+	for clause := range strings.SplitSeq(pq.Query, CLAUSE_SEP) {
+		key, _, _ := strings.Cut(clause, CLAUSE_ASSIGN)
+		keyword := Unescape(key)
+		if _, ok := JoinTypes[keyword]; !ok {
+			conditions = append(conditions, clause)
+			continue
+		}
+		_, p, err := parseClause(clause)
+		if err != nil {
+			return QueryParams{}, err
+		}
+		joins, err := p.parseJoins(keyword)
+		if err != nil {
+			return QueryParams{}, err
+		}
+		query.Joins = append(query.Joins, joins...)
+		for _, j := range joins {
+			query.Tables = append(query.Tables, j.Table)
+		}
+	}
+	if len(conditions) > 0 {
+		query.Conditions, err = NewWhereConditions(Unescape(strings.Join(conditions, CLAUSE_SEP)))
+		if err != nil {
+			return QueryParams{}, err
+		}
+	}
+	return query, nil
+}
+
+// parser is a recursive-descent parser of the tokens of a clause. A filter
+// clause is parsed as standard RSQL
+type parser struct {
+	clause string
+	tokens []token
+	i      int
+	filter bool
+}
+
+// newParser lexes a clause from byte offset start, e.g. after the `where=` of
+// `where=surname==Carson`, which is kept so that errors show the whole clause
+func newParser(clause string, start int, filter bool) (*parser, error) {
+	tokens, err := lex(clause, start, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{clause: clause, tokens: tokens, filter: filter}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// at reports whether the next token is one of kinds
+func (p *parser) at(kinds ...tokenKind) bool {
+	return slices.Contains(kinds, p.peek().kind)
+}
+
+// accept consumes the next token if it is of kind
+func (p *parser) accept(kind tokenKind) bool {
+	if p.at(kind) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expect consumes the next token, which must be of kind, and otherwise
+// reports that what was expected
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	if !p.at(kind) {
+		return token{}, p.errorf(p.peek().pos, "expected %s, found %s", what, p.describe(p.peek()))
+	}
+	return p.next(), nil
+}
+
+// expectEnd checks that the whole clause was parsed
+func (p *parser) expectEnd() error {
+	if t := p.peek(); t.kind != tokEOF {
+		return p.errorf(t.pos, "unexpected %s", p.describe(t))
+	}
+	return nil
+}
+
+func (p *parser) errorf(pos int, format string, args ...any) error {
+	return &ParseError{Clause: p.clause, Offset: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) describe(t token) string {
+	if t.kind == tokEOF {
+		return "end of clause"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// parseConditions parses `;` separated conditions, e.g.
+// `forename=in=Ann,Anne;surname==Carson`
+func (p *parser) parseConditions() ([]Condition, error) {
 	conditions := []Condition{}
-	// Multiple conditions allowed with ; separator
-	for cond := range strings.SplitSeq(whereConditions, ITEM_SEP) {
-		f, err := newCondition(cond)
+	for {
+		cond, err := p.parseCondition()
 		if err != nil {
-			return []Condition{}, err
+			return nil, err
+		}
+		conditions = append(conditions, cond)
+		if !p.accept(tokSemicolon) {
+			break
 		}
-		conditions = append(conditions, *f)
 	}
-	return conditions, nil
+	return conditions, p.expectEnd()
 }
 
-// newCondition creates a new Condition from an item in a ';' separated string of
-// `{col}=[values]` WHERE conditions
-func newCondition(cond string) (*Condition, error) {
-	// Split condition at "==" or "=in=" or "=out=" etc.
-	ReConditionOperator := getOperatorSplitRegex()
-	splitCondition := ReConditionOperator.Split(cond, -1)
-	if len(splitCondition) != 2 {
-		return nil, fmt.Errorf("Malformed WHERE clause in url: %s\n", cond)
-	}
-	operator := ReConditionOperator.FindString(cond)
-	conditionCol := splitCondition[0]
-	conditionVals := strings.Split(splitCondition[1], VALUES_LIST_SEP)
-
-	// Build column
-	column := Column{}
-	qualifiedCol := strings.Split(splitCondition[0], QUALIFIER_SEP)
-	// Add qualifier if the column was qualified with a table, e.g.
-	// `authors.forename`
-	if len(qualifiedCol) == 2 {
-		column.Qualifier = qualifiedCol[0]
-		column.Name = qualifiedCol[1]
-	} else {
-		column.Name = splitCondition[0]
+// parseCondition parses a column, an optional cast, an operator and its
+// values, e.g. `born::text=like=18%`
+func (p *parser) parseCondition() (Condition, error) {
+	column, err := p.parseColumn()
+	if err != nil {
+		return Condition{}, err
+	}
+
+	// An explicit cast of the column, e.g. `born::text`, runs up to the
+	// operator, as a type may contain spaces or commas, e.g. `numeric(4,2)`
+	cast := ""
+	if p.at(tokCast) {
+		start := p.next().pos + len(CAST_SEP)
+		for !p.at(tokOperator, tokSemicolon, tokEOF) {
+			p.next()
+		}
+		cast = p.clause[start:p.peek().pos]
+		if cast == "" {
+			return Condition{}, p.errorf(start, "expected type after %s", CAST_SEP)
+		}
+	}
+
+	opToken, err := p.expect(tokOperator, "operator")
+	if err != nil {
+		return Condition{}, err
 	}
+	valuesPos := opToken.pos + len(opToken.text)
 
-	nullCheck := hasNullCheck(operator)
-	// operator may be empty string and Split always returns array len 1
-	// so handle case of no values, except for isnull/isnotnull
-	if !nullCheck && len(conditionVals) == 1 && conditionVals[0] == "" {
-		return nil, fmt.Errorf("Condition on col %s with no values", conditionCol)
+	// Split a text search configuration from a full-text search operator,
+	// e.g. `=fts(english)=`
+	operator := opToken.text
+	var textSearch *TextSearch
+	if m := reFTSOperator.FindStringSubmatch(operator); m != nil {
+		operator = m[1] + "="
+		textSearch = &TextSearch{Func: FTSFunctions[operator], Config: m[2]}
+	} else if fn, ok := FTSFunctions[operator]; ok {
+		textSearch = &TextSearch{Func: fn}
+	}
+	sqlOperator := OperatorToSQLMap[operator]
+
+	// A subquery operator's value is a query on another table, e.g.
+	// `id=insub=books.author_id?genre_id==3`
+	if slices.Contains(SubqueryOperators, operator) {
+		subquery, err := p.parseSubquery()
+		if err != nil {
+			return Condition{}, err
+		}
+		return Condition{
+			Column:      column,
+			Values:      []string{subquery.String()},
+			Operator:    operator,
+			SQLOperator: sqlOperator,
+			Cast:        strings.ToLower(cast),
+			Subquery:    subquery,
+		}, nil
+	}
+
+	var values []string
+	if p.filter {
+		values, err = p.parseArguments(operator)
+		if err != nil {
+			return Condition{}, err
+		}
+	} else if isSingleValue(operator) {
+		val, err := p.parseValue(false)
+		if err != nil {
+			return Condition{}, err
+		}
+		values = []string{val}
+	} else {
+		values, err = p.parseValues()
+		if err != nil {
+			return Condition{}, err
+		}
 	}
+
 	// null check conditions should not have any rhs values, e.g.
 	// `where=born=isnull=` is valid
 	// `where=born=isnull=1800` is not valid
-	if nullCheck && len(conditionVals) >= 1 && conditionVals[0] != "" {
-		return nil, fmt.Errorf("cannot add values to null check conditions")
+	noValue := len(values) == 1 && values[0] == ""
+	nullCheck := sqlOperator == "IS NULL" || sqlOperator == "IS NOT NULL"
+	if nullCheck && !noValue {
+		return Condition{}, p.errorf(valuesPos, "%s takes no values", operator)
+	}
+	if !nullCheck && noValue {
+		return Condition{}, p.errorf(valuesPos, "expected value after %s", opToken.text)
+	}
+
+	// Ranges have a lower and upper bound, e.g. `born=between=1800,1900`
+	if (sqlOperator == "BETWEEN" || sqlOperator == "NOT BETWEEN") && len(values) != 2 {
+		return Condition{}, p.errorf(valuesPos, "%s takes two values", operator)
 	}
 
-	// Operator must be implemented
-	validOps := slices.Collect(maps.Keys(OperatorToSQLMap))
-	if !slices.Contains(validOps, operator) {
-		return nil, fmt.Errorf("invalid operator %s on condition %s", operator, cond)
+	// Prefixes and suffixes are matched as LIKE patterns with their
+	// wildcards escaped, e.g. `=startswith=100%` is `LIKE '100\%%'`
+	switch operator {
+	case "=startswith=":
+		values[0] = escapeLike(values[0]) + "%"
+	case "=endswith=":
+		values[0] = "%" + escapeLike(values[0])
 	}
 
-	return &Condition{
+	return Condition{
 		Column:      column,
-		Values:      conditionVals,
-		SQLOperator: OperatorToSQLMap[operator],
+		Values:      values,
+		Operator:    operator,
+		SQLOperator: sqlOperator,
+		Cast:        strings.ToLower(cast),
+		TextSearch:  textSearch,
 	}, nil
 }
 
-// getOperatorSplitRegex builds a regex from the OperatorToSQLMap's keys. The
-// regex matches any of the keys in the map
-func getOperatorSplitRegex() *regexp.Regexp {
-	// Build valid operators to split at
-	opRegex := []string{}
-	for k := range OperatorToSQLMap {
-		opRegex = append(opRegex, fmt.Sprintf("(%s)", k))
+// isSingleValue reports whether an operator takes a single value, e.g. a JSON
+// document, array literal, pattern or search terms, that may contain commas
+// and operators
+func isSingleValue(operator string) bool {
+	sqlOperator := OperatorToSQLMap[operator]
+	_, textSearch := FTSFunctions[operator]
+	return textSearch ||
+		slices.Contains(JSONOperators, sqlOperator) ||
+		slices.Contains(ArrayOperators, sqlOperator) ||
+		slices.Contains(SingleValueOperators, sqlOperator)
+}
+
+// parseValues parses a `,` separated list of values, e.g. `Ann,"Carson, Anne"`
+func (p *parser) parseValues() ([]string, error) {
+	values := []string{}
+	for {
+		val, err := p.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+		if !p.accept(tokComma) {
+			return values, nil
+		}
 	}
-	return regexp.MustCompile(fmt.Sprintf("(%s)", strings.Join(opRegex, "|")))
 }
 
-func hasNullCheck(operator string) bool {
-	return slices.Contains(
-		[]string{
-			"=isnull=",
-			"=na=",
-			"=isnotnull=",
-			"=notnull",
-			"=!null=",
-		},
-		operator)
+// parseValue parses a value, which ends at `;` or, in a list, at `,`. Quoted
+// values are unquoted and other values are taken as they are written, so a
+// value in a list mustn't contain an operator
+func (p *parser) parseValue(inList bool) (string, error) {
+	atEnd := func() bool {
+		return p.at(tokSemicolon, tokEOF) || (inList && p.at(tokComma)) || (p.filter && p.at(tokRParen))
+	}
+	start := p.peek()
+	if start.kind == tokQuoted {
+		p.next()
+		if !atEnd() {
+			return "", p.errorf(p.peek().pos, "unexpected %s after quoted value", p.describe(p.peek()))
+		}
+		return unquote(start.text)
+	}
+	for !atEnd() {
+		if t := p.peek(); inList && t.kind == tokOperator {
+			return "", p.errorf(t.pos, "unexpected operator %s in value", t.text)
+		}
+		p.next()
+	}
+	return p.clause[start.pos:p.peek().pos], nil
 }
 
-// newSelect makes a rsql.Columns value from the RHS of a URL select query param
-// e.g. the rhs of `select=forename,surename`
-func newSelect(selectedColumns string) ([]Column, error) {
-	columns := []Column{}
-	for sf := range strings.SplitSeq(selectedColumns, VALUES_LIST_SEP) {
-		if sf == "" {
-			return nil, fmt.Errorf("Empty column in %s", selectedColumns)
+// parseSubquery parses the value of a subquery operator, a column of another
+// table and optional conditions on its rows after `?`, e.g.
+// `books.author_id?genre_id==3`. A value with more than one condition is
+// quoted, e.g. `"books.author_id?genre_id==3;title=like=T%"`
+func (p *parser) parseSubquery() (*Subquery, error) {
+	atEnd := func() bool {
+		return p.at(tokSemicolon, tokEOF) || (p.filter && p.at(tokComma, tokRParen))
+	}
+	start := p.peek()
+	value, offset := "", start.pos
+	if start.kind == tokQuoted {
+		p.next()
+		if !atEnd() {
+			return nil, p.errorf(p.peek().pos, "unexpected %s after quoted value", p.describe(p.peek()))
+		}
+		var err error
+		if value, err = unquote(start.text); err != nil {
+			return nil, p.errorf(start.pos, "%s", err)
+		}
+		offset++
+	} else {
+		for !atEnd() {
+			p.next()
 		}
+		value = p.clause[start.pos:p.peek().pos]
+	}
 
-		column := Column{}
+	subquery, err := newSubquery(value)
+	// Report errors at their offset in the clause rather than the value
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return nil, p.errorf(offset+parseErr.Offset, "%s", parseErr.Msg)
+	}
+	return subquery, err
+}
 
-		// Check for alias indicated by `:` e.g. `genres.name:genre`
-		alias := strings.Split(sf, ALIAS_SEP)
-		if len(alias) > 2 {
-			return nil, fmt.Errorf("Too many alias separators in %s", sf)
-		} else if len(alias) == 2 {
-			column.Alias = alias[1]
+// newSubquery parses a subquery, e.g. `books.author_id?genre_id==3`
+func newSubquery(value string) (*Subquery, error) {
+	target, _, hasConditions := strings.Cut(value, SUBQUERY_SEP)
+	table, column, ok := strings.Cut(target, ".")
+	if !ok || !reIdent.MatchString(table) || !reIdent.MatchString(column) {
+		return nil, &ParseError{Clause: value, Msg: fmt.Sprintf("expected table.column, found %q", target)}
+	}
+	subquery := &Subquery{Table: table, Column: column}
+	if !hasConditions {
+		return subquery, nil
+	}
+	p, err := newParser(value, len(target)+len(SUBQUERY_SEP), false)
+	if err != nil {
+		return nil, err
+	}
+	subquery.Conditions, err = p.parseConditions()
+	return subquery, err
+}
+
+// parseFilter parses a standard RSQL filter, where `;` is AND, `,` is OR, AND
+// binds tighter than OR and parentheses group expressions, e.g.
+// `surname==Woolf,(born=gt=1900;died=lt=1950)`. Each comparison is appended to
+// conditions, and referred to by its index
+func (p *parser) parseFilter(conditions *[]Condition) (*Filter, error) {
+	f, err := p.parseOr(conditions)
+	if err != nil {
+		return nil, err
+	}
+	return &f, p.expectEnd()
+}
+
+func (p *parser) parseOr(conditions *[]Condition) (Filter, error) {
+	return p.parseLogic(OR, tokComma, conditions, p.parseAnd)
+}
+
+func (p *parser) parseAnd(conditions *[]Condition) (Filter, error) {
+	return p.parseLogic(AND, tokSemicolon, conditions, p.parseConstraint)
+}
+
+// parseLogic parses operands separated by sep, combined with logic if there
+// is more than one
+func (p *parser) parseLogic(
+	logic string,
+	sep tokenKind,
+	conditions *[]Condition,
+	parseOperand func(*[]Condition) (Filter, error),
+) (Filter, error) {
+	operands := []Filter{}
+	for {
+		operand, err := parseOperand(conditions)
+		if err != nil {
+			return Filter{}, err
+		}
+		operands = append(operands, operand)
+		if !p.accept(sep) {
+			break
+		}
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return Filter{Logic: logic, Operands: operands}, nil
+}
+
+// parseConstraint parses a parenthesized group or a comparison
+func (p *parser) parseConstraint(conditions *[]Condition) (Filter, error) {
+	if p.accept(tokLParen) {
+		f, err := p.parseOr(conditions)
+		if err != nil {
+			return Filter{}, err
+		}
+		_, err = p.expect(tokRParen, ")")
+		return f, err
+	}
+	cond, err := p.parseCondition()
+	if err != nil {
+		return Filter{}, err
+	}
+	*conditions = append(*conditions, cond)
+	return Filter{Condition: len(*conditions) - 1}, nil
+}
+
+// parseArguments parses the arguments of a comparison in a filter, either a
+// single value or a parenthesized list, e.g. `=in=(Ann,Anne)`. Only `=in=`,
+// `=out=`, ranges and array operators take a list, and the elements of a
+// list for an array operator are passed as one value, as in the dialect of
+// a where clause
+func (p *parser) parseArguments(operator string) ([]string, error) {
+	if !p.at(tokLParen) {
+		val, err := p.parseValue(true)
+		return []string{val}, err
+	}
+	open := p.next()
+	values, err := p.parseValues()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokRParen, ")"); err != nil {
+		return nil, err
+	}
+
+	sqlOperator := OperatorToSQLMap[operator]
+	switch {
+	case slices.Contains(ArrayOperators, sqlOperator) && sqlOperator != "= ANY":
+		for i, val := range values {
+			values[i] = quoteValue(val)
+		}
+		return []string{strings.Join(values, VALUES_LIST_SEP)}, nil
+	case slices.Contains([]string{"IN", "NOT IN", "BETWEEN", "NOT BETWEEN"}, sqlOperator),
+		len(values) == 1:
+		return values, nil
+	}
+	return nil, p.errorf(open.pos, "%s takes one value", operator)
+}
+
+// escapeLike escapes the wildcards `%` and `_`, and the escape character `\`,
+// so that a value is matched literally in a LIKE pattern
+func escapeLike(val string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(val)
+}
+
+// parseColumn parses a column in a select, where or order_by clause, e.g.
+// `surname`, `authors.surname` or a path into a json column such as
+// `meta->tags->>0`. Keys after a column may also be separated by `.`, e.g.
+// `meta.address.city`, in which case the last key is extracted as text.
+// Without a table, `meta.address` is parsed as column `address` of table
+// `meta`, and is resolved once the query's tables are known
+func (p *parser) parseColumn() (Column, error) {
+	names := []string{}
+	for {
+		name, err := p.expect(tokText, "column")
+		if err != nil {
+			return Column{}, err
 		}
-		if slices.Contains(alias, "") {
-			return nil, fmt.Errorf("Empty column operand in %s", sf)
+		names = append(names, name.text)
+		if !p.accept(tokDot) {
+			break
 		}
+	}
+	column := newColumn(names)
 
-		// Check for column quailifier indicated by `.` e.g. `books.author_id`
-		col := strings.Split(alias[0], QUALIFIER_SEP)
-		if len(col) > 2 {
-			return nil, fmt.Errorf("Too many qualifier separators in %s", sf)
-		} else if len(col) == 2 {
-			column.Qualifier = col[0]
-			column.Name = col[1]
-		} else {
-			column.Name = col[0]
+	// Check for json keys after `->` or `->>`, where `->>` must be the last
+	// step
+	asText := false
+	for p.at(tokArrow, tokTextArrow) {
+		arrow := p.next()
+		if asText {
+			return Column{}, p.errorf(arrow.pos, "%s must be the last step of a json path", JSON_TEXT_SEP)
 		}
-		if slices.Contains(col, "") {
-			return nil, fmt.Errorf("Empty column operand in %s", sf)
+		key, err := p.expect(tokText, "json key")
+		if err != nil {
+			return Column{}, err
 		}
+		asText = arrow.kind == tokTextArrow
+		column.Path = append(column.Path, key.text)
+		column.PathAsText = asText
+	}
+	return column, nil
+}
+
+// newColumn makes a column from its `.` separated names, e.g. `authors.surname`
+// or `meta.address.city`
+func newColumn(names []string) Column {
+	column := Column{Name: names[0]}
+	if len(names) >= 2 {
+		column.Qualifier = names[0]
+		column.Name = names[1]
+		column.Path = names[2:]
+		column.PathAsText = len(names) > 2
+	}
+	return column
+}
 
+// parseSelect parses `,` separated columns with optional aliases, e.g.
+// `forename,genres.name:genre`. Aliases may be quoted, e.g.
+// `title:"Title: subtitle"`. A computed column is an expression, which must
+// have an alias, e.g. `died-born:age`. Each column is lexed as it is parsed,
+// as a quote in an expression always begins a string literal
+func (p *parser) parseSelect() ([]Column, error) {
+	columns := []Column{}
+	for start := p.peek().pos; ; {
+		end, computed := selectItemEnd(p.clause, start)
+		var column Column
+		var err error
+		if computed {
+			column, err = p.parseComputedColumn(start, end)
+		} else if err = p.lexRange(start, end); err == nil {
+			column, err = p.parseColumn()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p.accept(tokColon) {
+			alias := p.peek()
+			switch alias.kind {
+			case tokText:
+				column.Alias = alias.text
+			case tokQuoted:
+				column.Alias, _ = unquote(alias.text)
+			default:
+				return nil, p.errorf(alias.pos, "expected alias, found %s", p.describe(alias))
+			}
+			p.next()
+		}
+		if column.Expr != nil && column.Alias == "" {
+			return nil, p.errorf(p.peek().pos, "expected alias after computed column %s", column.Expr)
+		}
+		columns = append(columns, column)
+		if !p.at(tokComma) {
+			return columns, p.expectEnd()
+		}
+		start = end + len(VALUES_LIST_SEP)
+	}
+}
+
+// parseComputedColumn parses a computed column from byte offset start up to
+// end, its expression's characters rather than its tokens, e.g.
+// `surname||', '||forename:full_name`. Its alias is lexed once the expression
+// is parsed
+func (p *parser) parseComputedColumn(start, end int) (Column, error) {
+	ep := &exprParser{clause: p.clause, i: start, end: end}
+	expr, err := ep.parse()
+	if err != nil {
+		return Column{}, err
+	}
+	return Column{Expr: &expr}, p.lexRange(ep.i, end)
+}
+
+// lexRange replaces the parser's tokens with those from byte offset start up
+// to end, followed by a `,` if that is where end is
+func (p *parser) lexRange(start, end int) error {
+	tokens, err := lex(p.clause[:end], start, p.filter)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			parseErr.Clause = p.clause
+		}
+		return err
+	}
+	if end < len(p.clause) {
+		tokens = slices.Insert(tokens, len(tokens)-1, token{kind: tokComma, text: VALUES_LIST_SEP, pos: end})
+	}
+	p.tokens, p.i = tokens, 0
+	return nil
+}
+
+// parseColumns parses `,` separated columns without aliases, e.g.
+// `author_id,books.genre_id`
+func (p *parser) parseColumns() ([]Column, error) {
+	columns := []Column{}
+	for {
+		column, err := p.parseColumn()
+		if err != nil {
+			return nil, err
+		}
 		columns = append(columns, column)
+		if !p.accept(tokComma) {
+			break
+		}
+	}
+	return columns, p.expectEnd()
+}
+
+// parseOrderBy parses `,` separated columns, e.g. `born:desc,authors.surname`.
+// Columns are sorted in ascending order unless followed by `:desc`
+func (p *parser) parseOrderBy() ([]OrderBy, error) {
+	orderBy, err := p.parseOrderByList()
+	if err != nil {
+		return nil, err
+	}
+	return orderBy, p.expectEnd()
+}
 
+// parseOrderByList parses the columns of an order_by clause up to the first
+// token after them
+func (p *parser) parseOrderByList() ([]OrderBy, error) {
+	orderBy := []OrderBy{}
+	for {
+		column, err := p.parseColumn()
+		if err != nil {
+			return nil, err
+		}
+		desc := false
+		if p.accept(tokColon) {
+			direction, err := p.expect(tokText, "asc or desc")
+			if err != nil {
+				return nil, err
+			}
+			switch strings.ToLower(direction.text) {
+			case "asc":
+			case "desc":
+				desc = true
+			default:
+				return nil, p.errorf(direction.pos, "invalid order direction %q", direction.text)
+			}
+		}
+		orderBy = append(orderBy, OrderBy{Column: column, Desc: desc})
+		if !p.accept(tokComma) {
+			break
+		}
 	}
-	return columns, nil
+	return orderBy, nil
 }
 
-// newJoins makes a rsql.Joins value from the RHS of a URL join query param
-// e.g. the rhs of `join=authors:books.author_id=authors.id`
-func newJoins(joinType, joinRelations string) ([]JoinRelation, error) {
+// reIdent matches a whole table or column name
+var reIdent = regexp.MustCompile(`^` + repatterns.Ident + `$`)
+
+// parseJoins parses `;` separated joins, each a table with an optional alias
+// and `;` separated terms of its ON clause, e.g.
+// `authors@editor:books.editor_id==editor.id;editor.born>1800`. A table
+// followed by `:` begins the next join. A cross join has no ON clause, e.g.
+// `cross_join=genres;formats`
+func (p *parser) parseJoins(keyword string) ([]JoinRelation, error) {
 	jr := []JoinRelation{}
+	for {
+		j := JoinRelation{Type: JoinTypes[keyword]}
+		var err error
+		j.Table, j.Alias, err = p.parseJoinTable()
+		if err != nil {
+			return nil, err
+		}
+		if keyword == CROSSJOIN {
+			jr = append(jr, j)
+			if !p.accept(tokSemicolon) {
+				break
+			}
+			continue
+		}
+		if _, err := p.expect(tokColon, JOIN_ON_ASSIGN); err != nil {
+			return nil, err
+		}
+		for {
+			if err := p.parseJoinTerm(&j); err != nil {
+				return nil, err
+			}
+			if !p.at(tokSemicolon) || p.atJoinStart(p.i+1) {
+				break
+			}
+			p.next()
+		}
+		jr = append(jr, j)
+		if !p.accept(tokSemicolon) {
+			break
+		}
+	}
+	return jr, p.expectEnd()
+}
+
+// atJoinStart reports whether the tokens from i begin a join, i.e. a table
+// followed by `:`
+func (p *parser) atJoinStart(i int) bool {
+	return i+1 < len(p.tokens) && p.tokens[i].kind == tokText && p.tokens[i+1].kind == tokColon
+}
+
+// parseJoinTable parses a joined table and its optional alias, e.g.
+// `authors@editor`
+func (p *parser) parseJoinTable() (string, string, error) {
+	t, err := p.expect(tokText, "table")
+	if err != nil {
+		return "", "", err
+	}
+	table, alias, hasAlias := strings.Cut(t.text, TABLE_ALIAS_SEP)
+	if !reIdent.MatchString(table) {
+		return "", "", p.errorf(t.pos, "invalid table name %q", table)
+	}
+	if hasAlias && !reIdent.MatchString(alias) {
+		return "", "", p.errorf(t.pos+len(table)+len(TABLE_ALIAS_SEP), "invalid table alias %q", alias)
+	}
+	return table, alias, nil
+}
 
-	// e.g. transform "inner_join" to INNER JOIN
-	joinType = strings.ToUpper(strings.ReplaceAll(joinType, "_", " "))
-
-	// Example:
-	// GET /books?join=authors:books.author_id==authors.id;genres:books.genres_id==genres.id
-	// Note that this enforces qualified column names in a JOIN statement
-	ReJoin := regexp.MustCompile(
-		fmt.Sprintf(`(\w+)%s(\w+)\%s(\w+)==(\w+)\%s(\w+)`,
-			JOIN_ON_ASSIGN,
-			QUALIFIER_SEP,
-			QUALIFIER_SEP),
-	)
-
-	// Multiple joins allowed with ; separator
-	for join := range strings.SplitSeq(joinRelations, ITEM_SEP) {
-		if !ReJoin.MatchString(join) {
-			return []JoinRelation{}, fmt.Errorf("Malformed JOIN clause: %s", join)
-		}
-		matches := ReJoin.FindStringSubmatch(join)
-		jr = append(jr, JoinRelation{
-			Type:           strings.ToUpper(joinType),
-			Table:          matches[1],
-			LeftQualifier:  matches[2],
-			LeftCol:        matches[3],
-			RightQualifier: matches[4],
-			RightCol:       matches[5],
-		})
-	}
-	return jr, nil
+// parseJoinTerm parses a term of a join's ON clause: either the equality of
+// two qualified columns, e.g. `books.author_id==authors.id`, or else a
+// condition with values, e.g. `authors.born>1800`. A lateral join's terms
+// can also be its order and number of rows, e.g. `order_by=id:desc;limit=3`
+func (p *parser) parseJoinTerm(j *JoinRelation) error {
+	if t := p.peek(); t.kind == tokText && p.tokens[p.i+1].kind == tokAssign {
+		if (t.text == ORDERBY || t.text == LIMIT) && !j.IsLateral() {
+			return p.errorf(t.pos, "%s is only supported in a lateral join", t.text)
+		}
+		var err error
+		switch t.text {
+		case ORDERBY:
+			p.i += 2
+			j.OrderBy, err = p.parseOrderByList()
+			return err
+		case LIMIT:
+			p.i += 2
+			pos := p.peek().pos
+			if j.Limit, err = p.parseNumber(); err == nil && j.Limit <= 0 {
+				err = p.errorf(pos, "limit of a lateral join must be positive")
+			}
+			return err
+		}
+	}
+
+	start := p.i
+	left, err := p.parseColumn()
+	if err != nil {
+		return err
+	}
+	if p.peek().text == "==" {
+		p.next()
+		if right, ok := p.parseJoinColumn(); ok && p.at(tokSemicolon, tokEOF) {
+			if left.Qualifier == "" {
+				return p.errorf(p.tokens[start].pos, "expected qualified column, found %q", left.Name)
+			}
+			j.On = append(j.On, JoinOn{Left: left, Right: right})
+			return nil
+		}
+	}
+
+	p.i = start
+	cond, err := p.parseCondition()
+	if err != nil {
+		return err
+	}
+	j.Conditions = append(j.Conditions, cond)
+	return nil
+}
+
+// parseJoinColumn parses the qualified column on the right of an equality in
+// a join, e.g. `authors.id`, and reports whether there is one. A qualifier
+// beginning with a digit is a number instead, e.g. `1.5`
+func (p *parser) parseJoinColumn() (Column, bool) {
+	if p.i+2 >= len(p.tokens) {
+		return Column{}, false
+	}
+	qualifier, dot, name := p.tokens[p.i], p.tokens[p.i+1], p.tokens[p.i+2]
+	if qualifier.kind != tokText || dot.kind != tokDot || name.kind != tokText ||
+		!reIdent.MatchString(qualifier.text) || !reIdent.MatchString(name.text) ||
+		unicode.IsDigit([]rune(qualifier.text)[0]) {
+		return Column{}, false
+	}
+	p.i += 3
+	return Column{Qualifier: qualifier.text, Name: name.text}, true
+}
+
+// parseIdent parses a table or column name
+func (p *parser) parseIdent(what string) (string, error) {
+	t, err := p.expect(tokText, what)
+	if err != nil {
+		return "", err
+	}
+	if !reIdent.MatchString(t.text) {
+		return "", p.errorf(t.pos, "invalid %s name %q", what, t.text)
+	}
+	return t.text, nil
+}
+
+// parseInt parses the number of a limit or offset clause
+func (p *parser) parseInt() (int, error) {
+	n, err := p.parseNumber()
+	if err != nil {
+		return 0, err
+	}
+	return n, p.expectEnd()
+}
+
+// parseBool parses the value of a distinct clause, `true` or `false`
+func (p *parser) parseBool() (bool, error) {
+	t, err := p.expect(tokText, "true or false")
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(t.text)
+	if err != nil {
+		return false, p.errorf(t.pos, "expected true or false, found %q", t.text)
+	}
+	return b, p.expectEnd()
+}
+
+// parseNumber parses a whole number, e.g. the limit of a lateral join
+func (p *parser) parseNumber() (int, error) {
+	t, err := p.expect(tokText, "number")
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(t.text)
+	if err != nil {
+		return 0, p.errorf(t.pos, "expected number, found %q", t.text)
+	}
+	return n, nil
 }
@@ -0,0 +1,575 @@
+package rsql_test
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopgrest/assert"
+	"gopgrest/rsql"
+)
+
+func Test_NewRSQLQuery_QuotedValues(t *testing.T) {
+	values := []struct {
+		name string
+		val  string
+	}{
+		{"semicolon", "Autobiography; of Red"},
+		{"comma", "Carson, Anne"},
+		{"ampersand", "Pride & Prejudice"},
+		{"equals", "a==b"},
+		{"colon", "Orlando: A Biography"},
+		{"single quote", "O'Brien"},
+		{"double quote", `The "Waves"`},
+		{"backslash", `C:\books`},
+		{"space", "Mrs Dalloway"},
+		{"percent", "100%"},
+		{"plus", "C++"},
+		{"operator", "x=in=y"},
+	}
+	for _, v := range values {
+		t.Run(v.name, func(t *testing.T) {
+			query, err := rsql.NewRSQLQuery("/books?where=title==" + url.QueryEscape(rsql.Quote(v.val)) + "&limit=1")
+			assert.Try(t, err)
+			assert.IsEq(t, len(query.Conditions), 1)
+			assert.IsTrue(t, slices.Equal(query.Conditions[0].Values, []string{v.val}))
+			assert.IsEq(t, query.Limit, 1)
+		})
+		t.Run(v.name+" in list", func(t *testing.T) {
+			where := "title=in=" + rsql.Quote(v.val) + ",other;id==1"
+			query, err := rsql.NewRSQLQuery("/books?where=" + url.QueryEscape(where))
+			assert.Try(t, err)
+			assert.IsEq(t, len(query.Conditions), 2)
+			assert.IsTrue(t, slices.Equal(query.Conditions[0].Values, []string{v.val, "other"}))
+		})
+	}
+
+	t.Run("single quotes", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery(`/books?where=title=in='Carson, Anne','say "hi"'`)
+		assert.Try(t, err)
+		assert.IsTrue(t, slices.Equal(query.Conditions[0].Values, []string{"Carson, Anne", `say "hi"`}))
+	})
+
+	t.Run("unquoted apostrophe", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?where=surname==O'Brien")
+		assert.Try(t, err)
+		assert.IsTrue(t, slices.Equal(query.Conditions[0].Values, []string{"O'Brien"}))
+	})
+
+	t.Run("unencoded percent", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?where=forename=like=Ann%")
+		assert.Try(t, err)
+		assert.IsTrue(t, slices.Equal(query.Conditions[0].Values, []string{"Ann%"}))
+	})
+
+	t.Run("quoted alias", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/books?select=title:" + url.QueryEscape(rsql.Quote("Title: subtitle")))
+		assert.Try(t, err)
+		assert.IsEq(t, query.Columns[0].Alias, "Title: subtitle")
+	})
+}
+
+func Test_NewRSQLQuery_InvalidQuotes(t *testing.T) {
+	cases := []struct {
+		name string
+		url  string
+	}{
+		{"unterminated", `/books?where=title=="Mrs Dalloway`},
+		{"unterminated in list", `/books?where=title=in=a,"b`},
+		{"text after quote", `/books?where=title=="Mrs"Dalloway`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := rsql.NewRSQLQuery(c.url)
+			assert.IsTrue(t, err != nil)
+		})
+	}
+}
+
+func Test_NewRSQLQuery_Operators(t *testing.T) {
+	cases := []struct {
+		where       string
+		sqlOperator string
+		values      []string
+	}{
+		{"born<=1900", "<=", []string{"1900"}},
+		{"born>=1900", ">=", []string{"1900"}},
+		{"born<1900", "<", []string{"1900"}},
+		{"surname!=Woolf", "!=", []string{"Woolf"}},
+		{"surname=!like=W%", "NOT LIKE", []string{"W%"}},
+		{"title=like=%,%", "LIKE", []string{"%,%"}},
+		{"title=ilike=%,%", "ILIKE", []string{"%,%"}},
+		{"born=!between=1800,1900", "NOT BETWEEN", []string{"1800", "1900"}},
+		{"meta->>pages>=300", ">=", []string{"300"}},
+		{"note=match=^a{1,2}$", "~", []string{"^a{1,2}$"}},
+		{"died=isnull=", "IS NULL", []string{""}},
+	}
+	for _, c := range cases {
+		t.Run(c.where, func(t *testing.T) {
+			conditions, err := rsql.NewWhereConditions(c.where)
+			assert.Try(t, err)
+			assert.IsEq(t, conditions[0].SQLOperator, c.sqlOperator)
+			assert.IsTrue(t, slices.Equal(conditions[0].Values, c.values))
+		})
+	}
+}
+
+func Test_NewRSQLQuery_ParseErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		url    string
+		offset int
+		caret  string
+	}{
+		{"missing operator", "/books?where=title", 11, "where=title\n           ^"},
+		{"operator in value", "/books?where=title==a<b", 14, "where=title==a<b\n              ^"},
+		{"unterminated quote", `/books?where=title=="Mrs`, 13, "where=title==\"Mrs\n             ^"},
+		{"text after quote", `/books?where=title=="Mrs"x`, 18, "where=title==\"Mrs\"x\n                  ^"},
+		{"empty column", "/books?select=id,,title", 10, "select=id,,title\n          ^"},
+		{"text step before last", "/books?select=meta->>a->b", 15, "select=meta->>a->b\n               ^"},
+		{"bad direction", "/books?order_by=title:up", 15, "order_by=title:up\n               ^"},
+		{"malformed join", "/books?join=authors:books.author_id=authors.id", 28, "join=authors:books.author_id=authors.id\n                            ^"},
+		{"limit", "/books?limit=ten", 6, "limit=ten\n      ^"},
+		{"distinct", "/books?distinct=yes", 9, "distinct=yes\n         ^"},
+		{"keyword", "/books?having=title==x", 0, "having=title==x\n^"},
+		{"non-ASCII", "/books?where=título==a<b", 16, "where=título==a<b\n               ^"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := rsql.NewRSQLQuery(c.url)
+			var parseErr *rsql.ParseError
+			assert.IsTrue(t, errors.As(err, &parseErr))
+			assert.IsEq(t, parseErr.Offset, c.offset)
+			assert.IsTrue(t, strings.HasSuffix(err.Error(), c.caret))
+		})
+	}
+}
+
+func Test_NewRSQLQuery_Subqueries(t *testing.T) {
+	t.Run("insub", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?where=id=insub=books.author_id?genre_id==3;born>1800")
+		assert.Try(t, err)
+		assert.IsEq(t, len(query.Conditions), 2)
+		subquery := query.Conditions[0].Subquery
+		assert.IsEq(t, query.Conditions[0].SQLOperator, "IN")
+		assert.IsEq(t, subquery.Table, "books")
+		assert.IsEq(t, subquery.Column, "author_id")
+		assert.IsEq(t, len(subquery.Conditions), 1)
+		assert.IsEq(t, subquery.Conditions[0].Values[0], "3")
+	})
+
+	t.Run("quoted with conditions", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery(`/authors?where=id=exists="books.author_id?genre_id=in=1,2;title=like=T%25"`)
+		assert.Try(t, err)
+		subquery := query.Conditions[0].Subquery
+		assert.IsEq(t, query.Conditions[0].SQLOperator, "EXISTS")
+		assert.IsEq(t, len(subquery.Conditions), 2)
+		assert.IsTrue(t, slices.Equal(subquery.Conditions[0].Values, []string{"1", "2"}))
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery(`/genres?where=id=insub=books.genre_id?author_id=insub=authors.id?born<1900`)
+		assert.Try(t, err)
+		nested := query.Conditions[0].Subquery.Conditions[0].Subquery
+		assert.IsEq(t, nested.Table, "authors")
+		assert.IsEq(t, nested.Conditions[0].SQLOperator, "<")
+	})
+
+	t.Run("without conditions in a filter", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?filter=id=!exists=books.author_id,born>1900")
+		assert.Try(t, err)
+		assert.IsEq(t, query.Filter.Logic, rsql.OR)
+		assert.IsEq(t, len(query.Conditions[0].Subquery.Conditions), 0)
+	})
+
+	urls := []string{
+		"/authors?where=id=insub=books.author_id?genre_id==3;born>1800",
+		`/authors?where=id=exists="books.author_id?genre_id==3;title=like=T%25"`,
+		`/authors?filter=id=!exists="books.author_id?genre_id=in=1,2",born>1900`,
+	}
+	for _, u := range urls {
+		t.Run("round trip "+u, func(t *testing.T) {
+			query, err := rsql.NewRSQLQuery(u)
+			assert.Try(t, err)
+			assert.IsEq(t, query.String(), u)
+		})
+	}
+
+	invalid := []struct {
+		name   string
+		url    string
+		offset int
+	}{
+		{"no column", "/authors?where=id=insub=books", 15},
+		{"invalid condition", "/authors?where=id=insub=books.author_id?genre_id", 39},
+		{"quoted invalid condition", `/authors?where=id=insub="books.author_id?genre_id"`, 40},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := rsql.NewRSQLQuery(c.url)
+			var parseErr *rsql.ParseError
+			assert.IsTrue(t, errors.As(err, &parseErr))
+			assert.IsEq(t, parseErr.Offset, c.offset)
+		})
+	}
+}
+
+func Test_NewRSQLQuery_ComputedColumns(t *testing.T) {
+	t.Run("concatenation and arithmetic", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?select=surname||', '||forename:full_name,died-born:age")
+		assert.Try(t, err)
+		assert.IsEq(t, len(query.Columns), 2)
+		fullName := query.Columns[0].Expr
+		assert.IsEq(t, query.Columns[0].Alias, "full_name")
+		assert.IsEq(t, fullName.Op, "||")
+		assert.IsEq(t, fullName.Operands[0].Op, "||")
+		assert.IsEq(t, fullName.Operands[0].Operands[1].Kind, rsql.EXPR_STRING)
+		assert.IsEq(t, fullName.Operands[0].Operands[1].Value, ", ")
+		assert.IsEq(t, fullName.Operands[1].Column.Name, "forename")
+		assert.IsEq(t, query.Columns[1].Expr.Op, "-")
+	})
+
+	t.Run("functions and casts", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?select=id,coalesce(died,2000)-born:age,extract(year,born::date):y,lower(authors.surname):s")
+		assert.Try(t, err)
+		assert.IsTrue(t, query.Columns[0].Expr == nil)
+		coalesce := query.Columns[1].Expr.Operands[0]
+		assert.IsEq(t, coalesce.Op, "coalesce")
+		assert.IsEq(t, coalesce.Operands[1].Kind, rsql.EXPR_NUMBER)
+		extract := query.Columns[2].Expr
+		assert.IsEq(t, extract.Field, "year")
+		assert.IsEq(t, extract.Operands[0].Kind, rsql.EXPR_CAST)
+		assert.IsEq(t, extract.Operands[0].Op, "date")
+		assert.IsEq(t, query.Columns[3].Expr.Operands[0].Column.Qualifier, "authors")
+	})
+
+	t.Run("string literal with separators", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?select=surname||',':s,forename")
+		assert.Try(t, err)
+		assert.IsEq(t, len(query.Columns), 2)
+		assert.IsEq(t, query.Columns[0].Expr.Operands[1].Value, ",")
+	})
+
+	urls := []string{
+		"/authors?select=surname||',%20'||forename:full_name,died-born:age",
+		"/authors?select=-(died-born)*2:n,(-born)::text:t,a-(b-c):d,a-b-c:e,a||(b||c):f",
+		"/authors?select=date_trunc(month,born):m,upper(meta->>city)||'!':c&order_by=m",
+		"/authors?select=born%2B1:b",
+	}
+	for _, u := range urls {
+		t.Run("round trip "+u, func(t *testing.T) {
+			query, err := rsql.NewRSQLQuery(u)
+			assert.Try(t, err)
+			assert.IsEq(t, query.String(), u)
+		})
+	}
+
+	invalid := []struct {
+		name   string
+		url    string
+		offset int
+	}{
+		{"no alias", "/authors?select=died-born", 16},
+		{"unknown function", "/authors?select=foo(x):a", 7},
+		{"wrong number of arguments", "/authors?select=lower(a,b):a", 7},
+		{"invalid date field", "/authors?select=extract(yr,born):a", 15},
+		{"unterminated string", "/authors?select=a||'x:a", 10},
+		{"unclosed parenthesis", "/authors?select=(a:x", 9},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := rsql.NewRSQLQuery(c.url)
+			var parseErr *rsql.ParseError
+			assert.IsTrue(t, errors.As(err, &parseErr))
+			assert.IsEq(t, parseErr.Offset, c.offset)
+		})
+	}
+}
+
+func Test_NewRSQLQuery_WindowFunctions(t *testing.T) {
+	t.Run("partition and order", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/books?select=title,row_number()over(partition_by=author_id,books.genre_id;order_by=id:desc):n")
+		assert.Try(t, err)
+		window := query.Columns[1].Expr.Over
+		assert.IsEq(t, query.Columns[1].Expr.Op, "row_number")
+		assert.IsEq(t, len(window.PartitionBy), 2)
+		assert.IsEq(t, window.PartitionBy[1].Qualifier, "books")
+		assert.IsEq(t, window.OrderBy[0].Column.Name, "id")
+		assert.IsTrue(t, window.OrderBy[0].Desc)
+	})
+
+	t.Run("arguments and empty window", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/books?select=lag(title,1,'none')over():prev")
+		assert.Try(t, err)
+		lag := query.Columns[0].Expr
+		assert.IsEq(t, len(lag.Operands), 3)
+		assert.IsEq(t, len(lag.Over.PartitionBy), 0)
+		assert.IsEq(t, len(lag.Over.OrderBy), 0)
+	})
+
+	urls := []string{
+		"/books?select=title,row_number()over(partition_by=author_id;order_by=id:desc):n&order_by=n",
+		"/books?select=sum(id)over(order_by=id):total,id*100/sum(id)over(partition_by=author_id):share",
+	}
+	for _, u := range urls {
+		t.Run("round trip "+u, func(t *testing.T) {
+			query, err := rsql.NewRSQLQuery(u)
+			assert.Try(t, err)
+			assert.IsEq(t, query.String(), u)
+		})
+	}
+
+	invalid := []struct {
+		name   string
+		url    string
+		offset int
+	}{
+		{"no window", "/books?select=rank():r", 13},
+		{"invalid direction", "/books?select=sum(id)over(order_by=id:up):r", 31},
+		{"invalid term", "/books?select=sum(id)over(limit=1):r", 19},
+		{"repeated term", "/books?select=sum(id)over(order_by=id;order_by=id):r", 31},
+		{"no arguments", "/books?select=lag()over():r", 7},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := rsql.NewRSQLQuery(c.url)
+			var parseErr *rsql.ParseError
+			assert.IsTrue(t, errors.As(err, &parseErr))
+			assert.IsEq(t, parseErr.Offset, c.offset)
+		})
+	}
+}
+
+func Test_NewWriteQuery(t *testing.T) {
+	query, err := rsql.NewWriteQuery("/books?authors.born<1900;title=like=T%25&join=authors:books.author_id==authors.id")
+	assert.Try(t, err)
+	assert.IsEq(t, len(query.Conditions), 2)
+	assert.IsEq(t, query.Conditions[0].Column.Qualifier, "authors")
+	assert.IsEq(t, query.Conditions[1].Values[0], "T%")
+	assert.IsEq(t, len(query.Joins), 1)
+	assert.IsTrue(t, slices.Equal(query.Tables, []string{"books", "authors"}))
+
+	query, err = rsql.NewWriteQuery("/authors?forename==Anne")
+	assert.Try(t, err)
+	assert.IsEq(t, len(query.Joins), 0)
+	assert.IsEq(t, query.Conditions[0].Values[0], "Anne")
+}
+
+func Test_QueryParams_String(t *testing.T) {
+	urls := []string{
+		"/authors?where=surname==Woolf",
+		`/authors?where=forename=in=Ann,"Carson,%20Anne";born<=1900;died=isnull=&select=forename,surname:"Surname:%20full"&order_by=born:desc,surname&limit=10&offset=20`,
+		"/books?where=title=startswith=100%25;title=endswith=_x;title=wfts(english)=red%20or%20dalloway&select=meta->tags->>0,meta->publisher->>city",
+		"/books?where=price::numeric(4,2)>=9.99;note=match=\"^a{1,2}$\";title==Pride%20%26%20Prejudice",
+		"/books?join=authors:books.author_id==authors.id;genres:books.genre_id==genres.id&left_join=editions:editions.book_id==books.id",
+		"/authors?distinct=true&select=forename",
+		"/books?distinct_on=author_id,books.genre_id&order_by=author_id,books.genre_id,id:desc",
+	}
+	for _, u := range urls {
+		t.Run(u, func(t *testing.T) {
+			query, err := rsql.NewRSQLQuery(u)
+			assert.Try(t, err)
+			assert.IsEq(t, query.String(), u)
+		})
+	}
+
+	t.Run("canonical form", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?limit=1&select=surname:'last name',meta.publisher.city&where=surname=='Woolf'")
+		assert.Try(t, err)
+		assert.IsEq(t, query.String(), `/authors?where=surname==Woolf&select=surname:last%20name,meta.publisher->>city&limit=1`)
+	})
+}
+
+func Test_Parse(t *testing.T) {
+	t.Run("clauses in order", func(t *testing.T) {
+		q, err := rsql.Parse("/authors?limit=2&where=born<1900&filter=surname==Woolf,died=isnull=&where=id>1")
+		assert.Try(t, err)
+		assert.IsEq(t, q.Table, "authors")
+		assert.IsEq(t, len(q.Clauses), 4)
+		assert.IsEq(t, q.Clauses[0].Keyword, rsql.LIMIT)
+		assert.IsEq(t, q.Clauses[0].Number, 2)
+		assert.IsEq(t, q.Clauses[1].Conditions[0].Column.Name, "born")
+		assert.IsEq(t, q.Clauses[2].Filter.Logic, rsql.OR)
+		assert.IsEq(t, len(q.Clauses[2].Conditions), 2)
+		assert.IsEq(t, q.Clauses[3].String(), "where=id>1")
+	})
+
+	// The conditions of every where and filter clause are kept
+	t.Run("params", func(t *testing.T) {
+		q, err := rsql.Parse("/authors?where=born<1900&filter=surname==Woolf,died=isnull=&where=id>1")
+		assert.Try(t, err)
+		query := q.Params()
+		assert.IsEq(t, len(query.Conditions), 4)
+		assert.IsEq(t, query.Filter.Operands[0].Condition, 1)
+		assert.IsEq(t, query.Filter.Operands[1].Condition, 2)
+		assert.IsEq(t, len(query.WhereConditions()), 2)
+		assert.IsEq(t, query.Limit, -1)
+	})
+
+	urls := []string{
+		"/authors",
+		"/authors?limit=10&where=surname==Woolf&select=surname:last%20name",
+		`/authors?filter=(forename=in=("Ann,%20Anne",Emily),surname==Woolf);born=gt=1800&where=id>1&where=died=isnull=`,
+		"/books?left_join=editions:editions.book_id==books.id&join=authors:books.author_id==authors.id&distinct=true",
+	}
+	for _, u := range urls {
+		t.Run("round trip "+u, func(t *testing.T) {
+			q, err := rsql.Parse(u)
+			assert.Try(t, err)
+			assert.IsEq(t, q.String(), u)
+		})
+	}
+}
+
+func Test_NewRSQLQuery_Filter(t *testing.T) {
+	t.Run("and binds tighter than or", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?filter=surname==Woolf,born=gt=1800;died=lt=1900")
+		assert.Try(t, err)
+		assert.IsEq(t, len(query.Conditions), 3)
+		assert.IsEq(t, query.Filter.Logic, rsql.OR)
+		assert.IsEq(t, query.Filter.Operands[0].Condition, 0)
+		assert.IsEq(t, query.Filter.Operands[1].Logic, rsql.AND)
+		assert.IsEq(t, query.Filter.Operands[1].Operands[1].Condition, 2)
+	})
+
+	t.Run("groups and lists", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery(`/authors?where=id>1&filter=(forename=in=("Ann, Anne",Emily),born=between=(1800,1900));died=isnull=`)
+		assert.Try(t, err)
+		assert.IsEq(t, query.Filter.Logic, rsql.AND)
+		assert.IsEq(t, query.Filter.Operands[0].Logic, rsql.OR)
+		assert.IsTrue(t, slices.Equal(query.Conditions[1].Values, []string{"Ann, Anne", "Emily"}))
+		assert.IsTrue(t, slices.Equal(query.Conditions[2].Values, []string{"1800", "1900"}))
+		assert.IsEq(t, len(query.WhereConditions()), 1)
+		assert.IsEq(t, query.WhereConditions()[0].Column.Name, "id")
+	})
+
+	t.Run("filters of several params are ANDed", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?filter=surname==a,surname==b&filter=born==3")
+		assert.Try(t, err)
+		assert.IsEq(t, len(query.Conditions), 3)
+		assert.IsEq(t, query.Filter.Logic, rsql.AND)
+		assert.IsEq(t, query.Filter.Operands[0].Logic, rsql.OR)
+		assert.IsEq(t, query.Filter.Operands[0].Operands[1].Condition, 1)
+		assert.IsEq(t, query.Filter.Operands[1].Condition, 2)
+		assert.IsEq(t, len(query.WhereConditions()), 0)
+	})
+
+	t.Run("array list is one value", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery(`/editions?filter=formats=overlaps=(paperback,"e,book")`)
+		assert.Try(t, err)
+		assert.IsTrue(t, slices.Equal(query.Conditions[0].Values, []string{`paperback,"e,book"`}))
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		u := `/authors?where=id>1&filter=(forename=in=("Ann,%20Anne",Emily),surname==Woolf);born=gt=1800`
+		query, err := rsql.NewRSQLQuery(u)
+		assert.Try(t, err)
+		assert.IsEq(t, query.String(), u)
+	})
+
+	invalid := []struct {
+		name   string
+		url    string
+		offset int
+	}{
+		{"unclosed group", "/authors?filter=(surname==Woolf,born=gt=1800", 35},
+		{"list on comparison", "/authors?filter=born=gt=(1800,1900)", 15},
+		{"unclosed list", "/authors?filter=forename=in=(Ann,Anne", 28},
+		{"trailing or", "/authors?filter=surname==Woolf,", 22},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := rsql.NewRSQLQuery(c.url)
+			var parseErr *rsql.ParseError
+			assert.IsTrue(t, errors.As(err, &parseErr))
+			assert.IsEq(t, parseErr.Offset, c.offset)
+		})
+	}
+}
+
+func Test_NewRSQLQuery_Joins(t *testing.T) {
+	t.Run("alias and terms", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/books?join=authors@editor:books.editor_id==editor.id;editor.born>1800;authors:books.author_id==authors.id")
+		assert.Try(t, err)
+		assert.IsEq(t, len(query.Joins), 2)
+		editor := query.Joins[0]
+		assert.IsEq(t, editor.Table, "authors")
+		assert.IsEq(t, editor.Name(), "editor")
+		assert.IsEq(t, len(editor.On), 1)
+		assert.IsEq(t, editor.On[0].Right.Qualifier, "editor")
+		assert.IsEq(t, len(editor.Conditions), 1)
+		assert.IsEq(t, editor.Conditions[0].SQLOperator, ">")
+		assert.IsEq(t, query.Joins[1].Name(), "authors")
+		assert.IsTrue(t, slices.Equal(query.Tables, []string{"books", "authors", "authors"}))
+	})
+
+	t.Run("multiple columns", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/editions?join=prices:editions.book_id==prices.book_id;editions.format==prices.format;prices.currency==GBP")
+		assert.Try(t, err)
+		assert.IsEq(t, len(query.Joins[0].On), 2)
+		assert.IsEq(t, len(query.Joins[0].Conditions), 1)
+		assert.IsEq(t, query.Joins[0].Conditions[0].Values[0], "GBP")
+	})
+
+	t.Run("cross join", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?cross_join=genres;books@b")
+		assert.Try(t, err)
+		assert.IsEq(t, len(query.Joins), 2)
+		assert.IsEq(t, query.Joins[0].Type, "CROSS JOIN")
+		assert.IsEq(t, query.Joins[1].Name(), "b")
+	})
+
+	// The form accepted before joins were parsed
+	t.Run("second = after the keyword", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/books?join==genres:books.genre_id==genres.id")
+		assert.Try(t, err)
+		assert.IsEq(t, query.Joins[0].Table, "genres")
+		assert.IsEq(t, query.String(), "/books?join=genres:books.genre_id==genres.id")
+	})
+
+	t.Run("lateral top-N join", func(t *testing.T) {
+		query, err := rsql.NewRSQLQuery("/authors?left_lateral_join=books@recent:recent.author_id==authors.id;order_by=id:desc,title;limit=3")
+		assert.Try(t, err)
+		recent := query.Joins[0]
+		assert.IsEq(t, recent.Type, "LEFT JOIN LATERAL")
+		assert.IsTrue(t, recent.IsLateral())
+		assert.IsEq(t, len(recent.On), 1)
+		assert.IsEq(t, len(recent.OrderBy), 2)
+		assert.IsTrue(t, recent.OrderBy[0].Desc)
+		assert.IsEq(t, recent.Limit, 3)
+	})
+
+	urls := []string{
+		"/books?join=authors@editor:books.editor_id==editor.id;editor.born>1800;authors:books.author_id==authors.id",
+		"/books?full_join=genres:books.genre_id==genres.id&cross_join=editions;authors@a",
+		"/authors?lateral_join=books@recent:recent.author_id==authors.id;recent.title=like=T%25;order_by=id:desc;limit=3",
+	}
+	for _, u := range urls {
+		t.Run("round trip "+u, func(t *testing.T) {
+			query, err := rsql.NewRSQLQuery(u)
+			assert.Try(t, err)
+			assert.IsEq(t, query.String(), u)
+		})
+	}
+
+	invalid := []struct {
+		name   string
+		url    string
+		offset int
+	}{
+		{"unqualified column", "/books?join=authors:author_id==authors.id", 13},
+		{"invalid alias", "/books?join=authors@my-editor:books.editor_id==books.id", 13},
+		{"limit without lateral", "/authors?join=books:books.author_id==authors.id;limit=3", 39},
+		{"lateral limit of zero", "/authors?lateral_join=books:books.author_id==authors.id;limit=0", 53},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := rsql.NewRSQLQuery(c.url)
+			var parseErr *rsql.ParseError
+			assert.IsTrue(t, errors.As(err, &parseErr))
+			assert.IsEq(t, parseErr.Offset, c.offset)
+		})
+	}
+}
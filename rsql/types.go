@@ -1,6 +1,11 @@
 package rsql
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
 
 // VALIDKEYWORDS are valid clause keywords for a URL query
 var VALIDKEYWORDS = []string{
@@ -10,47 +15,134 @@ var VALIDKEYWORDS = []string{
 	INNERJOIN,
 	LEFTJOIN,
 	RIGHTJOIN,
+	FULLJOIN,
+	CROSSJOIN,
+	LATERALJOIN,
+	LEFTLATERALJOIN,
 	LIMIT,
 	OFFSET,
+	ORDERBY,
+	FILTER,
+	DISTINCT,
+	DISTINCTON,
 }
 
 const (
-	WHERE     = "where"
-	SELECT    = "select"
-	JOIN      = "join"
-	INNERJOIN = "inner_join"
-	LEFTJOIN  = "left_join"
-	RIGHTJOIN = "right_join"
-	LIMIT     = "limit"
-	OFFSET    = "offset"
+	WHERE           = "where"
+	SELECT          = "select"
+	JOIN            = "join"
+	INNERJOIN       = "inner_join"
+	LEFTJOIN        = "left_join"
+	RIGHTJOIN       = "right_join"
+	FULLJOIN        = "full_join"
+	CROSSJOIN       = "cross_join"
+	LATERALJOIN     = "lateral_join"
+	LEFTLATERALJOIN = "left_lateral_join"
+	LIMIT           = "limit"
+	OFFSET          = "offset"
+	ORDERBY         = "order_by"
+	FILTER          = "filter"
+	DISTINCT        = "distinct"
+	DISTINCTON      = "distinct_on"
+)
+
+// JoinTypes maps join keywords to their SQL join type
+var JoinTypes = map[string]string{
+	JOIN:            "JOIN",
+	INNERJOIN:       "INNER JOIN",
+	LEFTJOIN:        "LEFT JOIN",
+	RIGHTJOIN:       "RIGHT JOIN",
+	FULLJOIN:        "FULL JOIN",
+	CROSSJOIN:       "CROSS JOIN",
+	LATERALJOIN:     "JOIN LATERAL",
+	LEFTLATERALJOIN: "LEFT JOIN LATERAL",
+}
+
+// Logical operators combining the conditions of a Filter
+const (
+	AND = "AND"
+	OR  = "OR"
 )
 
 // OperatorToSQLMap is a map of RSQL operators to their SQL counterpart
 var OperatorToSQLMap = map[string]string{
-	"==":          "=",
-	"!=":          "!=",
-	"=in=":        "IN",
-	"=out=":       "NOT IN",
-	"=like=":      "LIKE",
-	"=!like=":     "NOT LIKE",
-	"=notlike=":   "NOT LIKE",
-	"=nk=":        "NOT LIKE",
-	"=isnull=":    "IS NULL",
-	"=na=":        "IS NULL",
-	"=isnotnull=": "IS NOT NULL",
-	"=notnull=":   "IS NOT NULL",
-	"=nn=":        "IS NOT NULL",
-	"=!null=":     "IS NOT NULL",
-	"=le=":        "<=",
-	"=ge=":        ">=",
-	"<=":          "<=",
-	">=":          ">=",
-	"=lt=":        "<",
-	"=gt=":        ">",
-	"<":           "<",
-	">":           ">",
+	"==":            "=",
+	"!=":            "!=",
+	"=in=":          "IN",
+	"=out=":         "NOT IN",
+	"=like=":        "LIKE",
+	"=!like=":       "NOT LIKE",
+	"=notlike=":     "NOT LIKE",
+	"=nk=":          "NOT LIKE",
+	"=ilike=":       "ILIKE",
+	"=!ilike=":      "NOT ILIKE",
+	"=startswith=":  "LIKE",
+	"=endswith=":    "LIKE",
+	"=match=":       "~",
+	"=imatch=":      "~*",
+	"=between=":     "BETWEEN",
+	"=!between=":    "NOT BETWEEN",
+	"=isdistinct=":  "IS DISTINCT FROM",
+	"=isnull=":      "IS NULL",
+	"=na=":          "IS NULL",
+	"=isnotnull=":   "IS NOT NULL",
+	"=notnull=":     "IS NOT NULL",
+	"=nn=":          "IS NOT NULL",
+	"=!null=":       "IS NOT NULL",
+	"=le=":          "<=",
+	"=ge=":          ">=",
+	"<=":            "<=",
+	">=":            ">=",
+	"=lt=":          "<",
+	"=gt=":          ">",
+	"<":             "<",
+	">":             ">",
+	"=contains=":    "@>",
+	"=containedby=": "<@",
+	"=overlaps=":    "&&",
+	"=any=":         "= ANY",
+	"=haskey=":      "?",
+	"=jsonpath=":    "@?",
+	"=fts=":         "@@",
+	"=plfts=":       "@@",
+	"=phfts=":       "@@",
+	"=wfts=":        "@@",
+	"=insub=":       "IN",
+	"=exists=":      "EXISTS",
+	"=!exists=":     "NOT EXISTS",
 }
 
+// SingleValueOperators are the SQL operators that compare with a single value
+// that isn't split into a list, such as a pattern or regular expression that
+// may contain commas
+var SingleValueOperators = []string{"LIKE", "NOT LIKE", "ILIKE", "NOT ILIKE", "~", "~*", "IS DISTINCT FROM"}
+
+// ArrayOperators are the SQL operators on array columns. `@>` is also a JSON
+// operator, depending on the column's type. Their value isn't split into a
+// list by the parser, as it may be an array literal, e.g.
+// `where=formats=overlaps={paperback,"e book"}`
+var ArrayOperators = []string{"@>", "<@", "&&", "= ANY"}
+
+// FTSFunctions maps the full-text search operators to the function that
+// parses their value as a tsquery. Their value isn't split into a list, and
+// the operator may name a text search configuration, e.g.
+// `where=title=wfts(english)=lighthouse`
+var FTSFunctions = map[string]string{
+	"=fts=":   "to_tsquery",
+	"=plfts=": "plainto_tsquery",
+	"=phfts=": "phraseto_tsquery",
+	"=wfts=":  "websearch_to_tsquery",
+}
+
+// SubqueryOperators are the RSQL operators whose value is a Subquery, e.g.
+// `where=id=insub=books.author_id?genre_id==3`
+var SubqueryOperators = []string{"=insub=", "=exists=", "=!exists="}
+
+// JSONOperators are the SQL operators that take a single jsonb value and
+// whose values aren't split into a list, e.g. the JSON document in
+// `where=meta=contains={"tags":["a","b"]}`
+var JSONOperators = []string{"@>", "?", "@?"}
+
 // PathQuery holds the parts of a RESTful GET request's URL that are translated
 // to a SQL SELECT query. In the example:
 //
@@ -66,12 +158,63 @@ type PathQuery struct {
 type QueryParams struct {
 	Tables     []string       // Tables to SELECT in either FROM or JOIN
 	Columns    []Column       // Columns to return in SELECT query
+	Distinct   bool           // Whether to return only distinct rows
+	DistinctOn []Column       // Columns for DISTINCT ON, returning the first row of each
 	Conditions []Condition    // Conditionals for WHERE clause
+	Filter     *Filter        // Expression combining some of the Conditions
 	Joins      []JoinRelation // Relations for JOIN clauses
+	OrderBy    []OrderBy      // Columns for ORDER BY clause
 	Limit      int            // LIMIT value
 	Offset     int            // OFFSET value
 }
 
+// Filter is an expression from a standard RSQL `filter` clause, e.g.
+// `filter=surname==Woolf,(born=gt=1900;died=lt=1950)`. A Filter is either the
+// query's Condition at index Condition, or the AND or OR of its Operands
+type Filter struct {
+	Logic     string
+	Operands  []Filter
+	Condition int
+}
+
+// conditionIndexes returns the indexes of the conditions in a filter
+func (f Filter) conditionIndexes() []int {
+	if f.Logic == "" {
+		return []int{f.Condition}
+	}
+	indexes := []int{}
+	for _, operand := range f.Operands {
+		indexes = append(indexes, operand.conditionIndexes()...)
+	}
+	return indexes
+}
+
+// WhereConditions returns the conditions that aren't part of the filter,
+// which all must be true
+func (q QueryParams) WhereConditions() []Condition {
+	if q.Filter == nil {
+		return q.Conditions
+	}
+	inFilter := q.Filter.conditionIndexes()
+	conditions := []Condition{}
+	for i, cond := range q.Conditions {
+		if !slices.Contains(inFilter, i) {
+			conditions = append(conditions, cond)
+		}
+	}
+	return conditions
+}
+
+// OrderBy is one of the `,` separated columns in an `order_by` clause, e.g.
+// `order_by=born:desc,surname`. Rank is set by the service when the column is
+// the `rank` pseudo-column, which orders by how well rows match the query's
+// full-text search conditions
+type OrderBy struct {
+	Column Column
+	Desc   bool
+	Rank   bool
+}
+
 // Condition is the parsed result of one of any `;` separated 'where' conditions in
 // a URL query. The example:
 //
@@ -80,53 +223,329 @@ type QueryParams struct {
 // would be parsed as two separate Condition values:
 // {Column: "forename", Values: []string{"Ann", "Anne"}, SQLOperator: "IN" }
 // {Column: "surname", Values: []string{"Carson"}, SQLOperator: "IN" }
+//
+// Cast is an optional type the column is cast to, e.g. `text` in
+// `where=born::text=like=18%`. Operator is the RSQL operator, e.g. `=in=`,
+// without a text search configuration. Args holds the Values parsed as the column's
+// type, or the Cast type, and is set once the condition is validated.
+// TextSearch is only set for full-text search conditions, and Subquery for
+// conditions with a subquery operator
 type Condition struct {
 	Column      Column
 	Values      []string
+	Operator    string
 	SQLOperator string
+	Cast        string
+	Args        []any
+	TextSearch  *TextSearch
+	Subquery    *Subquery
 }
 
+// Subquery is the value of a subquery operator: a Column of another Table and
+// optional conditions on its rows, e.g. `books.author_id?genre_id==3`. `=insub=`
+// checks that a column's value is one of the subquery's values of Column,
+// while `=exists=` checks that a row of the subquery has Column equal to the
+// column. Schema is set once the subquery is validated
+type Subquery struct {
+	Schema     string
+	Table      string
+	Column     string
+	Conditions []Condition
+}
+
+// String returns the Subquery as it would be written as a condition's value,
+// e.g. `books.author_id?genre_id==3`
+func (s Subquery) String() string {
+	target := s.Table + "." + s.Column
+	if len(s.Conditions) == 0 {
+		return target
+	}
+	return target + SUBQUERY_SEP + joinStrings(s.Conditions, ITEM_SEP)
+}
+
+// TextSearch is the tsquery of a full-text search condition. Config is an
+// optional text search configuration, e.g. `english`. ToTSVector is set by the
+// service for columns that aren't a tsvector, so they are converted with
+// to_tsvector
+type TextSearch struct {
+	Func       string
+	Config     string
+	ToTSVector bool
+}
+
+// Column is a column in a select, where or order_by clause. Path is an
+// optional path of keys or array indexes into a json or jsonb column, e.g.
+// `meta->tags->>0`. Each key is extracted as JSON (`->`), except for the last
+// key if PathAsText is set, which is extracted as text (`->>`). A computed
+// column in a select clause has an Expr instead of a name, e.g. `died-born:age`
 type Column struct {
-	Qualifier string
-	Name      string
-	Alias     string
+	Qualifier  string
+	Name       string
+	Alias      string
+	Path       []string
+	PathAsText bool
+	Expr       *Expr
 }
 
-// ToSQLString returns a string representation of the Column as it would be
-// used in a SELECT statement, e.g. "SELECT books.title AS t"
-func (c *Column) ToSQLString() string {
-	var name string
-	if c.Qualifier != "" {
-		name = fmt.Sprintf("%s.%s", c.Qualifier, c.Name)
-	} else {
-		name = c.Name
+// String returns the Column as it would be written in a URL query, e.g.
+// `books.title:t` or `meta->tags->>0`
+func (c Column) String() string {
+	name := c.Name
+	if c.Expr != nil {
+		name = c.Expr.String()
+	} else if c.Qualifier != "" {
+		name = c.Qualifier + QUALIFIER_SEP + c.Name
+	}
+	for i, key := range c.Path {
+		if c.PathAsText && i == len(c.Path)-1 {
+			name += JSON_TEXT_SEP + key
+		} else {
+			name += JSON_SEP + key
+		}
 	}
 	if c.Alias != "" {
-		return fmt.Sprintf("%s AS %s", name, c.Alias)
-	} else {
-		return name
+		return name + ALIAS_SEP + quoteAlias(c.Alias)
 	}
+	return name
+}
+
+// String returns the Condition as it would be written in a URL query, e.g.
+// `born::text=like=18%` or `surname=in="Carson, Anne",Woolf`
+func (c Condition) String() string {
+	return c.format(false)
 }
 
+// format writes a condition as in a where clause, or as in a filter, where a
+// list of values is in parentheses, e.g. `surname=in=("Carson, Anne",Woolf)`
+func (c Condition) format(filter bool) string {
+	column := c.Column
+	column.Alias = ""
+	s := column.String()
+	if c.Cast != "" {
+		s += CAST_SEP + c.Cast
+	}
+
+	operator := c.Operator
+	if operator == "" {
+		operator = rsqlOperator(c.SQLOperator)
+	}
+	values := slices.Clone(c.Values)
+	switch operator {
+	case "=startswith=":
+		values[0] = unescapeLike(strings.TrimSuffix(values[0], "%"))
+	case "=endswith=":
+		values[0] = unescapeLike(strings.TrimPrefix(values[0], "%"))
+	}
+	if c.TextSearch != nil && c.TextSearch.Config != "" {
+		operator = strings.TrimSuffix(operator, "=") + "(" + c.TextSearch.Config + ")="
+	}
+	quote := quoteValue
+	if filter {
+		quote = quoteFilterValue
+	}
+	if c.Subquery != nil {
+		quote = func(val string) string { return quoteSubquery(val, filter) }
+	}
+	for i, val := range values {
+		values[i] = quote(val)
+	}
+	if filter && len(values) > 1 {
+		return s + operator + "(" + strings.Join(values, VALUES_LIST_SEP) + ")"
+	}
+	return s + operator + strings.Join(values, VALUES_LIST_SEP)
+}
+
+// filterString returns a filter as it would be written in a filter clause,
+// with each AND or OR within another in parentheses
+func (q QueryParams) filterString(f Filter, nested bool) string {
+	if f.Logic == "" {
+		return q.Conditions[f.Condition].format(true)
+	}
+	operands := make([]string, len(f.Operands))
+	for i, operand := range f.Operands {
+		operands[i] = q.filterString(operand, true)
+	}
+	sep := ITEM_SEP
+	if f.Logic == OR {
+		sep = VALUES_LIST_SEP
+	}
+	if nested {
+		return "(" + strings.Join(operands, sep) + ")"
+	}
+	return strings.Join(operands, sep)
+}
+
+// rsqlOperator returns the shortest RSQL operator for a SQL operator, for
+// conditions that weren't parsed, e.g. `==` for `=`
+func rsqlOperator(sqlOperator string) string {
+	for _, op := range slices.Backward(operators) {
+		if OperatorToSQLMap[op] == sqlOperator {
+			return op
+		}
+	}
+	return sqlOperator
+}
+
+// String returns the OrderBy as it would be written in a URL query, e.g.
+// `born:desc`
+func (o OrderBy) String() string {
+	column := o.Column
+	column.Alias = ""
+	if o.Desc {
+		return column.String() + ALIAS_SEP + "desc"
+	}
+	return column.String()
+}
+
+// String returns the JoinRelation as it would be written in a URL query, e.g.
+// `authors@editor:books.editor_id==editor.id;editor.born>1800`
+func (j JoinRelation) String() string {
+	table := j.Table
+	if j.Alias != "" {
+		table += TABLE_ALIAS_SEP + j.Alias
+	}
+	if j.Type == JoinTypes[CROSSJOIN] {
+		return table
+	}
+	terms := []string{}
+	for _, on := range j.On {
+		terms = append(terms, on.Left.String()+"=="+on.Right.String())
+	}
+	for _, cond := range j.Conditions {
+		terms = append(terms, cond.String())
+	}
+	if len(j.OrderBy) > 0 {
+		terms = append(terms, ORDERBY+CLAUSE_ASSIGN+joinStrings(j.OrderBy, VALUES_LIST_SEP))
+	}
+	if j.Limit > 0 {
+		terms = append(terms, LIMIT+CLAUSE_ASSIGN+strconv.Itoa(j.Limit))
+	}
+	return table + JOIN_ON_ASSIGN + strings.Join(terms, ITEM_SEP)
+}
+
+// IsLateral reports whether the joined table is a subquery of the top rows
+// of Table for each row of the tables before it
+func (j JoinRelation) IsLateral() bool {
+	return strings.HasSuffix(j.Type, " LATERAL")
+}
+
+// keyword returns the keyword of the join's type, e.g. `left_join` for
+// `LEFT JOIN`
+func (j JoinRelation) keyword() string {
+	for keyword, joinType := range JoinTypes {
+		if joinType == j.Type {
+			return keyword
+		}
+	}
+	return JOIN
+}
+
+// Name returns the name a joined table is referred to by in the query, its
+// alias or else its table name
+func (j JoinRelation) Name() string {
+	if j.Alias != "" {
+		return j.Alias
+	}
+	return j.Table
+}
+
+// String returns the query as a URL with its clauses in a canonical order,
+// e.g. `/authors?where=surname=="Carson, Anne"&order_by=born:desc`, which
+// parses back to the same QueryParams
+func (q QueryParams) String() string {
+	if len(q.Tables) == 0 {
+		return ""
+	}
+	params := []string{}
+	add := func(keyword, value string) {
+		params = append(params, keyword+CLAUSE_ASSIGN+escapeParam(value))
+	}
+	if conditions := q.WhereConditions(); len(conditions) > 0 {
+		add(WHERE, joinStrings(conditions, ITEM_SEP))
+	}
+	if q.Filter != nil {
+		add(FILTER, q.filterString(*q.Filter, false))
+	}
+	if q.Distinct {
+		add(DISTINCT, "true")
+	}
+	if len(q.DistinctOn) > 0 {
+		add(DISTINCTON, joinStrings(q.DistinctOn, VALUES_LIST_SEP))
+	}
+	if len(q.Columns) > 0 {
+		add(SELECT, joinStrings(q.Columns, VALUES_LIST_SEP))
+	}
+	// Consecutive joins of the same type share a clause, keeping their order
+	for i := 0; i < len(q.Joins); {
+		end := i + 1
+		for end < len(q.Joins) && q.Joins[end].Type == q.Joins[i].Type {
+			end++
+		}
+		add(q.Joins[i].keyword(), joinStrings(q.Joins[i:end], ITEM_SEP))
+		i = end
+	}
+	if len(q.OrderBy) > 0 {
+		add(ORDERBY, joinStrings(q.OrderBy, VALUES_LIST_SEP))
+	}
+	if q.Limit >= 0 {
+		add(LIMIT, strconv.Itoa(q.Limit))
+	}
+	if q.Offset > 0 {
+		add(OFFSET, strconv.Itoa(q.Offset))
+	}
+	if len(params) == 0 {
+		return "/" + q.Tables[0]
+	}
+	return "/" + q.Tables[0] + "?" + strings.Join(params, CLAUSE_SEP)
+}
+
+func joinStrings[T fmt.Stringer](items []T, sep string) string {
+	s := make([]string, len(items))
+	for i, item := range items {
+		s[i] = item.String()
+	}
+	return strings.Join(s, sep)
+}
+
+// JoinRelation is one of the `;` separated joins in a join clause, e.g.
+// `join=authors@editor:books.editor_id==editor.id;editor.born>1800`. Table is
+// joined ON each equality of columns in On and each of its Conditions, and
+// is referred to by its Alias if it has one, e.g. to join a table twice. A
+// lateral join takes the first Limit rows of Table in OrderBy's order, or
+// every row if Limit is 0, for each row of the tables before it
 type JoinRelation struct {
-	Type           string
-	Table          string
-	LeftQualifier  string
-	LeftCol        string
-	RightQualifier string
-	RightCol       string
-}
-
-// ToSQLString returns a string representation of the JoinRelation as it would be
-// used in a SELECT statement, e.g. `JOIN authors ON books.author_id = books.id“
-func (jr *JoinRelation) ToSQLString() string {
-	return fmt.Sprintf(
-		"%s %s ON %s.%s = %s.%s",
-		jr.Type,
-		jr.Table,
-		jr.LeftQualifier,
-		jr.LeftCol,
-		jr.RightQualifier,
-		jr.RightCol,
-	)
+	Type       string
+	Table      string
+	Alias      string
+	On         []JoinOn
+	Conditions []Condition
+	OrderBy    []OrderBy
+	Limit      int
+}
+
+// JoinOn is the equality of two qualified columns in a join's ON clause, e.g.
+// `books.author_id==authors.id`
+type JoinOn struct {
+	Left  Column
+	Right Column
+}
+
+// TableRef is a table of a query and the name it is referred to by, which is
+// a join's alias or else the table's name
+type TableRef struct {
+	Name  string
+	Table string
+}
+
+// TableRefs returns the query's tables in the order they are searched for an
+// unqualified column, starting with the table in the URL's path
+func (q QueryParams) TableRefs() []TableRef {
+	refs := []TableRef{}
+	if len(q.Tables) > 0 {
+		refs = append(refs, TableRef{Name: q.Tables[0], Table: q.Tables[0]})
+	}
+	for _, j := range q.Joins {
+		refs = append(refs, TableRef{Name: j.Name(), Table: j.Table})
+	}
+	return refs
 }
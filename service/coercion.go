@@ -0,0 +1,426 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"gopgrest/apperrors"
+	"gopgrest/pgtypes"
+	"gopgrest/repository"
+	"gopgrest/rsql"
+)
+
+// castTypes maps the type names accepted in a `::type` cast to the driver's
+// name for the type. Casts to arrays add `[]`, e.g. `::int[]`
+var castTypes = map[string]string{
+	"smallint":    "INT2",
+	"int2":        "INT2",
+	"integer":     "INT4",
+	"int":         "INT4",
+	"int4":        "INT4",
+	"bigint":      "INT8",
+	"int8":        "INT8",
+	"real":        "FLOAT4",
+	"float4":      "FLOAT4",
+	"float":       "FLOAT8",
+	"float8":      "FLOAT8",
+	"numeric":     "NUMERIC",
+	"decimal":     "NUMERIC",
+	"text":        "TEXT",
+	"varchar":     "VARCHAR",
+	"boolean":     "BOOL",
+	"bool":        "BOOL",
+	"date":        "DATE",
+	"time":        "TIME",
+	"timestamp":   "TIMESTAMP",
+	"timestamptz": "TIMESTAMPTZ",
+	"uuid":        "UUID",
+	"json":        "JSON",
+	"jsonb":       "JSONB",
+}
+
+// textSearchTypes are the types that full-text search conditions can match,
+// all but tsvector being converted with to_tsvector
+var textSearchTypes = []string{"TSVECTOR", "TEXT", "VARCHAR", "BPCHAR", "JSON", "JSONB"}
+
+// Layouts accepted for date and time values, tried in order
+var (
+	dateLayouts      = []string{time.DateOnly}
+	timeLayouts      = []string{time.TimeOnly, "15:04", "15:04:05.999999"}
+	timestampLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999",
+		"2006-01-02 15:04:05.999999Z07:00",
+		"2006-01-02 15:04:05.999999Z07",
+		"2006-01-02 15:04:05.999999",
+		time.DateOnly,
+	}
+)
+
+var (
+	reNumeric = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+	reUUID    = regexp.MustCompile(`^\{?[0-9a-fA-F]{8}-?([0-9a-fA-F]{4}-?){3}[0-9a-fA-F]{12}\}?$`)
+)
+
+// coerceConditions parses each condition's values as the type of its column,
+// or the type it is cast to, and sets the parsed values as the condition's
+// Args
+func (s *Service) coerceConditions(tables []rsql.TableRef, conditions []rsql.Condition) error {
+	for i := range conditions {
+		cond := &conditions[i]
+		if cond.Subquery != nil {
+			if err := s.coerceSubquery(cond); err != nil {
+				return err
+			}
+			continue
+		}
+		if cond.Cast != "" {
+			if err := coerceCastCondition(cond); err != nil {
+				return err
+			}
+			continue
+		}
+		dbType, err := s.conditionType(tables, *cond)
+		if err != nil {
+			return err
+		}
+		if err := coerceCondition(cond, dbType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coerceSubquery parses the values of a subquery's conditions as the types of
+// their columns in the subquery's table. A subquery condition has no values
+// of its own, though its cast is normalized
+func (s *Service) coerceSubquery(cond *rsql.Condition) error {
+	if cond.Cast != "" {
+		dbType, err := normalizeCast(cond.Cast)
+		if err != nil {
+			return fmt.Errorf("%w on col %s: %s", apperrors.InvalidCast, cond.Column, err)
+		}
+		cond.Cast = sqlTypeName(dbType)
+	}
+	tables := []rsql.TableRef{{Name: cond.Subquery.Table, Table: cond.Subquery.Table}}
+	return s.coerceConditions(tables, cond.Subquery.Conditions)
+}
+
+// conditionType returns the type a condition's values are compared with: the
+// type its column is cast to, text for a path into a json column extracted
+// with `->>`, or else the column's type
+func (s *Service) conditionType(tables []rsql.TableRef, cond rsql.Condition) (string, error) {
+	if cond.Cast != "" {
+		return normalizeCast(cond.Cast)
+	}
+	col, err := s.findColumn(tables, cond.Column)
+	if err != nil {
+		return "", err
+	}
+	if cond.Column.PathAsText {
+		return "TEXT", nil
+	}
+	return col.DBType, nil
+}
+
+// coerceCastCondition parses a condition's values as the type it is cast to.
+// The cast is normalized to the driver's type name so that it can be written
+// to the SQL statement, e.g. `::int` becomes `::int4`
+func coerceCastCondition(cond *rsql.Condition) error {
+	dbType, err := normalizeCast(cond.Cast)
+	if err != nil {
+		return fmt.Errorf("%w on col %s: %s", apperrors.InvalidCast, cond.Column, err)
+	}
+	cond.Cast = sqlTypeName(dbType)
+	return coerceCondition(cond, dbType)
+}
+
+// coerceCondition parses a condition's values as a type and sets the parsed
+// values as the condition's Args. Array operators require an array column,
+// except for `@>` on a jsonb column. JSON operators require a jsonb column and
+// take a JSON document for `@>`, or else a key or JSONPath expression as text
+func coerceCondition(cond *rsql.Condition, dbType string) error {
+	if slices.Contains(rsql.ArrayOperators, cond.SQLOperator) {
+		if strings.HasPrefix(dbType, "_") {
+			return coerceArrayCondition(cond, dbType)
+		}
+		if cond.SQLOperator != "@>" {
+			return fmt.Errorf(
+				"%w: %s requires an array, col %s is %s",
+				apperrors.InvalidArrayOperator,
+				cond.SQLOperator,
+				cond.Column,
+				sqlTypeName(dbType),
+			)
+		}
+	}
+	if slices.Contains(rsql.JSONOperators, cond.SQLOperator) {
+		if dbType != "JSONB" {
+			return fmt.Errorf(
+				"%w: %s requires a jsonb value, col %s is %s",
+				apperrors.InvalidJSONOperator,
+				cond.SQLOperator,
+				cond.Column,
+				sqlTypeName(dbType),
+			)
+		}
+		if cond.SQLOperator != "@>" {
+			dbType = "TEXT"
+		}
+	}
+	// Full-text search matches a tsvector, or text that is converted to one,
+	// against search terms
+	if cond.TextSearch != nil {
+		if !slices.Contains(textSearchTypes, dbType) {
+			return fmt.Errorf(
+				"%w: col %s is %s, not text or tsvector",
+				apperrors.InvalidTextSearch,
+				cond.Column,
+				sqlTypeName(dbType),
+			)
+		}
+		cond.TextSearch.ToTSVector = dbType != "TSVECTOR"
+		dbType = "TEXT"
+	}
+
+	// Null checks don't have values, and patterns and regular expressions
+	// are always matched against text
+	if len(cond.Values) == 0 || slices.Contains(
+		[]string{"IS NULL", "IS NOT NULL", "LIKE", "NOT LIKE", "ILIKE", "NOT ILIKE", "~", "~*"},
+		cond.SQLOperator,
+	) {
+		return nil
+	}
+
+	args, err := parseValues(dbType, cond.Values)
+	if err != nil {
+		return fmt.Errorf(
+			"%w: col %s expects type %s: %s",
+			apperrors.InvalidConditionValue,
+			cond.Column,
+			sqlTypeName(dbType),
+			err,
+		)
+	}
+	cond.Args = args
+	return nil
+}
+
+// coerceArrayCondition parses the value of an array operator on an array
+// column. The value of `= ANY` is a single element, while the value of any
+// other operator is an array literal or `,` separated elements, which are set
+// as the condition's Values and passed as one array
+func coerceArrayCondition(cond *rsql.Condition, dbType string) error {
+	if cond.SQLOperator == "= ANY" {
+		dbType = strings.TrimPrefix(dbType, "_")
+	} else {
+		elems, err := splitArrayValue(cond.Values[0])
+		if err != nil {
+			return fmt.Errorf("%w: col %s: %s", apperrors.InvalidConditionValue, cond.Column, err)
+		}
+		cond.Values = elems
+	}
+
+	args, err := parseValues(dbType, cond.Values)
+	if err != nil {
+		return fmt.Errorf(
+			"%w: col %s expects type %s: %s",
+			apperrors.InvalidConditionValue,
+			cond.Column,
+			sqlTypeName(dbType),
+			err,
+		)
+	}
+	cond.Args = args
+	return nil
+}
+
+// splitArrayValue splits a value into an array's elements, either from an
+// array literal, e.g. `{paperback,"e book"}`, or else at `,` as a list of
+// values that may be quoted, e.g. `paperback,"e,book"`
+func splitArrayValue(val string) ([]string, error) {
+	if !strings.HasPrefix(val, "{") {
+		return rsql.SplitValues(val)
+	}
+	elems, err := pgtypes.ParseArray(val)
+	if err != nil {
+		return nil, err
+	}
+	values := []string{}
+	for _, elem := range elems {
+		s, ok := elem.(string)
+		if !ok {
+			return nil, fmt.Errorf("array %s may not have NULL elements or inner arrays", val)
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
+
+// findColumn finds a condition's column in its qualifying table, or else in
+// the first of the referenced tables that has the column
+func (s *Service) findColumn(tables []rsql.TableRef, column rsql.Column) (repository.TableColumn, error) {
+	if column.Qualifier != "" {
+		table, err := s.qualifierTable(tables, column.Qualifier)
+		if err != nil {
+			return repository.TableColumn{}, err
+		}
+		tables = []rsql.TableRef{{Name: column.Qualifier, Table: table.Name}}
+	}
+	for _, ref := range tables {
+		table, err := s.Repo.GetTable(ref.Table)
+		if err != nil {
+			return repository.TableColumn{}, err
+		}
+		if col, ok := table.GetColumn(column.Name); ok {
+			return col, nil
+		}
+	}
+	return repository.TableColumn{}, fmt.Errorf("%w (%s)", apperrors.ColDoesNotExist, column)
+}
+
+// normalizeCast returns the driver's type name for a cast type, e.g. `INT4`
+// for `integer` or `_INT4` for `integer[]`
+func normalizeCast(cast string) (string, error) {
+	elemCast, isArray := strings.CutSuffix(cast, "[]")
+	dbType, ok := castTypes[elemCast]
+	if !ok {
+		return "", fmt.Errorf("unknown type %s", cast)
+	}
+	if isArray {
+		return "_" + dbType, nil
+	}
+	return dbType, nil
+}
+
+// normalizeExprCasts normalizes the casts in the expressions of computed
+// columns to the driver's type names, e.g. `(died-born)::int` to `::int4`
+func normalizeExprCasts(columns []rsql.Column) error {
+	var normalize func(e *rsql.Expr) error
+	normalize = func(e *rsql.Expr) error {
+		if e.Kind == rsql.EXPR_CAST {
+			dbType, err := normalizeCast(e.Op)
+			if err != nil {
+				return fmt.Errorf("%w in %s: %s", apperrors.InvalidCast, e, err)
+			}
+			e.Op = sqlTypeName(dbType)
+		}
+		for i := range e.Operands {
+			if err := normalize(&e.Operands[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, c := range columns {
+		if c.Expr == nil {
+			continue
+		}
+		if err := normalize(c.Expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlTypeName returns a driver's type name as it would be written in SQL,
+// e.g. `int4` for `INT4` or `int4[]` for `_INT4`
+func sqlTypeName(dbType string) string {
+	if elemType, isArray := strings.CutPrefix(dbType, "_"); isArray {
+		return strings.ToLower(elemType) + "[]"
+	}
+	return strings.ToLower(dbType)
+}
+
+// parseValues parses condition values as a type. The values of a condition
+// on an array type are the array's elements and are passed as a single array
+func parseValues(dbType string, values []string) ([]any, error) {
+	if elemType, isArray := strings.CutPrefix(dbType, "_"); isArray {
+		elems, err := parseValues(elemType, values)
+		if err != nil {
+			return nil, err
+		}
+		return []any{pq.Array(elems)}, nil
+	}
+
+	args := []any{}
+	for _, v := range values {
+		arg, err := parseValue(dbType, v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// parseValue parses a single value as a type. Values of types without a
+// lossless Go counterpart, e.g. numeric or timestamps, are only validated
+// and passed to Postgres as strings. Values of unknown types are passed as is
+func parseValue(dbType, val string) (any, error) {
+	switch dbType {
+	case "INT2", "INT4", "INT8":
+		bitSize := map[string]int{"INT2": 16, "INT4": 32, "INT8": 64}[dbType]
+		i, err := strconv.ParseInt(val, 10, bitSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer '%s'", val)
+		}
+		return i, nil
+	case "FLOAT4", "FLOAT8":
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", val)
+		}
+		return f, nil
+	case "NUMERIC":
+		if !reNumeric.MatchString(val) && val != "NaN" {
+			return nil, fmt.Errorf("invalid number '%s'", val)
+		}
+	case "BOOL":
+		switch strings.ToLower(val) {
+		case "t", "true", "y", "yes", "on", "1":
+			return true, nil
+		case "f", "false", "n", "no", "off", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean '%s'", val)
+	case "DATE":
+		if !matchesLayout(dateLayouts, val) {
+			return nil, fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", val)
+		}
+	case "TIME":
+		if !matchesLayout(timeLayouts, val) {
+			return nil, fmt.Errorf("invalid time '%s', expected HH:MM:SS", val)
+		}
+	case "TIMESTAMP", "TIMESTAMPTZ":
+		if !matchesLayout(timestampLayouts, val) {
+			return nil, fmt.Errorf("invalid timestamp '%s', expected RFC 3339", val)
+		}
+	case "UUID":
+		if !reUUID.MatchString(val) {
+			return nil, fmt.Errorf("invalid uuid '%s'", val)
+		}
+	case "JSON", "JSONB":
+		if !json.Valid([]byte(val)) {
+			return nil, fmt.Errorf("invalid JSON '%s'", val)
+		}
+	}
+	return val, nil
+}
+
+// matchesLayout reports whether a value can be parsed with any of the layouts
+func matchesLayout(layouts []string, val string) bool {
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, val); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package service_test
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"gopgrest/apperrors"
+	"gopgrest/assert"
+	"gopgrest/rsql"
+	"gopgrest/tests"
+)
+
+func Test_CoerceConditions(t *testing.T) {
+	s := tests.NewTestService(t)
+
+	cases := []struct {
+		name    string
+		where   string
+		expArgs []any
+	}{
+		{"integer", "id=in=1,2", []any{int64(1), int64(2)}},
+		{"boolean", "in_print==true", []any{true}},
+		{"boolean shorthand", "in_print==f", []any{false}},
+		{"numeric", "price>=9.99", []any{"9.99"}},
+		{"date", "published<1950-01-01", []any{"1950-01-01"}},
+		{"timestamptz", "added>2024-01-31T00:00:00Z", []any{"2024-01-31T00:00:00Z"}},
+		{"timestamp with space", "added>2024-01-31 12:00:00", []any{"2024-01-31 12:00:00"}},
+		{"uuid", "ref==a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", []any{"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"}},
+		{"unknown type", "cover==hardcover", []any{"hardcover"}},
+		{"cast", "price::int==15", []any{int64(15)}},
+		{"between", "published=between=1900-01-01,1950-01-01", []any{"1900-01-01", "1950-01-01"}},
+		{"is distinct from", "id=isdistinct=1", []any{int64(1)}},
+		{"json path", "meta->pages==304", []any{"304"}},
+		{"json path as text", "meta->publisher->>city==London", []any{"London"}},
+		{"json path cast", "meta->>pages::int>300", []any{int64(300)}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conditions, err := s.GetChangeFilter("editions", "/editions?where="+c.where)
+			assert.Try(t, err)
+			assert.IsEq(t, len(conditions[0].Args), len(c.expArgs))
+			for i, arg := range c.expArgs {
+				assert.IsEq(t, conditions[0].Args[i], arg)
+			}
+		})
+	}
+
+	t.Run("array values are passed as one array", func(t *testing.T) {
+		conditions, err := s.GetChangeFilter("editions", "/editions?where=formats==paperback,ebook")
+		assert.Try(t, err)
+		assert.IsEq(t, len(conditions[0].Args), 1)
+	})
+
+	t.Run("array operators", func(t *testing.T) {
+		conditions, err := s.GetChangeFilter(
+			"editions",
+			`/editions?where=formats=contains=paperback,ebook;formats=overlaps={paperback,"e,book"};formats=any=e,book`,
+		)
+		assert.Try(t, err)
+		assert.IsTrue(t, slices.Equal(conditions[0].Values, []string{"paperback", "ebook"}))
+		assert.IsEq(t, len(conditions[0].Args), 1)
+		assert.IsTrue(t, slices.Equal(conditions[1].Values, []string{"paperback", "e,book"}))
+		assert.IsEq(t, len(conditions[1].Args), 1)
+		assert.IsEq(t, conditions[2].Args[0], any("e,book"))
+	})
+
+	t.Run("patterns are single values", func(t *testing.T) {
+		conditions, err := s.GetChangeFilter("editions", `/editions?where=note=startswith=10%_\,;note=imatch=^a{1,2}$`)
+		assert.Try(t, err)
+		assert.IsTrue(t, slices.Equal(conditions[0].Values, []string{`10\%\_\\,%`}))
+		assert.IsTrue(t, slices.Equal(conditions[1].Values, []string{"^a{1,2}$"}))
+		assert.IsEq(t, len(conditions[1].Args), 0)
+	})
+
+	t.Run("cast is normalized", func(t *testing.T) {
+		conditions, err := s.GetChangeFilter("editions", "/editions?where=id::TEXT=like=1%")
+		assert.Try(t, err)
+		assert.IsEq(t, conditions[0].Cast, "text")
+		// Patterns are not parsed
+		assert.IsEq(t, len(conditions[0].Args), 0)
+	})
+}
+
+func Test_CoerceConditions_Invalid(t *testing.T) {
+	s := tests.NewTestService(t)
+
+	cases := []struct {
+		name   string
+		where  string
+		expErr error
+	}{
+		{"integer", "id==one", apperrors.InvalidConditionValue},
+		{"integer out of range", "id==99999999999", apperrors.InvalidConditionValue},
+		{"boolean", "in_print==maybe", apperrors.InvalidConditionValue},
+		{"numeric", "price>=cheap", apperrors.InvalidConditionValue},
+		{"date", "published<1950-13-01", apperrors.InvalidConditionValue},
+		{"timestamptz", "added>yesterday", apperrors.InvalidConditionValue},
+		{"uuid", "ref==not-a-uuid", apperrors.InvalidConditionValue},
+		{"cast value", "price::int==15.5", apperrors.InvalidConditionValue},
+		{"unknown cast", "price::money==15", apperrors.InvalidCast},
+		{"injected cast", "price::int4 OR true==15", apperrors.InvalidCast},
+		{"json path value", "meta->publisher=={city", apperrors.InvalidConditionValue},
+		{"json path on non-json column", "price->amount==15", apperrors.InvalidJSONPath},
+		{"json operator", "meta=haskey=tags", apperrors.InvalidJSONOperator},
+		{"between", "published=between=1900-01-01,later", apperrors.InvalidConditionValue},
+		{"array operator on non-array column", "price=overlaps=1,2", apperrors.InvalidArrayOperator},
+		{"any on non-array column", "id=any=1", apperrors.InvalidArrayOperator},
+		{"array element", "formats=containedby={paperback,NULL}", apperrors.InvalidConditionValue},
+		{"json containment", "meta=contains={}", apperrors.InvalidJSONOperator},
+		{"full-text search on non-text column", "price=fts=cheap", apperrors.InvalidTextSearch},
+		{"full-text search", "search=wfts(english)=lighthouse", apperrors.InvalidTextSearch},
+		{"rank without full-text search", "id==1&order_by=rank", apperrors.RankWithoutTextSearch},
+		{"column of another table", "books.title==Orlando", apperrors.InvalidChangeFilter},
+		{"filter", "id==1&filter=id==2,id==3", apperrors.InvalidChangeFilter},
+		{"join", "id==1&join=books:editions.book_id==books.id", apperrors.InvalidChangeFilter},
+		{"select", "id==1&select=id", apperrors.InvalidChangeFilter},
+		{"order_by", "id>1&order_by=id", apperrors.InvalidChangeFilter},
+		{"limit", "id>1&limit=1", apperrors.InvalidChangeFilter},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := s.GetChangeFilter("editions", "/editions?where="+c.where)
+			assert.ErrorsIs(t, err, c.expErr)
+		})
+	}
+}
+
+func Test_CoerceConditions_JSONPath(t *testing.T) {
+	s := tests.NewTestService(t)
+
+	cases := []struct {
+		name      string
+		where     string
+		expColumn rsql.Column
+	}{
+		{"arrows", "meta->tags->>0==classic", rsql.Column{Name: "meta", Path: []string{"tags", "0"}, PathAsText: true}},
+		{"dots", "meta.publisher.city==London", rsql.Column{Name: "meta", Path: []string{"publisher", "city"}, PathAsText: true}},
+		{"dots and arrows", `meta.publisher->city=='"London"'`, rsql.Column{Name: "meta", Path: []string{"publisher", "city"}}},
+		{"qualified", "editions.meta.pages>300", rsql.Column{Qualifier: "editions", Name: "meta", Path: []string{"pages"}, PathAsText: true}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conditions, err := s.GetChangeFilter("editions", "/editions?where="+c.where)
+			assert.Try(t, err)
+			assert.IsTrue(t, reflect.DeepEqual(conditions[0].Column, c.expColumn))
+		})
+	}
+}
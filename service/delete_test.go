@@ -3,6 +3,7 @@ package service_test
 import (
 	"testing"
 
+	"gopgrest/apperrors"
 	"gopgrest/assert"
 	"gopgrest/tests"
 )
@@ -25,3 +26,42 @@ func Test_ServiceDeleteRowsByRSQL(t *testing.T) {
 	assert.Try(t, err)
 	assert.IsTrue(t, len(gotRows) == 0)
 }
+
+func Test_ServiceDeleteRowsByRSQL_Join(t *testing.T) {
+	t.Run("Delete using a joined table", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		expCount, err := tests.CountRows(service.Repo, "books", "JOIN authors ON books.author_id = authors.id WHERE born < 1900")
+		assert.Try(t, err)
+
+		url := "/books?authors.born<1900&join=authors:books.author_id==authors.id"
+		deletedIDs, err := service.DeleteRowsByRSQL("books", url)
+		assert.Try(t, err)
+		assert.IsEq(t, len(deletedIDs), expCount)
+	})
+
+	// Both books and authors have an id column, which is taken to be the
+	// books' id
+	t.Run("Unqualified column in both tables", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		url := "/books?id==1&join=authors:books.author_id==authors.id"
+		deletedIDs, err := service.DeleteRowsByRSQL("books", url)
+		assert.Try(t, err)
+		assert.IsEq(t, len(deletedIDs), 1)
+		assert.IsEq(t, deletedIDs[0], int64(1))
+	})
+
+	invalid := []struct {
+		name string
+		url  string
+	}{
+		{"left join", "/books?authors.born<1900&left_join=authors:books.author_id==authors.id"},
+		{"join not on id", "/books?authors.born<1900&join=authors:books.author_id==authors.born"},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			service := tests.NewTestService(t)
+			_, err := service.DeleteRowsByRSQL("books", c.url)
+			assert.ErrorsIs(t, err, apperrors.InvalidJoin)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopgrest/apperrors"
+	"gopgrest/pgtypes"
+	"gopgrest/repository"
+	"gopgrest/rsql"
+)
+
+// CallFunction calls a database function with named arguments that are type
+// checked against the function's signature. The optional RSQL query params
+// in `query`, e.g. `select=title&limit=2`, are only allowed on set-returning
+// functions. If readOnly is set, e.g. for a GET request, VOLATILE functions
+// are rejected.
+//
+// Set-returning functions respond with a slice of rows, functions returning a
+// single value respond with that value, and functions returning a composite
+// type respond with a single row
+func (s *Service) CallFunction(name string, args map[string]any, query string, readOnly bool) (any, error) {
+	fn, err := s.Repo.GetFunction(name)
+	if err != nil {
+		return nil, err
+	}
+	if readOnly && !fn.IsReadOnly() {
+		return nil, fmt.Errorf("%w (%s)", apperrors.FunctionIsNotReadOnly, fn.Name)
+	}
+	if err := checkFunctionArgs(fn, args); err != nil {
+		return nil, err
+	}
+
+	rsqlQuery := rsql.QueryParams{Limit: -1}
+	if query != "" {
+		if !fn.ReturnsSet {
+			return nil, fmt.Errorf(
+				"%w: %s does not return a set",
+				apperrors.InvalidFunctionRSQL,
+				fn.Name,
+			)
+		}
+		rsqlQuery, err = rsql.NewRSQLQuery(fmt.Sprintf("/%s?%s", fn.Name, query))
+		if err != nil {
+			return nil, err
+		}
+		if err := validateFunctionQuery(fn, rsqlQuery); err != nil {
+			return nil, err
+		}
+	}
+
+	encodedArgs, err := encodeFunctionArgs(fn, args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", apperrors.InvalidFunctionArgs, err)
+	}
+	rows, err := s.Repo.CallFunction(fn, encodedArgs, rsqlQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %s\n", err)
+		}
+	}()
+
+	queryResults, err := scanRows(rows, s.Decoder)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Results:", queryResults)
+
+	if fn.ReturnsSet {
+		return queryResults, nil
+	}
+	// Functions that don't return a set always return one row
+	if len(queryResults) != 1 {
+		return nil, fmt.Errorf("function %s returned %d rows", fn.Name, len(queryResults))
+	}
+	if slices.Equal(fn.Columns, []string{fn.Name}) {
+		return queryResults[0][fn.Name], nil
+	}
+	return queryResults[0], nil
+}
+
+// checkFunctionArgs checks that each argument exists in the function's
+// signature with a value of a matching type, and that every argument without
+// a default value was passed
+func checkFunctionArgs(fn *repository.Function, args map[string]any) error {
+	for name, val := range args {
+		arg, ok := fn.GetArg(name)
+		if !ok {
+			return fmt.Errorf("%w: %s has no argument %s", apperrors.InvalidFunctionArgs, fn.Name, name)
+		}
+		if err := checkArgType(arg.Type, val); err != nil {
+			return fmt.Errorf("%w: %s: %s", apperrors.InvalidFunctionArgs, name, err)
+		}
+	}
+	for _, arg := range fn.Args {
+		if _, ok := args[arg.Name]; !ok && !arg.HasDefault {
+			return fmt.Errorf("%w: missing argument %s", apperrors.InvalidFunctionArgs, arg.Name)
+		}
+	}
+	return nil
+}
+
+// checkArgType checks a value decoded from a JSON body, whose numbers are
+// json.Numbers, or a string from a URL query param, against a Postgres type.
+// Types without a JSON counterpart (dates, uuids etc.) are passed as strings
+// and parsed by Postgres
+func checkArgType(pgType string, val any) error {
+	if val == nil {
+		return nil
+	}
+	str, isStr := val.(string)
+	if num, ok := val.(json.Number); ok {
+		str, isStr = num.String(), true
+	}
+
+	if strings.HasSuffix(pgType, "[]") {
+		// An array literal from a URL query param, e.g. `{1,2,3}`
+		if _, ok := val.([]any); ok || isStr {
+			return nil
+		}
+		return fmt.Errorf("expected array for type %s, got %v", pgType, val)
+	}
+
+	switch pgType {
+	case "smallint", "integer", "bigint":
+		if f, ok := val.(float64); ok && f == math.Trunc(f) {
+			return nil
+		}
+		// Whole JSON numbers may be written with a fraction or exponent
+		if num, ok := val.(json.Number); ok {
+			if f, err := num.Float64(); err == nil && f == math.Trunc(f) {
+				return nil
+			}
+		}
+		if _, err := strconv.ParseInt(str, 10, 64); isStr && err == nil {
+			return nil
+		}
+		return fmt.Errorf("expected integer for type %s, got %v", pgType, val)
+	case "numeric", "real", "double precision":
+		if _, ok := val.(float64); ok {
+			return nil
+		}
+		if _, err := strconv.ParseFloat(str, 64); isStr && err == nil {
+			return nil
+		}
+		return fmt.Errorf("expected number for type %s, got %v", pgType, val)
+	case "boolean":
+		if _, ok := val.(bool); ok {
+			return nil
+		}
+		if _, err := strconv.ParseBool(str); isStr && err == nil {
+			return nil
+		}
+		return fmt.Errorf("expected boolean for type %s, got %v", pgType, val)
+	case "json", "jsonb":
+		return nil
+	default:
+		if !isStr {
+			return fmt.Errorf("expected string for type %s, got %v", pgType, val)
+		}
+		return nil
+	}
+}
+
+// encodeFunctionArgs encodes the JSON arrays, objects and numbers of
+// arguments as their Postgres types with pgtypes.Encode, so that numbers keep
+// their precision. Strings are passed as they are for Postgres to parse
+func encodeFunctionArgs(fn *repository.Function, args map[string]any) (map[string]any, error) {
+	encoded := map[string]any{}
+	for name, val := range args {
+		arg, _ := fn.GetArg(name)
+		switch val.(type) {
+		case []any, map[string]any, json.Number:
+			// Types that aren't casts, e.g. `double precision`, are encoded
+			// as text
+			dbType, _ := normalizeCast(arg.Type)
+			v, err := pgtypes.Encode(dbType, val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			encoded[name] = v
+		default:
+			encoded[name] = val
+		}
+	}
+	return encoded, nil
+}
+
+// validateFunctionQuery checks RSQL query params on a function's result.
+// Joins and subqueries are not supported and each column must be one of the function's
+// result columns, optionally qualified with the function's name. The types of
+// result columns aren't known, so only values of conditions with an explicit
+// cast are parsed
+func validateFunctionQuery(fn *repository.Function, query rsql.QueryParams) error {
+	if len(query.Joins) > 0 {
+		return fmt.Errorf("%w: joins are not supported on functions", apperrors.InvalidFunctionRSQL)
+	}
+
+	if slices.ContainsFunc(query.Conditions, func(c rsql.Condition) bool { return c.Subquery != nil }) {
+		return fmt.Errorf("%w: subqueries are not supported on functions", apperrors.InvalidFunctionRSQL)
+	}
+
+	columns := []rsql.Column{}
+	for _, c := range query.Columns {
+		if c.Expr == nil {
+			columns = append(columns, c)
+			continue
+		}
+		for _, col := range c.Expr.Columns() {
+			columns = append(columns, *col)
+		}
+	}
+	columns = append(columns, query.DistinctOn...)
+	for _, c := range query.Conditions {
+		columns = append(columns, c.Column)
+	}
+	for _, o := range query.OrderBy {
+		isAlias := o.Column.Qualifier == "" && slices.ContainsFunc(
+			query.Columns,
+			func(c rsql.Column) bool { return c.Alias == o.Column.Name },
+		)
+		if !isAlias {
+			columns = append(columns, o.Column)
+		}
+	}
+
+	for _, c := range columns {
+		if (c.Qualifier != "" && c.Qualifier != fn.Name) || !slices.Contains(fn.Columns, c.Name) {
+			return fmt.Errorf(
+				"%w: column %s not found in result of %s",
+				apperrors.InvalidFunctionRSQL,
+				c,
+				fn.Name,
+			)
+		}
+	}
+
+	// Every column is in the function's result
+	distinct, distinctCols := distinctColumns(query)
+	for _, c := range distinctCols {
+		c.Qualifier = fn.Name
+	}
+	if err := validateDistinct(distinct); err != nil {
+		return err
+	}
+
+	for i := range query.Conditions {
+		if query.Conditions[i].Cast == "" {
+			continue
+		}
+		if err := coerceCastCondition(&query.Conditions[i]); err != nil {
+			return err
+		}
+	}
+	return normalizeExprCasts(query.Columns)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"gopgrest/apperrors"
 	"gopgrest/assert"
 	"gopgrest/tests"
 	"gopgrest/types"
@@ -23,6 +24,33 @@ func Test_ServiceGetRowByID(t *testing.T) {
 	}
 }
 
+// Primary keys are decoded from change events' JSON payloads
+func Test_ServiceGetChangedRow(t *testing.T) {
+	t.Run("single column", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		row, err := service.GetChangedRow("authors", float64(2))
+		assert.Try(t, err)
+		assert.IsEq(t, row["surname"], any("Brontë"))
+	})
+
+	t.Run("several columns", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		repo, err := service.Repo.WithSchema("archive")
+		assert.Try(t, err)
+		service.Repo = repo
+		pk := map[string]any{"letter_id": float64(1), "recipient": "Lucilius"}
+		row, err := service.GetChangedRow("recipients", pk)
+		assert.Try(t, err)
+		assert.IsEq(t, row["recipient"], any("Lucilius"))
+	})
+
+	t.Run("deleted row", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		_, err := service.GetChangedRow("authors", float64(1000))
+		assert.IsNotEq(t, err, nil)
+	})
+}
+
 func Test_RepoGetRows_NoQuery(t *testing.T) {
 	// GET /authors
 	t.Run("No RSQL query", func(t *testing.T) {
@@ -116,6 +144,12 @@ func Test_ServiceGetRows_Where(t *testing.T) {
 		url := "/authors?where=authors.forename==Anne"
 		serviceGetRowsTester(t, rawQuery, "authors", url)
 	})
+
+	t.Run("Filters (rsql `filter`) in several params must all be true", func(t *testing.T) {
+		rawQuery := "SELECT * FROM authors WHERE (surname = 'Woolf' OR surname = 'Brontë') AND born > 1850"
+		url := "/authors?filter=surname==Woolf,surname==Brontë&filter=born=gt=1850"
+		serviceGetRowsTester(t, rawQuery, "authors", url)
+	})
 }
 
 func Test_ServiceGetRows_Select(t *testing.T) {
@@ -174,6 +208,124 @@ func Test_ServiceGetRows_Joins(t *testing.T) {
 		url := "/books?select=title,name:genre&right_join=genres:books.genre_id==genres.id"
 		serviceGetRowsTester(t, rawQuery, "books", url)
 	})
+
+	t.Run("Aliased self join with a condition", func(t *testing.T) {
+		rawQuery := "SELECT authors.surname, peer.surname AS peer FROM authors JOIN authors AS peer ON authors.forename = peer.forename AND peer.born > 1900"
+		url := "/authors?select=authors.surname,peer.surname:peer&join=authors@peer:authors.forename==peer.forename;peer.born>1900"
+		serviceGetRowsTester(t, rawQuery, "authors", url)
+	})
+
+	t.Run("Join with multiple columns", func(t *testing.T) {
+		rawQuery := "SELECT title, surname FROM books JOIN authors ON books.author_id = authors.id AND books.id = authors.id"
+		url := "/books?select=title,surname&join=authors:books.author_id==authors.id;books.id==authors.id"
+		serviceGetRowsTester(t, rawQuery, "books", url)
+	})
+
+	t.Run("FULL JOIN and CROSS JOIN", func(t *testing.T) {
+		rawQuery := "SELECT title, name AS genre, surname FROM books FULL JOIN genres ON books.genre_id = genres.id CROSS JOIN authors"
+		url := "/books?select=title,name:genre,surname&full_join=genres:books.genre_id==genres.id&cross_join=authors"
+		serviceGetRowsTester(t, rawQuery, "books", url)
+	})
+
+	t.Run("Lateral top-N join", func(t *testing.T) {
+		rawQuery := `SELECT surname, recent.title FROM authors LEFT JOIN LATERAL (
+			SELECT * FROM books AS recent WHERE recent.author_id = authors.id ORDER BY id DESC LIMIT 1
+		) AS recent ON true`
+		url := "/authors?select=surname,recent.title&left_lateral_join=books@recent:recent.author_id==authors.id;order_by=id:desc;limit=1"
+		serviceGetRowsTester(t, rawQuery, "authors", url)
+	})
+
+	t.Run("Table joined twice without an alias", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		_, err := service.GetRowsByRSQL("authors", "/authors?join=authors:authors.id==authors.id")
+		assert.ErrorsIs(t, err, apperrors.InvalidJoin)
+	})
+}
+
+func Test_ServiceGetRows_Subqueries(t *testing.T) {
+	t.Run("IN subquery (rsql `=insub=`)", func(t *testing.T) {
+		rawQuery := "SELECT * FROM authors WHERE id IN (SELECT author_id FROM books WHERE genre_id = 3)"
+		url := "/authors?where=id=insub=books.author_id?genre_id==3"
+		serviceGetRowsTester(t, rawQuery, "authors", url)
+	})
+
+	t.Run("EXISTS subquery (rsql `=exists=`)", func(t *testing.T) {
+		rawQuery := "SELECT * FROM authors WHERE born > 1800 AND EXISTS (SELECT 1 FROM books WHERE books.author_id = authors.id AND genre_id IN (1, 2))"
+		url := `/authors?where=born>1800;id=exists="books.author_id?genre_id=in=1,2"`
+		serviceGetRowsTester(t, rawQuery, "authors", url)
+	})
+
+	t.Run("NOT EXISTS subquery in a filter (rsql `=!exists=`)", func(t *testing.T) {
+		rawQuery := "SELECT * FROM genres WHERE NOT EXISTS (SELECT 1 FROM books WHERE books.genre_id = genres.id) OR name = 'Romance'"
+		url := "/genres?filter=id=!exists=books.genre_id,name==Romance"
+		serviceGetRowsTester(t, rawQuery, "genres", url)
+	})
+
+	t.Run("Nested subqueries", func(t *testing.T) {
+		rawQuery := "SELECT * FROM genres WHERE id IN (SELECT genre_id FROM books WHERE author_id IN (SELECT id FROM authors WHERE born < 1900))"
+		url := "/genres?where=id=insub=books.genre_id?author_id=insub=authors.id?born<1900"
+		serviceGetRowsTester(t, rawQuery, "genres", url)
+	})
+
+	invalid := []struct {
+		name      string
+		tableName string
+		url       string
+	}{
+		{"unknown column", "authors", "/authors?where=id=insub=books.writer_id"},
+		{"exists on the subquery's table", "books", "/books?where=id=exists=books.id"},
+		{"insub on a column of another type", "authors", "/authors?where=surname=insub=books.author_id"},
+		{"exists on a column of another type", "authors", "/authors?where=born=exists=books.title"},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			service := tests.NewTestService(t)
+			_, err := service.GetRowsByRSQL(c.tableName, c.url)
+			assert.ErrorsIs(t, err, apperrors.InvalidSubquery)
+		})
+	}
+}
+
+func Test_ServiceGetRows_Distinct(t *testing.T) {
+	t.Run("DISTINCT (rsql `distinct`)", func(t *testing.T) {
+		rawQuery := "SELECT DISTINCT forename FROM authors"
+		url := "/authors?distinct=true&select=forename"
+		serviceGetRowsTester(t, rawQuery, "authors", url)
+	})
+
+	t.Run("DISTINCT ON (rsql `distinct_on`)", func(t *testing.T) {
+		rawQuery := "SELECT DISTINCT ON (forename) forename, surname FROM authors ORDER BY forename, born DESC"
+		url := "/authors?distinct_on=forename&select=forename,surname&order_by=forename,born:desc"
+		serviceGetRowsTester(t, rawQuery, "authors", url)
+	})
+
+	t.Run("DISTINCT ON a column qualified only in order_by", func(t *testing.T) {
+		rawQuery := "SELECT DISTINCT ON (author_id) author_id, title FROM books ORDER BY books.author_id, title"
+		url := "/books?distinct_on=author_id&select=author_id,title&order_by=books.author_id,title"
+		serviceGetRowsTester(t, rawQuery, "books", url)
+	})
+
+	t.Run("DISTINCT ON a column in several joined tables", func(t *testing.T) {
+		rawQuery := "SELECT DISTINCT ON (books.id) books.id, title FROM books JOIN authors ON books.author_id = authors.id ORDER BY books.id"
+		url := "/books?join=authors:books.author_id==authors.id&distinct_on=id&select=books.id,title&order_by=books.id"
+		serviceGetRowsTester(t, rawQuery, "books", url)
+	})
+
+	invalid := []struct {
+		name string
+		url  string
+	}{
+		{"distinct and distinct_on", "/authors?distinct=true&distinct_on=forename"},
+		{"order_by before distinct_on columns", "/authors?distinct_on=forename&order_by=born,forename"},
+		{"unqualified order_by column of another table", "/books?join=authors:books.author_id==authors.id&distinct_on=authors.id&order_by=id"},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			service := tests.NewTestService(t)
+			_, err := service.GetRowsByRSQL("authors", c.url)
+			assert.ErrorsIs(t, err, apperrors.InvalidDistinct)
+		})
+	}
 }
 
 func Test_ServiceGetRows_LIMIT(t *testing.T) {
@@ -222,3 +374,86 @@ func serviceGetRowsTester(
 	err = tests.CheckMapEquality(expRows, gotRows)
 	assert.Try(t, err)
 }
+
+// The `rank` pseudo-column is resolved during validation and kept in the
+// query that is executed
+func Test_ServiceGetRows_OrderByRank(t *testing.T) {
+	rawQuery := "SELECT id FROM books WHERE to_tsvector(title) @@ websearch_to_tsquery('red or dalloway') " +
+		"ORDER BY ts_rank(to_tsvector(title), websearch_to_tsquery('red or dalloway')) DESC"
+	url := "/books?select=id&where=title=wfts=red%20or%20dalloway&order_by=rank:desc"
+	serviceGetRowsTester(t, rawQuery, "books", url)
+}
+
+func Test_ServiceGetRows_OrderBy(t *testing.T) {
+	t.Run("qualified column of a joined table", func(t *testing.T) {
+		rawQuery := "SELECT title FROM books JOIN authors ON books.author_id = authors.id ORDER BY authors.born, title"
+		url := "/books?select=title&join=authors:books.author_id==authors.id&order_by=authors.born,title"
+		serviceGetRowsTester(t, rawQuery, "books", url)
+	})
+
+	t.Run("column of a table not in the query", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		_, err := service.GetRowsByRSQL("authors", "/authors?order_by=genre_id")
+		assert.ErrorsIs(t, err, apperrors.ColDoesNotExist)
+	})
+}
+
+func Test_ServiceGetRows_ComputedColumns(t *testing.T) {
+	t.Run("concatenation and arithmetic", func(t *testing.T) {
+		rawQuery := "SELECT surname || ', ' || forename AS full_name, died - born AS age FROM authors WHERE died IS NOT NULL ORDER BY age DESC"
+		url := "/authors?select=surname||',%20'||forename:full_name,died-born:age&where=died=notnull=&order_by=age:desc"
+		serviceGetRowsTester(t, rawQuery, "authors", url)
+	})
+
+	t.Run("functions and casts", func(t *testing.T) {
+		rawQuery := "SELECT upper(surname) AS s, (coalesce(died, 2000) - born)::text AS span FROM authors"
+		url := "/authors?select=upper(authors.surname):s,(coalesce(died,2000)-born)::text:span"
+		serviceGetRowsTester(t, rawQuery, "authors", url)
+	})
+
+	t.Run("unknown column", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		_, err := service.GetRowsByRSQL("authors", "/authors?select=lower(nickname):n")
+		assert.IsTrue(t, err != nil)
+	})
+
+	t.Run("column of a table not in the query", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		_, err := service.GetRowsByRSQL("authors", "/authors?select=title||surname:x")
+		assert.ErrorsIs(t, err, apperrors.ColDoesNotExist)
+	})
+
+	t.Run("unknown cast", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		_, err := service.GetRowsByRSQL("authors", "/authors?select=born::money:b")
+		assert.ErrorsIs(t, err, apperrors.InvalidCast)
+	})
+}
+
+func Test_ServiceGetRows_WindowFunctions(t *testing.T) {
+	t.Run("partition and order", func(t *testing.T) {
+		rawQuery := "SELECT title, rank() OVER (PARTITION BY author_id ORDER BY id) AS r FROM books ORDER BY id"
+		url := "/books?select=title,rank()over(partition_by=author_id;order_by=id):r&order_by=id"
+		serviceGetRowsTester(t, rawQuery, "books", url)
+	})
+
+	t.Run("running total with lag", func(t *testing.T) {
+		rawQuery := "SELECT id, sum(id) OVER (ORDER BY id) AS total, lag(title, 1, '-') OVER (ORDER BY id) AS prev FROM books ORDER BY id"
+		url := "/books?select=id,sum(id)over(order_by=id):total,lag(title,1,'-')over(order_by=id):prev&order_by=id"
+		serviceGetRowsTester(t, rawQuery, "books", url)
+	})
+
+	t.Run("unknown partition column", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		_, err := service.GetRowsByRSQL("books", "/books?select=rank()over(partition_by=publisher_id):r")
+		assert.IsTrue(t, err != nil)
+	})
+
+	t.Run("partition and order columns of a table not in the query", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		_, err := service.GetRowsByRSQL("authors", "/authors?select=row_number()over(partition_by=genre_id):n")
+		assert.ErrorsIs(t, err, apperrors.ColDoesNotExist)
+		_, err = service.GetRowsByRSQL("authors", "/authors?select=row_number()over(order_by=title):n")
+		assert.ErrorsIs(t, err, apperrors.ColDoesNotExist)
+	})
+}
@@ -4,10 +4,13 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"maps"
 	"reflect"
 	"slices"
+	"strings"
 
 	"gopgrest/apperrors"
+	"gopgrest/pgtypes"
 	"gopgrest/repository"
 	"gopgrest/rsql"
 	"gopgrest/types"
@@ -24,11 +27,38 @@ func verifyColumns(t *repository.Table, cols []string) (string, error) {
 	return "", nil
 }
 
-// ScanRows scans rows from a query into a map
+// fillMissingColumns returns copies of rows where each of cols that a row is
+// missing is set to nil
+func fillMissingColumns(rows []types.RowData, cols []string) []types.RowData {
+	filled := make([]types.RowData, len(rows))
+	for i, row := range rows {
+		filled[i] = maps.Clone(row)
+		for _, col := range cols {
+			if _, ok := row[col]; !ok {
+				filled[i][col] = nil
+			}
+		}
+	}
+	return filled
+}
+
+// ScanRows scans rows from a query into a map, with values decoded by the
+// default pgtypes.Decoder
 func ScanRows(rows *sql.Rows) ([]types.RowData, error) {
+	return scanRows(rows, pgtypes.Decoder{})
+}
+
+// scanRows scans rows from a query into a map, with values decoded by their
+// column's type so that they marshal to JSON
+func scanRows(rows *sql.Rows, decoder pgtypes.Decoder) ([]types.RowData, error) {
 	// Make arrays of pointers with sizes that match column type
 	cols, _ := rows.Columns()
 	rowValues, rowPtrs := makeScanDestination(rows, cols)
+	dbTypes := []string{}
+	colTypes, _ := rows.ColumnTypes()
+	for _, ct := range colTypes {
+		dbTypes = append(dbTypes, ct.DatabaseTypeName())
+	}
 
 	scannedRows := []types.RowData{}
 	for rows.Next() {
@@ -37,7 +67,7 @@ func ScanRows(rows *sql.Rows) ([]types.RowData, error) {
 		if err != nil {
 			return nil, err
 		}
-		scannedRow := makeScannedRowMap(cols, rowValues)
+		scannedRow := makeScannedRowMap(cols, dbTypes, rowValues, decoder)
 		scannedRows = append(scannedRows, scannedRow)
 	}
 
@@ -64,39 +94,264 @@ func makeScanDestination(rows *sql.Rows, cols []string) ([]any, []any) {
 	return rowValues, rowPtrs
 }
 
-// makeScannedRowMap fills a RowDataMap with values from a scanned row
-func makeScannedRowMap(cols []string, rowValues []any) types.RowData {
+// makeScannedRowMap fills a RowDataMap with values from a scanned row,
+// decoded by their column's type
+func makeScannedRowMap(cols, dbTypes []string, rowValues []any, decoder pgtypes.Decoder) types.RowData {
 	scannedRow := make(types.RowData)
 	for i, col := range cols {
-		val := rowValues[i]
-		scannedRow[col] = val
-
+		scannedRow[col] = decoder.Decode(dbTypes[i], rowValues[i])
 	}
 	return scannedRow
 }
 
-func (s *Service) validateRSQLQuery(query rsql.QueryParams) error {
+// encodeRows returns copies of rows with each value encoded for its column's
+// type. Columns must already be verified to exist
+func encodeRows(t *repository.Table, rows []types.RowData) ([]types.RowData, error) {
+	encoded := make([]types.RowData, len(rows))
+	for i, row := range rows {
+		encoded[i] = types.RowData{}
+		for k, v := range row {
+			col, _ := t.GetColumn(k)
+			val, err := pgtypes.Encode(col.DBType, v)
+			if err != nil {
+				return nil, fmt.Errorf("%w\ncol %s: %s", apperrors.InvalidRequestBody, k, err)
+			}
+			encoded[i][k] = val
+		}
+	}
+	return encoded, nil
+}
+
+// validateRSQLQuery validates a query against the schema, marking its
+// order_by columns that are the `rank` pseudo-column
+func (s *Service) validateRSQLQuery(query *rsql.QueryParams) error {
 	if err := s.validateRSQLTables(query.Tables); err != nil {
 		return err
 	}
-	if err := s.ValidateRSQLConditions(query.Tables, query.Conditions); err != nil {
+	tables := query.TableRefs()
+	if err := s.resolveJSONColumns(tables, queryColumns(*query)); err != nil {
+		return err
+	}
+	if err := s.validateRSQLJoins(*query); err != nil {
+		return err
+	}
+	if err := s.ValidateRSQLConditions(tables, query.Conditions); err != nil {
+		return err
+	}
+	for _, j := range query.Joins {
+		if err := s.ValidateRSQLConditions(tables, j.Conditions); err != nil {
+			return err
+		}
+	}
+	if err := s.validateRSQLColumns(tables, query.Columns); err != nil {
 		return err
 	}
-	if err := s.validateRSQLColumns(query.Columns); err != nil {
+	if err := s.validateRSQLColumns(tables, query.DistinctOn); err != nil {
 		return err
 	}
-	if err := s.validateRSQLJoins(query.Joins); err != nil {
+	if err := s.validateRSQLOrderBy(query); err != nil {
+		return err
+	}
+	// Compare distinct_on and order_by columns by their tables, so that e.g.
+	// `author_id` matches `books.author_id`
+	distinct, columns := distinctColumns(*query)
+	if err := s.qualifyColumns(tables, columns); err != nil {
+		return err
+	}
+	if err := validateDistinct(distinct); err != nil {
+		return err
+	}
+	// Write distinct_on columns as they were resolved, as a column may be in
+	// several of the query's tables
+	query.DistinctOn = distinct.DistinctOn
+	if err := s.validateJSONPaths(tables, queryColumns(*query)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *Service) ValidateRSQLConditions(tableNames []string, conditions []rsql.Condition) error {
+// queryColumns returns pointers to every column in a query's select,
+// distinct_on, where, join and order_by clauses, including the order of a
+// lateral join
+func queryColumns(query rsql.QueryParams) []*rsql.Column {
+	columns := []*rsql.Column{}
+	for i := range query.Columns {
+		if query.Columns[i].Expr != nil {
+			columns = append(columns, query.Columns[i].Expr.Columns()...)
+		} else {
+			columns = append(columns, &query.Columns[i])
+		}
+	}
+	for i := range query.DistinctOn {
+		columns = append(columns, &query.DistinctOn[i])
+	}
+	for i := range query.Conditions {
+		columns = append(columns, &query.Conditions[i].Column)
+	}
+	for _, j := range query.Joins {
+		for i := range j.Conditions {
+			columns = append(columns, &j.Conditions[i].Column)
+		}
+		for i := range j.OrderBy {
+			columns = append(columns, &j.OrderBy[i].Column)
+		}
+	}
+	for i := range query.OrderBy {
+		columns = append(columns, &query.OrderBy[i].Column)
+	}
+	return columns
+}
+
+// resolveJSONColumns reads a column qualified with something other than one
+// of the query's tables as a path into a json column, e.g. `meta.address` as
+// key `address` of column `meta` rather than column `address` of table `meta`
+func (s *Service) resolveJSONColumns(tables []rsql.TableRef, columns []*rsql.Column) error {
+	for _, c := range columns {
+		if c.Qualifier == "" || slices.ContainsFunc(tables, func(t rsql.TableRef) bool { return t.Name == c.Qualifier }) {
+			continue
+		}
+		for _, ref := range tables {
+			table, err := s.Repo.GetTable(ref.Table)
+			if err != nil {
+				return err
+			}
+			if _, ok := table.GetColumn(c.Qualifier); !ok {
+				continue
+			}
+			// Keys separated by `.` are extracted as text, e.g. `meta.address`
+			// is `meta->>address`
+			if len(c.Path) == 0 {
+				c.PathAsText = true
+			}
+			c.Path = append([]string{c.Name}, c.Path...)
+			c.Name = c.Qualifier
+			c.Qualifier = ""
+			break
+		}
+	}
+	return nil
+}
+
+// validateJSONPaths checks that columns with a path are json or jsonb columns
+func (s *Service) validateJSONPaths(tables []rsql.TableRef, columns []*rsql.Column) error {
+	for _, c := range columns {
+		if len(c.Path) == 0 {
+			continue
+		}
+		col, err := s.findColumn(tables, *c)
+		if err != nil {
+			return err
+		}
+		if col.DBType != "JSON" && col.DBType != "JSONB" {
+			return fmt.Errorf(
+				"%w: col %s is %s, not json or jsonb",
+				apperrors.InvalidJSONPath,
+				c,
+				sqlTypeName(col.DBType),
+			)
+		}
+	}
+	return nil
+}
+
+// validateRSQLOrderBy checks that each order_by column is either an alias
+// from the select clause, the `rank` pseudo-column or a valid column, and
+// marks the query's `rank` columns as Rank
+func (s *Service) validateRSQLOrderBy(query *rsql.QueryParams) error {
+	columns := []rsql.Column{}
+	for i, o := range query.OrderBy {
+		isAlias := o.Column.Qualifier == "" && slices.ContainsFunc(
+			query.Columns,
+			func(c rsql.Column) bool { return c.Alias == o.Column.Name },
+		)
+		if isAlias {
+			continue
+		}
+		isRank, err := s.isRank(*query, o.Column)
+		if err != nil {
+			return err
+		}
+		if isRank {
+			query.OrderBy[i].Rank = true
+			continue
+		}
+		columns = append(columns, o.Column)
+	}
+	return s.validateRSQLColumns(query.TableRefs(), columns)
+}
+
+// distinctColumns returns a copy of a query with copies of its distinct_on
+// and order_by columns, which can be qualified through the returned pointers
+// without changing the query
+func distinctColumns(query rsql.QueryParams) (rsql.QueryParams, []*rsql.Column) {
+	query.DistinctOn = slices.Clone(query.DistinctOn)
+	query.OrderBy = slices.Clone(query.OrderBy)
+	columns := []*rsql.Column{}
+	for i := range query.DistinctOn {
+		columns = append(columns, &query.DistinctOn[i])
+	}
+	for i := range query.OrderBy {
+		columns = append(columns, &query.OrderBy[i].Column)
+	}
+	return query, columns
+}
+
+// validateDistinct checks that a query has at most one of distinct and
+// distinct_on, and that the columns of distinct_on are its leftmost order_by
+// columns, in any order, as Postgres requires of DISTINCT ON. Columns are
+// compared as written, so they must be qualified alike
+func validateDistinct(query rsql.QueryParams) error {
+	if len(query.DistinctOn) == 0 {
+		return nil
+	}
+	if query.Distinct {
+		return fmt.Errorf("%w: use only one of %s and %s", apperrors.InvalidDistinct, rsql.DISTINCT, rsql.DISTINCTON)
+	}
+	distinctOn := map[string]bool{}
+	for _, c := range query.DistinctOn {
+		distinctOn[c.String()] = true
+	}
+	matched := map[string]bool{}
+	for _, o := range query.OrderBy {
+		if len(matched) == len(distinctOn) {
+			break
+		}
+		if !distinctOn[o.Column.String()] {
+			return fmt.Errorf(
+				"%w: order_by column %s must come after the %s columns %s",
+				apperrors.InvalidDistinct,
+				o.Column,
+				rsql.DISTINCTON,
+				query.DistinctOn,
+			)
+		}
+		matched[o.Column.String()] = true
+	}
+	return nil
+}
+
+// isRank reports whether a column is the `rank` pseudo-column, i.e. `rank`
+// isn't a column of any of the query's tables. Ranking requires a full-text
+// search condition
+func (s *Service) isRank(query rsql.QueryParams, column rsql.Column) (bool, error) {
+	if column.Qualifier != "" || column.Name != "rank" || len(column.Path) > 0 {
+		return false, nil
+	}
+	if _, err := s.findColumn(query.TableRefs(), column); err == nil {
+		return false, nil
+	}
+	if !slices.ContainsFunc(query.Conditions, func(c rsql.Condition) bool { return c.TextSearch != nil }) {
+		return false, apperrors.RankWithoutTextSearch
+	}
+	return true, nil
+}
+
+func (s *Service) ValidateRSQLConditions(tables []rsql.TableRef, conditions []rsql.Condition) error {
 	// Validate: each column in the WHERE clause should be valid for its table
-	for _, f := range conditions {
+	for i, f := range conditions {
 		// Check if column is prefixed with a table, e.g. authors.forename
 		if f.Column.Qualifier != "" {
-			table, err := s.Repo.GetTable(f.Column.Qualifier)
+			table, err := s.qualifierTable(tables, f.Column.Qualifier)
 			if err != nil {
 				return err
 			}
@@ -110,13 +365,18 @@ func (s *Service) ValidateRSQLConditions(tableNames []string, conditions []rsql.
 		} else {
 			// Search for column in all tables referenced in query
 			found := false
-			for _, tableName := range tableNames {
-				table, err := s.Repo.GetTable(tableName)
+			for _, ref := range tables {
+				table, err := s.Repo.GetTable(ref.Table)
 				if err != nil {
 					return err
 				}
 				if s.Repo.IsValidColumn(*table, f.Column.Name) {
 					found = true
+					// A column compared with a subquery is qualified, so
+					// that it isn't taken for a column of the subquery
+					if f.Subquery != nil {
+						conditions[i].Column.Qualifier = ref.Name
+					}
 					break
 				}
 			}
@@ -127,10 +387,121 @@ func (s *Service) ValidateRSQLConditions(tableNames []string, conditions []rsql.
 				)
 			}
 		}
+		if f.Subquery != nil {
+			if err := s.validateSubquery(tables, &conditions[i]); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+// qualifyColumns qualifies each unqualified column with the first of the
+// tables that has it, the table in the URL's path before any joined table, so
+// that a column in several of the tables isn't ambiguous
+func (s *Service) qualifyColumns(tables []rsql.TableRef, columns []*rsql.Column) error {
+	for _, col := range columns {
+		if col.Qualifier != "" {
+			continue
+		}
+		for _, ref := range tables {
+			table, err := s.Repo.GetTable(ref.Table)
+			if err != nil {
+				return err
+			}
+			if s.Repo.IsValidColumn(*table, col.Name) {
+				col.Qualifier = ref.Name
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// validateSubquery checks that a subquery's table and column exist, that the
+// column can be compared with the condition's column, and validates its
+// conditions against its table
+func (s *Service) validateSubquery(tables []rsql.TableRef, cond *rsql.Condition) error {
+	subquery := cond.Subquery
+	table, err := s.Repo.GetTable(subquery.Table)
+	if err != nil {
+		return err
+	}
+	col, ok := table.GetColumn(subquery.Column)
+	if !ok {
+		return fmt.Errorf(
+			"%w: col %s not found in table %s",
+			apperrors.InvalidSubquery,
+			subquery.Column,
+			subquery.Table,
+		)
+	}
+	dbType, err := s.conditionType(tables, *cond)
+	if err != nil {
+		return fmt.Errorf("%w on col %s: %s", apperrors.InvalidCast, cond.Column, err)
+	}
+	if !isComparable(dbType, col.DBType) {
+		return fmt.Errorf(
+			"%w: col %s of type %s cannot be compared with %s.%s of type %s",
+			apperrors.InvalidSubquery,
+			cond.Column,
+			sqlTypeName(dbType),
+			subquery.Table,
+			subquery.Column,
+			sqlTypeName(col.DBType),
+		)
+	}
+	// The column is compared with the subquery's column within the subquery,
+	// where its qualifier would refer to the subquery's table
+	if cond.SQLOperator != "IN" && cond.Column.Qualifier == subquery.Table {
+		return fmt.Errorf(
+			"%w: %s on a column of table %s",
+			apperrors.InvalidSubquery,
+			cond.Operator,
+			subquery.Table,
+		)
+	}
+	subquery.Schema = s.Repo.Schema
+
+	subqueryTables := []rsql.TableRef{{Name: subquery.Table, Table: subquery.Table}}
+	columns := []*rsql.Column{}
+	for i := range subquery.Conditions {
+		columns = append(columns, &subquery.Conditions[i].Column)
+	}
+	if err := s.resolveJSONColumns(subqueryTables, columns); err != nil {
+		return err
+	}
+	if err := s.ValidateRSQLConditions(subqueryTables, subquery.Conditions); err != nil {
+		return err
+	}
+	return s.validateJSONPaths(subqueryTables, columns)
+}
+
+// comparableTypes are groups of types whose values Postgres compares with
+// each other, e.g. an int4 column with an int8 one
+var comparableTypes = [][]string{
+	{"INT2", "INT4", "INT8", "FLOAT4", "FLOAT8", "NUMERIC"},
+	{"TEXT", "VARCHAR", "BPCHAR"},
+	{"DATE", "TIMESTAMP", "TIMESTAMPTZ"},
+}
+
+// isComparable reports whether values of two types can be compared, as they
+// are the same type or in the same group of comparableTypes. Arrays are
+// comparable if their elements are
+func isComparable(a, b string) bool {
+	elemA, isArrayA := strings.CutPrefix(a, "_")
+	elemB, isArrayB := strings.CutPrefix(b, "_")
+	if isArrayA != isArrayB {
+		return false
+	}
+	if elemA == elemB {
+		return true
+	}
+	return slices.ContainsFunc(comparableTypes, func(group []string) bool {
+		return slices.Contains(group, elemA) && slices.Contains(group, elemB)
+	})
+}
+
 func (s *Service) validateRSQLTables(tables []string) error {
 	for _, t := range tables {
 		_, err := s.Repo.GetTable(t)
@@ -141,66 +512,90 @@ func (s *Service) validateRSQLTables(tables []string) error {
 	return nil
 }
 
-func (s *Service) validateRSQLColumns(columns []rsql.Column) error {
+func (s *Service) validateRSQLColumns(tables []rsql.TableRef, columns []rsql.Column) error {
 	for _, f := range columns {
 
-		// If the column has a qualifier, check the column against that table
-		if f.Qualifier != "" {
-			t, err := s.Repo.GetTable(f.Qualifier)
-			if err != nil {
-				return err
-			}
-			if !s.Repo.IsValidColumn(*t, f.Name) {
-				return fmt.Errorf("column %s not found in table %s", f, f.Qualifier)
+		// Check the columns a computed column refers to
+		if f.Expr != nil {
+			for _, c := range f.Expr.Columns() {
+				if err := s.validateRSQLColumns(tables, []rsql.Column{*c}); err != nil {
+					return err
+				}
 			}
 			continue
 		}
 
-		// Otherwise, check all tables
-		foundCol := false
-		for _, t := range s.Repo.Tables {
-			if s.Repo.IsValidColumn(t, f.Name) {
-				foundCol = true
-				break
-			}
-		}
-		if !foundCol {
-			return fmt.Errorf("column %s not found in any tables", f)
+		// Check the column against its qualifying table, or else the query's
+		// tables
+		if _, err := s.findColumn(tables, f); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (s *Service) validateRSQLJoins(joins []rsql.JoinRelation) error {
-	for _, j := range joins {
+// validateRSQLJoins checks that each joined table exists and is referred to
+// by a name no other table of the query has, and that the columns of each
+// join's ON clause are in the query's tables, one of them the joined table
+func (s *Service) validateRSQLJoins(query rsql.QueryParams) error {
+	tables := query.TableRefs()
+	for i, ref := range tables {
+		if slices.ContainsFunc(tables[:i], func(t rsql.TableRef) bool { return t.Name == ref.Name }) {
+			return fmt.Errorf(
+				"%w: table %s is in the query more than once, give it an alias, e.g. `%s%s%s_2`",
+				apperrors.InvalidJoin, ref.Name, ref.Table, rsql.TABLE_ALIAS_SEP, ref.Table,
+			)
+		}
+	}
+	for _, j := range query.Joins {
 		// Check tables exist
 		if _, err := s.Repo.GetTable(j.Table); err != nil {
 			return err
 		}
-		leftTable, err := s.Repo.GetTable(j.LeftQualifier)
-		if err != nil {
-			return err
-		}
-		rightTable, err := s.Repo.GetTable(j.RightQualifier)
-		if err != nil {
-			return err
+		// Check table after JOIN keyword is in the qualified column names. A
+		// cross join has no ON clause, and a lateral join needn't relate its
+		// rows to the tables before it
+		refersToJoin := func(on rsql.JoinOn) bool {
+			return on.Left.Qualifier == j.Name() || on.Right.Qualifier == j.Name()
 		}
-		// Check table after JOIN keyword is in the qualified column names
-		// (LeftTable/RightTable)
-		if !slices.Contains([]string{j.LeftQualifier, j.RightQualifier}, j.Table) {
-			return fmt.Errorf("Table in JOIN statement missing from relation: %v", j)
+		needsRelation := j.Type != rsql.JoinTypes[rsql.CROSSJOIN] && !j.IsLateral()
+		if needsRelation && !slices.ContainsFunc(j.On, refersToJoin) {
+			return fmt.Errorf("%w: table %s missing from relation: %v", apperrors.InvalidJoin, j.Name(), j)
 		}
-
-		if !s.Repo.IsValidColumn(*leftTable, j.LeftCol) {
-			return fmt.Errorf("col %s not found in table %s in join %v", j.LeftCol, leftTable, j)
+		for _, on := range j.On {
+			for _, c := range []rsql.Column{on.Left, on.Right} {
+				i := slices.IndexFunc(tables, func(t rsql.TableRef) bool { return t.Name == c.Qualifier })
+				if i < 0 {
+					return fmt.Errorf("%w: table %s not in query in join %v", apperrors.InvalidJoin, c.Qualifier, j)
+				}
+				table, err := s.Repo.GetTable(tables[i].Table)
+				if err != nil {
+					return err
+				}
+				if !s.Repo.IsValidColumn(*table, c.Name) {
+					return fmt.Errorf("col %s not found in table %s in join %v", c.Name, table.Name, j)
+				}
+			}
 		}
-		if !s.Repo.IsValidColumn(*rightTable, j.RightCol) {
-			return fmt.Errorf("col %s not found in table %s in join %v", j.LeftCol, leftTable, j)
+		// A lateral join is ordered by its own table's columns
+		for _, o := range j.OrderBy {
+			if _, err := s.findColumn([]rsql.TableRef{{Name: j.Name(), Table: j.Table}}, o.Column); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// qualifierTable returns the table a column's qualifier refers to, a table
+// of the query by its name or alias, or else a table in the schema
+func (s *Service) qualifierTable(tables []rsql.TableRef, qualifier string) (*repository.Table, error) {
+	if i := slices.IndexFunc(tables, func(t rsql.TableRef) bool { return t.Name == qualifier }); i >= 0 {
+		return s.Repo.GetTable(tables[i].Table)
+	}
+	return s.Repo.GetTable(qualifier)
+}
+
 func (s *Service) newRSQLQuery(url string) (rsql.QueryParams, error) {
 	// Parse RSQL
 	query, err := rsql.NewRSQLQuery(url)
@@ -208,8 +603,29 @@ func (s *Service) newRSQLQuery(url string) (rsql.QueryParams, error) {
 		return rsql.QueryParams{}, err
 	}
 	// Validate RSQL
-	if err := s.validateRSQLQuery(query); err != nil {
+	if err := s.validateRSQLQuery(&query); err != nil {
+		return rsql.QueryParams{}, err
+	}
+	// Parse condition values as their column's type
+	if err := s.coerceConditions(query.TableRefs(), query.Conditions); err != nil {
+		return rsql.QueryParams{}, err
+	}
+	for _, j := range query.Joins {
+		if err := s.coerceConditions(query.TableRefs(), j.Conditions); err != nil {
+			return rsql.QueryParams{}, err
+		}
+	}
+	if err := normalizeExprCasts(query.Columns); err != nil {
 		return rsql.QueryParams{}, err
 	}
 	return query, nil
 }
+
+// writeNotAllowed returns err for a write the table doesn't allow, or
+// apperrors.TableIsReadOnly if the table allows no writes at all
+func writeNotAllowed(table *repository.Table, err error) error {
+	if table.ReadOnly() {
+		err = apperrors.TableIsReadOnly
+	}
+	return fmt.Errorf("%w (%s)", err, table.Name)
+}
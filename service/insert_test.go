@@ -1,6 +1,7 @@
 package service_test
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -29,7 +30,7 @@ func Test_ServiceInsertRows(t *testing.T) {
 		},
 	}
 
-	ids, err := service.InsertRows(newRows, "authors")
+	ids, err := service.InsertRows(newRows, "authors", "")
 	assert.Try(t, err)
 
 	// Turn got ids into str to retrieve from db in one query
@@ -56,14 +57,14 @@ func Test_ServiceInsertRows(t *testing.T) {
 
 func Test_ServiceInsertRow_NoRows(t *testing.T) {
 	repo := tests.NewTestService(t)
-	_, err := repo.InsertRows([]types.RowData{}, "authors")
+	_, err := repo.InsertRows([]types.RowData{}, "authors", "")
 	assert.ErrorsIs(t, err, apperrors.InsertWithNoRows)
 }
 
 func Test_ServiceInsertRow_BadTable(t *testing.T) {
 	service := tests.NewTestService(t)
 
-	_, gotErr := service.InsertRows([]types.RowData{{"dummy": "value"}}, "doesnotexist")
+	_, gotErr := service.InsertRows([]types.RowData{{"dummy": "value"}}, "doesnotexist", "")
 	expErr := apperrors.TableDoesNotExist
 	assert.ErrorsIs(t, gotErr, expErr)
 }
@@ -73,18 +74,17 @@ func Test_ServiceInsertRow_BadColumn(t *testing.T) {
 	badCol := "specialty"
 	badRow := []types.RowData{{"surname": "Sappho", badCol: "lyric poetry"}}
 
-	_, gotErr := service.InsertRows(badRow, "authors")
+	_, gotErr := service.InsertRows(badRow, "authors", "")
 	expErr := apperrors.ColDoesNotExist
 	assert.ErrorsIs(t, gotErr, expErr)
 }
 
-func Test_ServiceInsertRow_MismatchedColumns(t *testing.T) {
+func Test_ServiceInsertRows_DifferentColumns(t *testing.T) {
 	service := tests.NewTestService(t)
-	mismatchedCols := []types.RowData{
+	newRows := []types.RowData{
 		{
 			"surname": "Jemisin",
 			"born":    int64(1972),
-			"died":    nil,
 		},
 		{
 			"forename": "Martha",
@@ -92,7 +92,44 @@ func Test_ServiceInsertRow_MismatchedColumns(t *testing.T) {
 		},
 	}
 
-	_, gotErr := service.InsertRows(mismatchedCols, "authors")
-	expErr := apperrors.InsertColsDoNotMatch
-	assert.ErrorsIs(t, gotErr, expErr)
+	ids, err := service.InsertRows(newRows, "authors", "")
+	assert.Try(t, err)
+	assert.IsEq(t, len(ids), 2)
+
+	// Missing columns are set to their default
+	gotRows, err := tests.SelectRows(
+		service.Repo,
+		fmt.Sprintf("SELECT forename, born FROM authors WHERE id IN (%d, %d) ORDER BY id", ids[0], ids[1]),
+	)
+	assert.Try(t, err)
+	assert.IsEq(t, gotRows[0]["forename"], "")
+	assert.IsEq(t, gotRows[0]["born"], int64(1972))
+	assert.IsEq(t, gotRows[1]["forename"], "Martha")
+	assert.IsEq(t, gotRows[1]["born"], nil)
+}
+
+func Test_ServiceInsertRows_MissingNull(t *testing.T) {
+	service := tests.NewTestService(t)
+	newRows := []types.RowData{
+		{"surname": "Jemisin", "forename": "N.K."},
+		{"surname": "Plato"},
+	}
+
+	// forename has a default but can't be null
+	_, gotErr := service.InsertRows(newRows, "authors", "null")
+	var validationErr *apperrors.ValidationError
+	assert.IsTrue(t, errors.As(gotErr, &validationErr))
+	assert.IsEq(t, validationErr.Fields[0].Row, 1)
+	assert.IsEq(t, validationErr.Fields[0].Column, "forename")
+}
+
+func Test_ServiceInsertRows_MismatchedTypes(t *testing.T) {
+	service := tests.NewTestService(t)
+	mismatchedTypes := []types.RowData{
+		{"surname": "Jemisin", "born": int64(1972)},
+		{"surname": "Nussbaum", "born": "1947"},
+	}
+
+	_, gotErr := service.InsertRows(mismatchedTypes, "authors", "")
+	assert.ErrorsIs(t, gotErr, apperrors.InsertValTypesDoNotMatch)
 }
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopgrest/apperrors"
+	"gopgrest/repatterns"
+	"gopgrest/rsql"
+	"gopgrest/types"
+)
+
+// SavedQuery is a named RSQL query template, e.g.
+// `/books?select=title&where=genre_id=={genre}`, served at
+// `GET /_queries/{name}`. Its path may select a schema like a request's,
+// e.g. `/archive/letters`. Each `{param}` placeholder in Query is replaced by
+// the value of one of Params, which maps the params to their types, e.g.
+// `int` for `genre`. A value is quoted, so that it is parsed as a single
+// value, and a placeholder must be where a value begins, e.g. after `==`
+type SavedQuery struct {
+	Query  string            `json:"query"`
+	Params map[string]string `json:"params"`
+}
+
+// reSavedQueryName matches the name of a saved query, which is a part of its
+// path
+var reSavedQueryName = regexp.MustCompile(`^` + repatterns.Ident + `$`)
+
+// rePlaceholder matches the placeholder of a param in a saved query, e.g.
+// `{genre}`
+var rePlaceholder = regexp.MustCompile(`\{(` + repatterns.Ident + `)\}`)
+
+// sampleValues are values of each type a saved query's params may have, with
+// which its query is validated
+var sampleValues = map[string]string{
+	"INT2":        "0",
+	"INT4":        "0",
+	"INT8":        "0",
+	"FLOAT4":      "0",
+	"FLOAT8":      "0",
+	"NUMERIC":     "0",
+	"TEXT":        "",
+	"VARCHAR":     "",
+	"BOOL":        "true",
+	"DATE":        "2000-01-01",
+	"TIME":        "00:00:00",
+	"TIMESTAMP":   "2000-01-01T00:00:00",
+	"TIMESTAMPTZ": "2000-01-01T00:00:00Z",
+	"UUID":        "00000000-0000-0000-0000-000000000000",
+	"JSON":        "null",
+	"JSONB":       "null",
+}
+
+// ReadSavedQueries reads saved queries from a JSON file of queries by name,
+// e.g. `{"books_by_genre": {"query": "/books?where=genre_id=={genre}",
+// "params": {"genre": "int"}}}`
+func ReadSavedQueries(path string) (map[string]SavedQuery, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	queries := map[string]SavedQuery{}
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, fmt.Errorf("could not read saved queries from %s: %w", path, err)
+	}
+	return queries, nil
+}
+
+// SetSavedQueries validates saved queries against the schema, so that an
+// invalid query is reported once rather than on each request, and sets them
+// as the service's SavedQueries
+func (s *Service) SetSavedQueries(queries map[string]SavedQuery) error {
+	for _, name := range slices.Sorted(maps.Keys(queries)) {
+		if err := s.validateSavedQuery(name, queries[name]); err != nil {
+			return fmt.Errorf("saved query %s: %w", name, err)
+		}
+	}
+	s.SavedQueries = queries
+	return nil
+}
+
+// validateSavedQuery checks that a saved query's params have types that can
+// be passed in a URL and are each used in its query, that each placeholder in
+// its query is one of its params, and that its query is valid once its params
+// are replaced by sample values of their types
+func (s *Service) validateSavedQuery(name string, q SavedQuery) error {
+	if !reSavedQueryName.MatchString(name) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	samples := map[string]string{}
+	for param, cast := range q.Params {
+		dbType, err := normalizeCast(cast)
+		if err != nil {
+			return fmt.Errorf("param %s: %w", param, err)
+		}
+		sample, ok := sampleValues[dbType]
+		if !ok {
+			return fmt.Errorf("param %s: type %s is not supported", param, cast)
+		}
+		if !strings.Contains(q.Query, placeholder(param)) {
+			return fmt.Errorf("param %s is not used in the query", param)
+		}
+		samples[param] = sample
+	}
+	for _, m := range rePlaceholder.FindAllStringSubmatch(q.Query, -1) {
+		if _, ok := q.Params[m[1]]; !ok {
+			return fmt.Errorf("placeholder %s is not a param", m[0])
+		}
+	}
+	schema, tableName, url, err := q.expand(samples)
+	if err != nil {
+		return err
+	}
+	scoped, err := s.withSchema(schema)
+	if err != nil {
+		return err
+	}
+	if _, err := scoped.Repo.GetTable(tableName); err != nil {
+		return err
+	}
+	_, err = scoped.newRSQLQuery(url)
+	return err
+}
+
+// GetSavedQuery gets the rows of a saved query with values for each of its
+// params, which are parsed as their types
+func (s *Service) GetSavedQuery(name string, values map[string]string) ([]types.RowData, error) {
+	q, ok := s.SavedQueries[name]
+	if !ok {
+		return nil, fmt.Errorf("%w (%s)", apperrors.SavedQueryDoesNotExist, name)
+	}
+	for param := range values {
+		if _, ok := q.Params[param]; !ok {
+			return nil, fmt.Errorf("%w: %s has no param %s", apperrors.InvalidSavedQueryParams, name, param)
+		}
+	}
+	for _, param := range slices.Sorted(maps.Keys(q.Params)) {
+		val, ok := values[param]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing param %s", apperrors.InvalidSavedQueryParams, param)
+		}
+		dbType, err := normalizeCast(q.Params[param])
+		if err != nil {
+			return nil, err
+		}
+		if _, err := parseValues(dbType, []string{val}); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", apperrors.InvalidSavedQueryParams, param, err)
+		}
+	}
+
+	schema, tableName, url, err := q.expand(values)
+	if err != nil {
+		return nil, err
+	}
+	scoped, err := s.withSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	return scoped.GetRowsByRSQL(tableName, url)
+}
+
+// withSchema returns a copy of the Service that looks up tables in another
+// exposed schema, or the Service itself if schema is empty
+func (s *Service) withSchema(schema string) (*Service, error) {
+	if schema == "" {
+		return s, nil
+	}
+	repo, err := s.Repo.WithSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	scoped := *s
+	scoped.Repo = repo
+	return &scoped, nil
+}
+
+// expand replaces the placeholders of a saved query's params with their
+// values, quoted and percent-encoded, and returns the schema selected by the
+// query's path, if any, and the query's table and URL without the schema
+func (q SavedQuery) expand(values map[string]string) (string, string, string, error) {
+	expanded := q.Query
+	for param, val := range values {
+		expanded = strings.ReplaceAll(expanded, placeholder(param), url.QueryEscape(rsql.Quote(val)))
+	}
+	var schema string
+	if !repatterns.ReqOptionalParams.MatchString(expanded) {
+		if matches := repatterns.ReqSchemaPrefix.FindStringSubmatch(expanded); matches != nil {
+			schema, expanded = matches[1], matches[2]
+		}
+	}
+	matches := repatterns.ReqOptionalParams.FindStringSubmatch(expanded)
+	if matches == nil {
+		return "", "", "", fmt.Errorf(
+			"query %s is not a table with optional query params, e.g. `/books?select=title` or `/archive/letters`",
+			q.Query,
+		)
+	}
+	return schema, matches[1], expanded, nil
+}
+
+// placeholder returns the placeholder of a saved query's param, e.g.
+// `{genre}`
+func placeholder(param string) string {
+	return "{" + param + "}"
+}
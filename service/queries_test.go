@@ -0,0 +1,131 @@
+package service_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopgrest/apperrors"
+	"gopgrest/assert"
+	"gopgrest/service"
+	"gopgrest/tests"
+)
+
+// savedQueries are saved queries on the test schema
+var savedQueries = map[string]service.SavedQuery{
+	"books_by_author": {
+		Query:  "/books?select=title,authors.surname:author&join=authors:books.author_id==authors.id&where=authors.surname=={surname}&order_by=title",
+		Params: map[string]string{"surname": "text"},
+	},
+	"authors_born_between": {
+		Query:  "/authors?select=surname&where=born=ge={from};born=lt={to}&order_by=born",
+		Params: map[string]string{"from": "int", "to": "int"},
+	},
+	"letters_by_title": {
+		Query:  "/archive/letters?select=title&where=title=={title}",
+		Params: map[string]string{"title": "text"},
+	},
+}
+
+func Test_ReadSavedQueries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.json")
+	data := `{"books_by_genre": {"query": "/books?where=genre_id=={genre}", "params": {"genre": "int"}}}`
+	assert.Try(t, os.WriteFile(path, []byte(data), 0o600))
+
+	queries, err := service.ReadSavedQueries(path)
+	assert.Try(t, err)
+	assert.IsEq(t, queries["books_by_genre"].Query, "/books?where=genre_id=={genre}")
+	assert.IsEq(t, queries["books_by_genre"].Params["genre"], "int")
+}
+
+func Test_ServiceSetSavedQueries(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		service := tests.NewTestService(t)
+		assert.Try(t, service.SetSavedQueries(savedQueries))
+		assert.IsEq(t, len(service.SavedQueries), 3)
+	})
+
+	invalid := []struct {
+		name  string
+		query service.SavedQuery
+	}{
+		{"unknown table", service.SavedQuery{Query: "/publishers?select=name"}},
+		{"unknown column", service.SavedQuery{Query: "/books?select=isbn"}},
+		{"unknown type", service.SavedQuery{
+			Query:  "/books?where=genre_id=={genre}",
+			Params: map[string]string{"genre": "money"},
+		}},
+		{"array type", service.SavedQuery{
+			Query:  "/books?where=genre_id=in={genres}",
+			Params: map[string]string{"genres": "int[]"},
+		}},
+		{"unused param", service.SavedQuery{
+			Query:  "/books?where=genre_id==1",
+			Params: map[string]string{"genre": "int"},
+		}},
+		{"param type doesn't match column", service.SavedQuery{
+			Query:  "/books?where=genre_id=={genre}",
+			Params: map[string]string{"genre": "text"},
+		}},
+		{"undeclared placeholder", service.SavedQuery{Query: "/books?where=title=={title}"}},
+		{"schema is not exposed", service.SavedQuery{Query: "/pg_catalog/pg_class?select=relname"}},
+		{"table not in schema", service.SavedQuery{Query: "/archive/books?select=title"}},
+		{"not a table path", service.SavedQuery{Query: "books?select=title"}},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			queries := map[string]service.SavedQuery{"q": c.query}
+			service := tests.NewTestService(t)
+			err := service.SetSavedQueries(queries)
+			assert.IsTrue(t, err != nil)
+			assert.IsEq(t, len(service.SavedQueries), 0)
+		})
+	}
+}
+
+func Test_ServiceGetSavedQuery(t *testing.T) {
+	service := tests.NewTestService(t)
+	assert.Try(t, service.SetSavedQueries(savedQueries))
+
+	t.Run("with params", func(t *testing.T) {
+		expRows, err := tests.SelectRows(service.Repo, "SELECT surname FROM authors WHERE born >= 1800 AND born < 1900 ORDER BY born")
+		assert.Try(t, err)
+		gotRows, err := service.GetSavedQuery("authors_born_between", map[string]string{"from": "1800", "to": "1900"})
+		assert.Try(t, err)
+		assert.Try(t, tests.CheckMapEquality(expRows, gotRows))
+	})
+
+	t.Run("value with separators", func(t *testing.T) {
+		gotRows, err := service.GetSavedQuery("books_by_author", map[string]string{"surname": "Woolf;title==x"})
+		assert.Try(t, err)
+		assert.IsEq(t, len(gotRows), 0)
+	})
+
+	t.Run("in another schema", func(t *testing.T) {
+		expRows, err := tests.SelectRows(service.Repo, "SELECT title FROM archive.letters WHERE title = 'Moral Letters to Lucilius'")
+		assert.Try(t, err)
+		gotRows, err := service.GetSavedQuery("letters_by_title", map[string]string{"title": "Moral Letters to Lucilius"})
+		assert.Try(t, err)
+		assert.Try(t, tests.CheckMapEquality(expRows, gotRows))
+	})
+
+	t.Run("unknown query", func(t *testing.T) {
+		_, err := service.GetSavedQuery("all_books", nil)
+		assert.ErrorsIs(t, err, apperrors.SavedQueryDoesNotExist)
+	})
+
+	invalid := []struct {
+		name   string
+		values map[string]string
+	}{
+		{"missing param", map[string]string{"from": "1800"}},
+		{"unknown param", map[string]string{"from": "1800", "to": "1900", "limit": "1"}},
+		{"invalid value", map[string]string{"from": "1800", "to": "soon"}},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := service.GetSavedQuery("authors_born_between", c.values)
+			assert.ErrorsIs(t, err, apperrors.InvalidSavedQueryParams)
+		})
+	}
+}
@@ -7,17 +7,22 @@ import (
 	"maps"
 	"slices"
 	"strconv"
+	"strings"
 
 	"gopgrest/apperrors"
-	"gopgrest/repatterns"
+	"gopgrest/pgtypes"
 	"gopgrest/repository"
 	"gopgrest/rsql"
 	"gopgrest/types"
 )
 
-// Service handles business logic with retrieved repository data
+// Service handles business logic with retrieved repository data. Decoder
+// decodes the values of queried rows for JSON output, and SavedQueries are
+// the named queries served by GetSavedQuery
 type Service struct {
-	Repo repository.Repository
+	Repo         repository.Repository
+	Decoder      pgtypes.Decoder
+	SavedQueries map[string]SavedQuery
 }
 
 // NewService returns a new Service struct
@@ -45,7 +50,7 @@ func (s *Service) GetRowByID(tableName, idAsStr string) (types.RowData, error) {
 	if err != nil {
 		return nil, err
 	}
-	queryResults, err := ScanRows(rows)
+	queryResults, err := scanRows(rows, s.Decoder)
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +88,7 @@ func (s *Service) GetRowsByRSQL(tableName string, url string) ([]types.RowData,
 	}()
 
 	// Scan rows into struct slice
-	queryResults, err := ScanRows(rows)
+	queryResults, err := scanRows(rows, s.Decoder)
 	if err != nil {
 		return nil, err
 	}
@@ -91,9 +96,18 @@ func (s *Service) GetRowsByRSQL(tableName string, url string) ([]types.RowData,
 	return queryResults, nil
 }
 
-// InsertRows inserts new rows in a specified table If multiple rows are
-// inserted, they must each have the same columns and value types
-func (s *Service) InsertRows(newRows []types.RowData, tableName string) ([]int64, error) {
+// Values of the `missing` preference, which sets how columns that are
+// missing from some rows of a multi-row insert are filled
+const (
+	MISSING_DEFAULT = "default"
+	MISSING_NULL    = "null"
+)
+
+// InsertRows inserts new rows in a specified table. If multiple rows are
+// inserted, they may each set different columns, and columns set by other rows
+// are filled with their DEFAULT, or with null if missing is MISSING_NULL. The
+// values of a column must have the same type in each row that sets it
+func (s *Service) InsertRows(newRows []types.RowData, tableName, missing string) ([]int64, error) {
 	var ids []int64
 	if len(newRows) == 0 {
 		return ids, apperrors.InsertWithNoRows
@@ -102,37 +116,48 @@ func (s *Service) InsertRows(newRows []types.RowData, tableName string) ([]int64
 	if err != nil {
 		return ids, err
 	}
+	if !table.Insertable {
+		return ids, writeNotAllowed(table, apperrors.TableIsNotInsertable)
+	}
 
 	// Each column in the insert data must exist in the table
-	cols := slices.Collect(maps.Keys(newRows[0]))
+	cols := []string{}
+	for _, row := range newRows {
+		for col := range row {
+			if !slices.Contains(cols, col) {
+				cols = append(cols, col)
+			}
+		}
+	}
 	badCol, err := verifyColumns(table, cols)
 	if err != nil {
 		return ids, fmt.Errorf("%w\n(%s:%s) ", err, table.Name, badCol)
 	}
 
-	// If there's only one row to insert, skip the remaining consistency checks
-	if len(newRows) == 1 {
-		return s.Repo.InsertRows(tableName, newRows)
+	// Fill missing columns with null rather than leaving them to be set to
+	// their DEFAULT
+	if missing == MISSING_NULL {
+		newRows = fillMissingColumns(newRows, cols)
 	}
 
-	// Compare cols and value types of other rows against first row
-	slices.Sort(cols)
-	valTypes := make(map[string]any, len(cols))
-	for _, col := range cols {
-		valTypes[col] = fmt.Sprintf("%T", newRows[0][col])
+	// Each value must be valid for its column's type and constraints
+	if err := validateRows(table, newRows, true); err != nil {
+		return ids, err
 	}
 
-	// Each row must have matching columns and value types
+	// Each column's values must have the same type in every row that sets it.
+	// Nulls, e.g. filled in for missing columns, match any type
+	valTypes := map[string]string{}
 	for _, row := range newRows {
-		// Compare cols
-		thisCols := slices.Collect(maps.Keys(row))
-		slices.Sort(thisCols)
-		if slices.Compare(cols, thisCols) != 0 {
-			return ids, fmt.Errorf("%w\n%v %v", apperrors.InsertColsDoNotMatch, cols, thisCols)
-		}
-		// Compare value types
 		for k, v := range row {
-			if valTypes[k] != fmt.Sprintf("%T", v) {
+			if v == nil {
+				continue
+			}
+			valType := fmt.Sprintf("%T", v)
+			if _, ok := valTypes[k]; !ok {
+				valTypes[k] = valType
+			}
+			if valTypes[k] != valType {
 				return ids, fmt.Errorf(
 					"%w\ncol: %s %v %v",
 					apperrors.InsertValTypesDoNotMatch,
@@ -144,6 +169,12 @@ func (s *Service) InsertRows(newRows []types.RowData, tableName string) ([]int64
 		}
 	}
 
+	// Encode values for the driver, e.g. arrays as array literals
+	newRows, err = encodeRows(table, newRows)
+	if err != nil {
+		return ids, err
+	}
+
 	insertedIDs, err := s.Repo.InsertRows(tableName, newRows)
 	if err != nil {
 		log.Println("Results:", insertedIDs)
@@ -159,8 +190,11 @@ func (s *Service) UpdateRowsByRSQL(tableName, url string, updateData *types.RowD
 	if err != nil {
 		return []int64{}, err
 	}
+	if !table.Updatable {
+		return []int64{}, writeNotAllowed(table, apperrors.TableIsNotUpdatable)
+	}
 
-	conditions, err := s.parseWhereClause(tableName, url)
+	query, err := s.parseWriteQuery(tableName, url)
 	if err != nil {
 		return []int64{}, err
 	}
@@ -171,6 +205,15 @@ func (s *Service) UpdateRowsByRSQL(tableName, url string, updateData *types.RowD
 	if err != nil {
 		return []int64{}, fmt.Errorf("%w (%s:%s) ", err, table.Name, badCol)
 	}
+	// Each value must be valid for its column's type and constraints
+	if err := validateRows(table, []types.RowData{*updateData}, false); err != nil {
+		return []int64{}, err
+	}
+	encoded, err := encodeRows(table, []types.RowData{*updateData})
+	if err != nil {
+		return []int64{}, err
+	}
+	updateData = &encoded[0]
 
 	// Decode request body into a dummy row value to validate column names
 	var dummyRow types.RowData
@@ -181,7 +224,7 @@ func (s *Service) UpdateRowsByRSQL(tableName, url string, updateData *types.RowD
 	}
 
 	// Update row
-	updatedIDs, err := s.Repo.UpdateRowsByRSQL(tableName, conditions, updateData)
+	updatedIDs, err := s.Repo.UpdateRowsByRSQL(tableName, query, updateData)
 	if err == nil {
 		log.Println("Results:", updatedIDs)
 	}
@@ -190,40 +233,235 @@ func (s *Service) UpdateRowsByRSQL(tableName, url string, updateData *types.RowD
 
 func (s *Service) DeleteRowsByRSQL(tableName, url string) ([]int64, error) {
 	// Get table info for verification
-	_, err := s.Repo.GetTable(tableName)
+	table, err := s.Repo.GetTable(tableName)
 	if err != nil {
 		return []int64{}, err
 	}
-	conditions, err := s.parseWhereClause(tableName, url)
+	if !table.Updatable {
+		return []int64{}, writeNotAllowed(table, apperrors.TableIsNotUpdatable)
+	}
+	query, err := s.parseWriteQuery(tableName, url)
 	if err != nil {
 		return []int64{}, err
 	}
-	deletedIDs, err := s.Repo.DeleteRowsByRSQL(tableName, conditions)
+	deletedIDs, err := s.Repo.DeleteRowsByRSQL(tableName, query)
 	if err != nil {
 		log.Println("Results:", deletedIDs)
 	}
 	return deletedIDs, err
 }
 
-// parsWhereClause parses and validates any 'WHERE' conditions found in a url
-func (s *Service) parseWhereClause(tableName, url string) ([]rsql.Condition, error) {
-	conditions := []rsql.Condition{}
-	if !repatterns.ReqHasParams.MatchString(url) {
-		return conditions, nil
+// RefreshMaterializedView refreshes a materialized view, optionally without
+// locking out concurrent reads
+func (s *Service) RefreshMaterializedView(tableName string, concurrently bool) error {
+	table, err := s.Repo.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+	if table.Kind != repository.MATVIEW {
+		return fmt.Errorf("%w (%s)", apperrors.NotMaterializedView, table.Name)
+	}
+	return s.Repo.RefreshMaterializedView(table.Name, concurrently)
+}
+
+// GetChangedRow gets the current row of a change event by its primary key,
+// the value of the table's single primary key column or an object of the
+// values of each of its columns, as decoded from the event's JSON payload
+func (s *Service) GetChangedRow(tableName string, pk any) (types.RowData, error) {
+	table, err := s.Repo.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	values := []string{}
+	switch len(table.PrimaryKey) {
+	case 0:
+		return nil, fmt.Errorf("table %s has no primary key", tableName)
+	case 1:
+		values = append(values, formatPK(pk))
+	default:
+		pkValues, ok := pk.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid primary key %v for table %s", pk, tableName)
+		}
+		for _, col := range table.PrimaryKey {
+			values = append(values, formatPK(pkValues[col]))
+		}
+	}
+	rows, err := s.Repo.GetRowByPK(tableName, table.PrimaryKey, values)
+	if err != nil {
+		return nil, err
+	}
+	queryResults, err := scanRows(rows, s.Decoder)
+	if err != nil {
+		return nil, err
+	}
+	// The row may have been deleted since the event
+	if len(queryResults) == 0 {
+		return nil, fmt.Errorf("no row in %s with primary key %v", tableName, pk)
+	}
+	return queryResults[0], nil
+}
+
+// formatPK formats a primary key value decoded from JSON, where numbers are
+// float64, as text that Postgres parses as the column's type
+func formatPK(pk any) string {
+	if f, ok := pk.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
 	}
+	return fmt.Sprintf("%v", pk)
+}
 
-	// Make new struct array from the query params
-	// Needs third element, i.e. second capture group
-	queryParams := repatterns.ReqHasParams.FindStringSubmatch(url)[2]
-	conditions, err := rsql.NewWhereConditions(queryParams)
+// GetChangeFilter parses and validates the optional 'where' conditions in a
+// url that are used to filter a table's change feed
+func (s *Service) GetChangeFilter(tableName, url string) ([]rsql.Condition, error) {
+	// Get table info for verification
+	_, err := s.Repo.GetTable(tableName)
 	if err != nil {
-		return []rsql.Condition{}, err
+		return nil, err
 	}
+	query, err := s.newRSQLQuery(url)
+	if err != nil {
+		return nil, err
+	}
+	// Change feed filters are evaluated in Go as conditions on the changed
+	// row that all must be true, so only `where` is supported
+	if clause := unsupportedChangeClause(query); clause != "" {
+		return nil, fmt.Errorf(
+			"%w: %s is not supported in change feed filters, use %s",
+			apperrors.InvalidChangeFilter,
+			clause,
+			rsql.WHERE,
+		)
+	}
+	// Change feed filters are evaluated in Go on the changed row, which doesn't
+	// implement jsonb containment, JSONPath, full-text search or subqueries,
+	// nor has columns of other tables
+	table := query.TableRefs()[0]
+	for _, cond := range query.Conditions {
+		if cond.Subquery != nil {
+			return nil, fmt.Errorf(
+				"%w: %s is not supported in change feed filters",
+				apperrors.InvalidSubquery,
+				cond.Operator,
+			)
+		}
+		if cond.Column.Qualifier != "" && cond.Column.Qualifier != table.Name {
+			return nil, fmt.Errorf(
+				"%w: column %s of another table is not supported in change feed filters",
+				apperrors.InvalidChangeFilter,
+				cond.Column,
+			)
+		}
+		dbType, err := s.conditionType(query.TableRefs(), cond)
+		if err != nil {
+			return nil, err
+		}
+		isArrayOp := strings.HasPrefix(dbType, "_") && slices.Contains(rsql.ArrayOperators, cond.SQLOperator)
+		if !isArrayOp && slices.Contains(rsql.JSONOperators, cond.SQLOperator) {
+			return nil, fmt.Errorf(
+				"%w: %s is not supported in change feed filters",
+				apperrors.InvalidJSONOperator,
+				cond.SQLOperator,
+			)
+		}
+		if cond.TextSearch != nil {
+			return nil, fmt.Errorf(
+				"%w: full-text search is not supported in change feed filters",
+				apperrors.InvalidTextSearch,
+			)
+		}
+	}
+	return query.Conditions, nil
+}
 
-	// Each col in query params must exist in given table
-	if err := s.ValidateRSQLConditions([]string{tableName}, conditions); err != nil {
-		return []rsql.Condition{}, err
+// unsupportedChangeClause returns the keyword of the first clause in a query
+// that a change feed filter can't honour, or "" if it has none
+func unsupportedChangeClause(query rsql.QueryParams) string {
+	switch {
+	case query.Filter != nil:
+		return rsql.FILTER
+	case len(query.Joins) > 0:
+		return rsql.JOIN
+	case len(query.Columns) > 0:
+		return rsql.SELECT
+	case query.Distinct:
+		return rsql.DISTINCT
+	case len(query.DistinctOn) > 0:
+		return rsql.DISTINCTON
+	case len(query.OrderBy) > 0:
+		return rsql.ORDERBY
+	case query.Limit >= 0:
+		return rsql.LIMIT
+	case query.Offset > 0:
+		return rsql.OFFSET
+	}
+	return ""
+}
+
+// parseWriteQuery parses and validates the 'WHERE' conditions and optional
+// joins found in the url of a PUT or DELETE request. Only inner joins are
+// supported, each on the id of its table, so that a row of the table being
+// changed matches at most one row of each joined table
+func (s *Service) parseWriteQuery(tableName, url string) (rsql.QueryParams, error) {
+	query, err := rsql.NewWriteQuery(url)
+	if err != nil {
+		return rsql.QueryParams{}, err
+	}
+	if len(query.Tables) == 0 {
+		query.Tables = []string{tableName}
+	}
+	for _, j := range query.Joins {
+		if j.Type != rsql.JoinTypes[rsql.JOIN] && j.Type != rsql.JoinTypes[rsql.INNERJOIN] {
+			return rsql.QueryParams{}, fmt.Errorf(
+				"%w: only %s and %s are supported in PUT and DELETE",
+				apperrors.InvalidJoin, rsql.JOIN, rsql.INNERJOIN,
+			)
+		}
+		onID := func(on rsql.JoinOn) bool {
+			return (on.Left.Qualifier == j.Name() && on.Left.Name == "id" && on.Right.Qualifier != j.Name()) ||
+				(on.Right.Qualifier == j.Name() && on.Right.Name == "id" && on.Left.Qualifier != j.Name())
+		}
+		if !slices.ContainsFunc(j.On, onID) {
+			return rsql.QueryParams{}, fmt.Errorf(
+				"%w: join %v must be on %s.id in PUT and DELETE, so that each row matches at most one of its rows",
+				apperrors.InvalidJoin, j, j.Name(),
+			)
+		}
 	}
 
-	return conditions, nil
+	tables := query.TableRefs()
+	if err := s.resolveJSONColumns(tables, queryColumns(query)); err != nil {
+		return rsql.QueryParams{}, err
+	}
+	if err := s.validateRSQLJoins(query); err != nil {
+		return rsql.QueryParams{}, err
+	}
+	// Each col in query params must exist in one of the query's tables
+	if err := s.ValidateRSQLConditions(tables, query.Conditions); err != nil {
+		return rsql.QueryParams{}, err
+	}
+	for _, j := range query.Joins {
+		if err := s.ValidateRSQLConditions(tables, j.Conditions); err != nil {
+			return rsql.QueryParams{}, err
+		}
+	}
+	// A column may be in both the table being changed and a joined table
+	if len(query.Joins) > 0 {
+		if err := s.qualifyColumns(tables, queryColumns(query)); err != nil {
+			return rsql.QueryParams{}, err
+		}
+	}
+	if err := s.validateJSONPaths(tables, queryColumns(query)); err != nil {
+		return rsql.QueryParams{}, err
+	}
+	// Parse condition values as their column's type
+	if err := s.coerceConditions(tables, query.Conditions); err != nil {
+		return rsql.QueryParams{}, err
+	}
+	for _, j := range query.Joins {
+		if err := s.coerceConditions(tables, j.Conditions); err != nil {
+			return rsql.QueryParams{}, err
+		}
+	}
+	return query, nil
 }
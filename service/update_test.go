@@ -33,3 +33,31 @@ func Test_ServiceUpdateRowsByRSQL(t *testing.T) {
 	err = tests.CheckMapEquality(expAuthors, gotRows)
 	assert.Try(t, err)
 }
+
+func Test_ServiceUpdateRowsByRSQL_Join(t *testing.T) {
+	service := tests.NewTestService(t)
+	expCount, err := tests.CountRows(service.Repo, "books", "JOIN authors ON books.author_id = authors.id WHERE surname = 'Woolf'")
+	assert.Try(t, err)
+
+	// Move each book by Woolf to genre 1
+	update := types.RowData{"genre_id": 1}
+	url := "/books?authors.surname==Woolf&join=authors:books.author_id==authors.id"
+	ids, err := service.UpdateRowsByRSQL("books", url, &update)
+	assert.Try(t, err)
+	assert.IsEq(t, len(ids), expCount)
+
+	gotCount, err := tests.CountRows(service.Repo, "books", "JOIN authors ON books.author_id = authors.id WHERE surname = 'Woolf' AND genre_id = 1")
+	assert.Try(t, err)
+	assert.IsEq(t, gotCount, expCount)
+}
+
+// Both books and authors have an id column, which is taken to be the books' id
+func Test_ServiceUpdateRowsByRSQL_JoinUnqualifiedColumn(t *testing.T) {
+	service := tests.NewTestService(t)
+	update := types.RowData{"genre_id": 1}
+	url := "/books?id==2&join=authors:books.author_id==authors.id"
+	ids, err := service.UpdateRowsByRSQL("books", url, &update)
+	assert.Try(t, err)
+	assert.IsEq(t, len(ids), 1)
+	assert.IsEq(t, ids[0], int64(2))
+}
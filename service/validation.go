@@ -0,0 +1,254 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopgrest/apperrors"
+	"gopgrest/repository"
+	"gopgrest/types"
+)
+
+// validateRows validates the values in rows to insert or update against the
+// types and constraints of their columns, reporting every invalid value at
+// once as an *apperrors.ValidationError. Columns that require a value are
+// only reported as missing on insert. Columns must already be verified to
+// exist
+func validateRows(t *repository.Table, rows []types.RowData, isInsert bool) error {
+	fieldErrs := []apperrors.FieldError{}
+	for i, row := range rows {
+		for _, col := range t.Columns {
+			val, ok := row[col.Name]
+			if !ok {
+				if isInsert && col.Constraints.Required() {
+					fieldErrs = append(fieldErrs, apperrors.FieldError{
+						Row: i, Column: col.Name, Message: "value is required",
+					})
+				}
+				continue
+			}
+			if msg := validateValue(col, val); msg != "" {
+				fieldErrs = append(fieldErrs, apperrors.FieldError{
+					Row: i, Column: col.Name, Message: msg,
+				})
+			}
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return &apperrors.ValidationError{Fields: fieldErrs}
+	}
+	return nil
+}
+
+// validateValue returns why a value is invalid for a column, or an empty
+// string if it is valid
+func validateValue(col repository.TableColumn, val any) string {
+	if val == nil {
+		if col.Constraints.NotNull {
+			return "must not be null"
+		}
+		return ""
+	}
+	if msg := validateType(col.DBType, col.Constraints, val); msg != "" {
+		return msg
+	}
+	return ""
+}
+
+// validateType returns why a value is invalid for a type, or an empty string
+// if it is valid. Values of unknown types are left for Postgres to validate
+func validateType(dbType string, c repository.ColumnConstraints, val any) string {
+	if elemType, isArray := strings.CutPrefix(dbType, "_"); isArray {
+		return validateArray(elemType, c, val)
+	}
+	// The driver doesn't know enum types, so arrays of enums aren't prefixed
+	if len(c.EnumLabels) > 0 {
+		if reflect.ValueOf(val).Kind() == reflect.Slice {
+			return validateArray(dbType, c, val)
+		}
+		s, ok := val.(string)
+		if !ok || !slices.Contains(c.EnumLabels, s) {
+			return fmt.Sprintf("must be one of %s", strings.Join(c.EnumLabels, ", "))
+		}
+		return ""
+	}
+
+	switch dbType {
+	case "INT2", "INT4", "INT8":
+		i, ok := toInt(val)
+		if !ok {
+			return fmt.Sprintf("expected integer, got %s", jsonTypeName(val))
+		}
+		bitSize := map[string]int{"INT2": 16, "INT4": 32, "INT8": 64}[dbType]
+		limit := int64(1) << (bitSize - 1)
+		if bitSize < 64 && (i < -limit || i >= limit) {
+			return fmt.Sprintf("%d is out of range for %s", i, sqlTypeName(dbType))
+		}
+	case "FLOAT4", "FLOAT8":
+		if _, ok := toFloat(val); !ok {
+			return fmt.Sprintf("expected number, got %s", jsonTypeName(val))
+		}
+	case "NUMERIC":
+		return validateNumeric(c, val)
+	case "BOOL":
+		if _, ok := val.(bool); !ok {
+			return fmt.Sprintf("expected boolean, got %s", jsonTypeName(val))
+		}
+	case "TEXT", "VARCHAR", "BPCHAR":
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Sprintf("expected string, got %s", jsonTypeName(val))
+		}
+		if c.MaxLength > 0 && utf8.RuneCountInString(s) > c.MaxLength {
+			return fmt.Sprintf("exceeds maximum length of %d characters", c.MaxLength)
+		}
+	case "DATE", "TIME", "TIMESTAMP", "TIMESTAMPTZ", "UUID":
+		if _, ok := val.(time.Time); ok && dbType != "UUID" {
+			return ""
+		}
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Sprintf("expected string, got %s", jsonTypeName(val))
+		}
+		if _, err := parseValue(dbType, s); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// validateArray validates each element of an array value. Strings are left
+// for Postgres to parse as array literals, e.g. `{a,b}`
+func validateArray(elemType string, c repository.ColumnConstraints, val any) string {
+	if _, ok := val.(string); ok {
+		return ""
+	}
+	v := reflect.ValueOf(val)
+	if v.Kind() != reflect.Slice {
+		return fmt.Sprintf("expected array, got %s", jsonTypeName(val))
+	}
+	for i := range v.Len() {
+		elem := v.Index(i).Interface()
+		if elem == nil {
+			continue
+		}
+		if msg := validateType(elemType, c, elem); msg != "" {
+			return fmt.Sprintf("element %d: %s", i, msg)
+		}
+	}
+	return ""
+}
+
+// validateNumeric validates a number or numeric string against the precision
+// and scale of a numeric column. Extra fractional digits are rounded by
+// Postgres, so only the integer digits are limited
+func validateNumeric(c repository.ColumnConstraints, val any) string {
+	var digits string
+	if n, ok := val.(json.Number); ok {
+		digits = n.String()
+	} else if s, ok := val.(string); ok {
+		if _, err := parseValue("NUMERIC", s); err != nil {
+			return err.Error()
+		}
+		digits = s
+	} else if f, ok := toFloat(val); ok {
+		digits = strconv.FormatFloat(f, 'f', -1, 64)
+	} else {
+		return fmt.Sprintf("expected number, got %s", jsonTypeName(val))
+	}
+
+	if c.Precision == 0 || digits == "NaN" {
+		return ""
+	}
+	// Normalize exponents in numeric strings, e.g. `1e5`
+	if strings.ContainsAny(digits, "eE") {
+		f, _ := strconv.ParseFloat(digits, 64)
+		digits = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	intPart, _, _ := strings.Cut(strings.TrimLeft(digits, "+-"), ".")
+	intPart = strings.TrimLeft(intPart, "0")
+	if maxDigits := c.Precision - c.Scale; len(intPart) > maxDigits {
+		return fmt.Sprintf(
+			"exceeds numeric(%d,%d), at most %d digits before the decimal point",
+			c.Precision, c.Scale, maxDigits,
+		)
+	}
+	return ""
+}
+
+// toInt converts integers and whole numbers, e.g. numbers decoded from JSON
+// as float64 or json.Number, to int64
+func toInt(val any) (int64, bool) {
+	switch v := reflect.ValueOf(val); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	// Whole numbers may be written with a fraction or exponent, e.g. `1.0`
+	if n, ok := val.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i, true
+		}
+		if f, err := n.Float64(); err == nil {
+			return toInt(f)
+		}
+	}
+	return 0, false
+}
+
+// toFloat converts any number, including a numeric string, to float64
+func toFloat(val any) (float64, bool) {
+	switch v := reflect.ValueOf(val); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		if !reNumeric.MatchString(v.String()) {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// jsonTypeName returns the JSON type of a value for error messages
+func jsonTypeName(val any) string {
+	if _, ok := val.(json.Number); ok {
+		return "number"
+	}
+	switch reflect.ValueOf(val).Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	if _, ok := toFloat(val); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", val)
+}
@@ -0,0 +1,96 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"gopgrest/apperrors"
+	"gopgrest/assert"
+	"gopgrest/service"
+	"gopgrest/tests"
+	"gopgrest/types"
+)
+
+func Test_ValidateRows(t *testing.T) {
+	s := tests.NewTestService(t)
+
+	cases := []struct {
+		name   string
+		row    types.RowData
+		column string
+	}{
+		{"string for integer", types.RowData{"book_id": "one"}, "book_id"},
+		{"fraction for integer", types.RowData{"book_id": 1.5}, "book_id"},
+		{"integer out of range", types.RowData{"book_id": float64(1 << 40)}, "book_id"},
+		{"null for not null", types.RowData{"book_id": 1.0, "in_print": nil}, "in_print"},
+		{"string for boolean", types.RowData{"book_id": 1.0, "in_print": "yes"}, "in_print"},
+		{"invalid date", types.RowData{"book_id": 1.0, "published": "last year"}, "published"},
+		{"invalid uuid", types.RowData{"book_id": 1.0, "ref": "abc"}, "ref"},
+		{"numeric precision", types.RowData{"book_id": 1.0, "price": 12345.5}, "price"},
+		{"numeric string precision", types.RowData{"book_id": 1.0, "price": "1e4"}, "price"},
+		{"enum label", types.RowData{"book_id": 1.0, "cover": "spiral"}, "cover"},
+		{"max length", types.RowData{"book_id": 1.0, "note": "more than ten"}, "note"},
+		{"array element", types.RowData{"book_id": 1.0, "formats": []any{"ebook", 1.0}}, "formats"},
+		{"missing required", types.RowData{"published": "1998-01-01"}, "book_id"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := s.InsertRows([]types.RowData{c.row}, "editions", service.MISSING_DEFAULT)
+			assert.ErrorsIs(t, err, apperrors.InvalidRequestBody)
+
+			var validationErr *apperrors.ValidationError
+			assert.IsTrue(t, errors.As(err, &validationErr))
+			assert.IsEq(t, len(validationErr.Fields), 1)
+			assert.IsEq(t, validationErr.Fields[0].Column, c.column)
+		})
+	}
+}
+
+func Test_ValidateRows_AllErrors(t *testing.T) {
+	s := tests.NewTestService(t)
+	rows := []types.RowData{
+		{"book_id": 1.0, "price": "cheap", "cover": "paperback"},
+		{"book_id": "two", "price": 9.99, "cover": "spiral"},
+	}
+
+	_, err := s.InsertRows(rows, "editions", service.MISSING_DEFAULT)
+	var validationErr *apperrors.ValidationError
+	assert.IsTrue(t, errors.As(err, &validationErr))
+
+	exp := []apperrors.FieldError{
+		{Row: 0, Column: "price"},
+		{Row: 1, Column: "book_id"},
+		{Row: 1, Column: "cover"},
+	}
+	assert.IsEq(t, len(validationErr.Fields), len(exp))
+	for i, fieldErr := range exp {
+		assert.IsEq(t, validationErr.Fields[i].Row, fieldErr.Row)
+		assert.IsEq(t, validationErr.Fields[i].Column, fieldErr.Column)
+	}
+}
+
+func Test_ValidateRows_Update(t *testing.T) {
+	s := tests.NewTestService(t)
+
+	// Required columns may be left out of updates
+	_, err := s.UpdateRowsByRSQL("editions", "/editions?id==1", &types.RowData{"in_print": nil})
+	var validationErr *apperrors.ValidationError
+	assert.IsTrue(t, errors.As(err, &validationErr))
+	assert.IsEq(t, len(validationErr.Fields), 1)
+	assert.IsEq(t, validationErr.Fields[0].Column, "in_print")
+}
+
+// CHECK constraints are left for Postgres to enforce. Each violation aborts
+// the test's transaction, so each uses its own service
+func Test_ValidateRows_CheckViolation(t *testing.T) {
+	t.Run("insert", func(t *testing.T) {
+		s := tests.NewTestService(t)
+		_, err := s.InsertRows([]types.RowData{{"book_id": 1.0, "price": -1.0}}, "editions", service.MISSING_DEFAULT)
+		assert.ErrorsIs(t, err, apperrors.CheckViolation)
+	})
+	t.Run("update", func(t *testing.T) {
+		s := tests.NewTestService(t)
+		_, err := s.UpdateRowsByRSQL("editions", "/editions?where=id==1", &types.RowData{"price": -5.0})
+		assert.ErrorsIs(t, err, apperrors.CheckViolation)
+	})
+}
@@ -0,0 +1,93 @@
+package sqlbuilder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Builder builds a SQL statement and the values for its placeholders.
+// Identifiers are always quoted and values are always passed as placeholders,
+// so names and values from a request are never spliced into the statement
+type Builder struct {
+	sql    strings.Builder
+	values []any
+}
+
+// New returns an empty Builder
+func New() *Builder {
+	return &Builder{values: []any{}}
+}
+
+// Write appends SQL keywords or punctuation to the statement. It must never
+// be passed names or values from a request
+func (b *Builder) Write(sql ...string) *Builder {
+	for _, s := range sql {
+		b.sql.WriteString(s)
+	}
+	return b
+}
+
+// Ident appends an identifier, qualified by any preceding names, e.g.
+// Ident("public", "authors") appends `"public"."authors"`
+func (b *Builder) Ident(names ...string) *Builder {
+	b.sql.WriteString(QuoteIdent(names...))
+	return b
+}
+
+// Value appends a placeholder for a value, e.g. `$3`
+func (b *Builder) Value(v any) *Builder {
+	b.values = append(b.values, v)
+	fmt.Fprintf(&b.sql, "$%d", len(b.values))
+	return b
+}
+
+// ValueList appends a comma separated list of placeholders, e.g. `$1,$2`
+func (b *Builder) ValueList(values []any) *Builder {
+	for i, v := range values {
+		if i > 0 {
+			b.sql.WriteString(",")
+		}
+		b.Value(v)
+	}
+	return b
+}
+
+// Join appends the SQL written by each function, separated by sep, e.g.
+//
+//	b.Join(", ", len(cols), func(i int) { b.Ident(cols[i]) })
+func (b *Builder) Join(sep string, n int, write func(i int)) *Builder {
+	for i := range n {
+		if i > 0 {
+			b.sql.WriteString(sep)
+		}
+		write(i)
+	}
+	return b
+}
+
+// Len is the length of the statement so far
+func (b *Builder) Len() int {
+	return b.sql.Len()
+}
+
+// String returns the statement
+func (b *Builder) String() string {
+	return b.sql.String()
+}
+
+// Values returns the values for the statement's placeholders in order
+func (b *Builder) Values() []any {
+	return b.values
+}
+
+// QuoteIdent quotes each name as an identifier and joins them with `.`, e.g.
+// QuoteIdent("authors", "surname") returns `"authors"."surname"`
+func QuoteIdent(names ...string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = pq.QuoteIdentifier(name)
+	}
+	return strings.Join(quoted, ".")
+}
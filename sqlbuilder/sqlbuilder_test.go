@@ -0,0 +1,54 @@
+package sqlbuilder_test
+
+import (
+	"slices"
+	"testing"
+
+	"gopgrest/assert"
+	"gopgrest/sqlbuilder"
+)
+
+func Test_QuoteIdent(t *testing.T) {
+	cases := []struct {
+		name  string
+		names []string
+		exp   string
+	}{
+		{"lowercase", []string{"authors"}, `"authors"`},
+		{"mixed case", []string{"DisplayName"}, `"DisplayName"`},
+		{"reserved word", []string{"user"}, `"user"`},
+		{"non-ASCII", []string{"éditeurs"}, `"éditeurs"`},
+		{"qualified", []string{"public", "authors", "surname"}, `"public"."authors"."surname"`},
+		{"embedded quote", []string{`a" FROM authors; --`}, `"a"" FROM authors; --"`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.IsEq(t, sqlbuilder.QuoteIdent(c.names...), c.exp)
+		})
+	}
+}
+
+func Test_Builder(t *testing.T) {
+	t.Run("placeholders are numbered in order", func(t *testing.T) {
+		b := sqlbuilder.New()
+		b.Write("SELECT * FROM ").Ident("public", "authors").
+			Write(" WHERE ").Ident("surname").Write(" IN (").ValueList([]any{"Carson", "Woolf"}).Write(")").
+			Write(" LIMIT ").Value(1)
+
+		assert.IsEq(t, b.String(), `SELECT * FROM "public"."authors" WHERE "surname" IN ($1,$2) LIMIT $3`)
+		assert.IsTrue(t, slices.Equal(b.Values(), []any{"Carson", "Woolf", 1}))
+	})
+
+	t.Run("join", func(t *testing.T) {
+		cols := []string{"forename", "order"}
+		b := sqlbuilder.New()
+		b.Join(", ", len(cols), func(i int) { b.Ident(cols[i]) })
+		assert.IsEq(t, b.String(), `"forename", "order"`)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		b := sqlbuilder.New()
+		assert.IsEq(t, b.Len(), 0)
+		assert.IsEq(t, len(b.Values()), 0)
+	})
+}
@@ -1,11 +1,15 @@
 package tests
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -53,7 +57,7 @@ func CheckMapEquality(expRows, gotRows []types.RowData) error {
 			if !ok {
 				return fmt.Errorf("Expected key %s in row %v", k, gotRow)
 			}
-			if gotVal != expVal {
+			if !reflect.DeepEqual(gotVal, expVal) {
 				return fmt.Errorf(
 					"\nExpected %s: %v (type %T)\nGot: %v (type %T)",
 					k,
@@ -112,3 +116,71 @@ func ParseIDArrayResponse(t *testing.T, resp string) []int64 {
 	}
 	return ids
 }
+
+// DialWebSocket opens a WebSocket connection to a test server and completes
+// the opening handshake
+func DialWebSocket(t *testing.T, serverURL, path string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	assert.Try(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	reader := bufio.NewReader(conn)
+
+	_, err = fmt.Fprintf(conn, "GET %s HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", path)
+	assert.Try(t, err)
+
+	resp, err := http.ReadResponse(reader, nil)
+	assert.Try(t, err)
+	assert.IsEq(t, resp.StatusCode, http.StatusSwitchingProtocols)
+	return conn, reader
+}
+
+// WriteWebSocketFrame writes a single masked frame, as a client would
+func WriteWebSocketFrame(t *testing.T, conn net.Conn, opcode byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0,
+			byte(len(payload)>>24), byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload)))
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := conn.Write(frame)
+	assert.Try(t, err)
+}
+
+// ReadWebSocketFrame reads the payload of a single unmasked frame sent by the
+// server
+func ReadWebSocketFrame(t *testing.T, r io.Reader) []byte {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(r, header)
+	assert.Try(t, err)
+	length := int(header[1] & 0x7F)
+	extLen := map[int]int{126: 2, 127: 8}[length]
+	if extLen > 0 {
+		ext := make([]byte, extLen)
+		_, err = io.ReadFull(r, ext)
+		assert.Try(t, err)
+		length = 0
+		for _, b := range ext {
+			length = length<<8 | int(b)
+		}
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	assert.Try(t, err)
+	return payload
+}
@@ -13,6 +13,9 @@ import (
 	"gopgrest/service"
 )
 
+// SCHEMAS are the schemas in the test database exposed to the tests
+var SCHEMAS = []string{"public", "archive"}
+
 var (
 	host       = os.Getenv("HOST")
 	port       = os.Getenv("TEST_DB_PORT")
@@ -22,9 +25,10 @@ var (
 )
 
 type TestDB struct {
-	DB     *sql.DB
-	TX     *sql.Tx
-	Tables []repository.Table
+	DB        *sql.DB
+	TX        *sql.Tx
+	Tables    []repository.Table
+	Functions []repository.Function
 }
 
 // NewTestDB returns a test database
@@ -40,7 +44,14 @@ func NewTestDB(t *testing.T) *TestDB {
 
 	// Get tables from database here rather than from a Tx later, which won't
 	// return the column names
-	tables, err := repository.GetPublicTables(db)
+	tables, err := repository.GetTables(db, SCHEMAS)
+	if err != nil {
+		t.Fatalf("could not get tables: %v", err)
+	}
+	functions, err := repository.GetFunctions(db, SCHEMAS)
+	if err != nil {
+		t.Fatalf("could not get functions: %v", err)
+	}
 
 	t.Cleanup(func() {
 		db.Close()
@@ -50,6 +61,7 @@ func NewTestDB(t *testing.T) *TestDB {
 		db,
 		nil,
 		tables,
+		functions,
 	}
 }
 
@@ -71,7 +83,7 @@ func (tdb *TestDB) BeginTX(t *testing.T) *sql.Tx {
 func NewTestRepo(t *testing.T) repository.Repository {
 	tdb := NewTestDB(t)
 	tx := tdb.BeginTX(t)
-	repo := repository.NewRepository(tx, tdb.Tables)
+	repo := repository.NewRepository(tx, tdb.Tables, tdb.Functions, SCHEMAS)
 	return repo
 }
 
@@ -87,6 +99,6 @@ func NewTestService(t *testing.T) service.Service {
 func NewTestAPIHandler(t *testing.T) api.APIHandler {
 	tdb := NewTestDB(t)
 	tx := tdb.BeginTX(t)
-	h := api.NewAPIHandler(tx, tdb.Tables)
+	h := api.NewAPIHandler(tx, tdb.Tables, tdb.Functions, SCHEMAS)
 	return h
 }
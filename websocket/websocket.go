@@ -0,0 +1,274 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The minimal subset of RFC 6455 needed for a JSON messaging server: text
+// and binary messages, fragmentation, ping/pong and close. Extensions and
+// subprotocols are not supported.
+
+// handshakeGUID is appended to the client's key to compute the accept key
+const handshakeGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MAX_MESSAGE_SIZE is the largest message the server will read
+const MAX_MESSAGE_SIZE = 1 << 20
+
+// WRITE_TIMEOUT is how long a frame write may block on a client that isn't
+// reading before the connection is closed
+const WRITE_TIMEOUT = 10 * time.Second
+
+// Frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+var (
+	NotAWebSocketRequest = errors.New("Request is not a WebSocket upgrade")
+	MessageTooLarge      = errors.New("WebSocket message too large")
+	UnmaskedClientFrame  = errors.New("WebSocket client frame is not masked")
+)
+
+// Conn is a server side WebSocket connection. Reads must happen from a single
+// goroutine, writes may happen from any goroutine. A write that fails or times
+// out closes the connection, so a client that stops reading can't hold up
+// other writers for longer than WRITE_TIMEOUT
+type Conn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	wmu  sync.Mutex
+}
+
+// Upgrade completes the WebSocket opening handshake and takes over the
+// request's underlying connection
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, NotAWebSocketRequest
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("unsupported WebSocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("connection does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, rw: rw}, nil
+}
+
+// CheckOrigin reports whether a request may be upgraded from its Origin
+// header. Requests without an Origin, i.e. not from a browser, are allowed, as
+// are origins with the request's host or in the allowed list, where `*`
+// allows any origin
+func CheckOrigin(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(allowedOrigins, "*") || slices.Contains(allowedOrigins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a client's
+// Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + handshakeGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// ReadMessage reads the next text or binary message, answering pings along
+// the way. Returns io.EOF once the client has closed the connection
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// Echo the close frame to complete the closing handshake
+			c.writeFrame(opClose, payload)
+			return nil, io.EOF
+		}
+
+		message = append(message, payload...)
+		if len(message) > MAX_MESSAGE_SIZE {
+			return nil, MessageTooLarge
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// WriteMessage sends a text message
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// WriteJSON sends a value encoded as JSON in a text message
+func (c *Conn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(data)
+}
+
+// Close sends a close frame and closes the underlying connection
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, []byte{})
+	return c.conn.Close()
+}
+
+// readFrame reads a single frame and unmasks its payload
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.rw, header); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.rw, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.rw, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > MAX_MESSAGE_SIZE {
+		return false, 0, nil, MessageTooLarge
+	}
+	// Clients must mask every frame they send
+	if !masked {
+		return false, 0, nil, UnmaskedClientFrame
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.rw, mask); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes a single unfragmented, unmasked frame, closing the
+// connection if the write fails or times out
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	err := c.writeFrameLocked(opcode, payload)
+	if err != nil {
+		c.conn.Close()
+	}
+	return err
+}
+
+// writeFrameLocked writes a frame while holding the write lock
+func (c *Conn) writeFrameLocked(opcode byte, payload []byte) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT)); err != nil {
+		return err
+	}
+
+	header := []byte{0x80 | opcode}
+	length := len(payload)
+	switch {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// headerContains reports whether a comma separated header contains a token,
+// ignoring case
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for v := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
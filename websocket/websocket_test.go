@@ -0,0 +1,80 @@
+package websocket_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopgrest/assert"
+	"gopgrest/tests"
+	"gopgrest/websocket"
+)
+
+func Test_AcceptKey(t *testing.T) {
+	// Example from RFC 6455 section 1.3
+	assert.IsEq(t, websocket.AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+}
+
+func Test_Upgrade_NotWebSocket(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/_changes", nil)
+	_, err := websocket.Upgrade(httptest.NewRecorder(), req)
+	assert.ErrorsIs(t, err, websocket.NotAWebSocketRequest)
+}
+
+func Test_CheckOrigin(t *testing.T) {
+	cases := []struct {
+		name    string
+		origin  string
+		allowed []string
+		exp     bool
+	}{
+		{"no origin", "", nil, true},
+		{"same host", "http://example.com", nil, true},
+		{"other host", "http://evil.example", nil, false},
+		{"allowed origin", "https://app.example", []string{"https://app.example"}, true},
+		{"other origin with allow-list", "https://evil.example", []string{"https://app.example"}, false},
+		{"any origin", "https://evil.example", []string{"*"}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/_changes", nil)
+			if c.origin != "" {
+				req.Header.Set("Origin", c.origin)
+			}
+			assert.IsEq(t, websocket.CheckOrigin(req, c.allowed), c.exp)
+		})
+	}
+}
+
+func Test_EchoMessages(t *testing.T) {
+	// Server echoes each message back until the client closes
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(msg)
+		}
+	}))
+	defer server.Close()
+
+	conn, reader := tests.DialWebSocket(t, server.URL, "/")
+
+	// Payload lengths that use each of the three length encodings
+	for _, msg := range []string{"hello", strings.Repeat("x", 300), strings.Repeat("y", 70000)} {
+		tests.WriteWebSocketFrame(t, conn, 0x1, []byte(msg))
+		got := tests.ReadWebSocketFrame(t, reader)
+		assert.IsEq(t, string(got), msg)
+	}
+
+	// Pings are answered with a pong carrying the same payload
+	tests.WriteWebSocketFrame(t, conn, 0x9, []byte("ping"))
+	assert.IsEq(t, string(tests.ReadWebSocketFrame(t, reader)), "ping")
+}